@@ -0,0 +1,203 @@
+// Copyright 2026 k8s-enc-image-operator authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package aeswrap handles keys wrapped with a local AES key-encryption key
+// (KEK), for clusters that cannot reach a KMS. Multiple KEK versions are
+// identified by key ID, so that KEKs can be rotated.
+package aeswrap
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+
+	"github.com/lumjjb/k8s-enc-image-operator/keysync/sechandlers"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// SecretType is the type of the secrets holding AES wrapped keys
+	SecretType = "aes-wrapped-key"
+
+	// AlgGCM wraps keys with AES-GCM, the key ID and the filename are
+	// authenticated as additional data
+	AlgGCM = "aes-gcm"
+
+	// AlgKWP wraps keys with AES key wrap with padding (RFC 5649)
+	AlgKWP = "aes-kwp"
+
+	// DefaultFilename is the name of the key file if the secret does not
+	// specify one
+	DefaultFilename = "aeskey"
+)
+
+// Secret data fields of AES wrapped keys
+const (
+	// KidField is the ID of the KEK the key is wrapped with
+	KidField = "kid"
+
+	// AlgField is the algorithm the key is wrapped with, AlgGCM if not set
+	AlgField = "alg"
+
+	// CiphertextField is the wrapped key, for AlgGCM the nonce followed by
+	// the ciphertext
+	CiphertextField = "ciphertext"
+
+	// FilenameField is the name of the key file, DefaultFilename if not set.
+	// It must be a valid secret data key, and is only authenticated with
+	// AlgGCM.
+	FilenameField = "filename"
+)
+
+// NewAESSecretKeyHandler returns a secret handler unwrapping keys with the
+// KEKs, which are mapped by key ID
+func NewAESSecretKeyHandler(keks map[string][]byte) sechandlers.SecretKeyHandler {
+	return func(data map[string][]byte) (map[string][]byte, error) {
+		kid, ok := data[KidField]
+		if !ok {
+			return nil, errors.New("kid not in secret")
+		}
+
+		ciphertext, ok := data[CiphertextField]
+		if !ok {
+			return nil, errors.New("ciphertext not in secret")
+		}
+
+		kek, ok := keks[string(kid)]
+		if !ok {
+			return nil, errors.Errorf("KEK %q not available", kid)
+		}
+
+		alg := AlgGCM
+		if a, ok := data[AlgField]; ok {
+			alg = string(a)
+		}
+
+		filename := DefaultFilename
+		if f, ok := data[FilenameField]; ok && len(f) > 0 {
+			filename = string(f)
+			if err := sechandlers.ValidateFilename(filename); err != nil {
+				return nil, err
+			}
+		}
+
+		key, err := unwrap(alg, kek, additionalData(string(kid), string(data[FilenameField])), ciphertext)
+		if err != nil {
+			return nil, err
+		}
+
+		return map[string][]byte{filename: key}, nil
+	}
+}
+
+// NewAESSecretKeyWrapper returns a secret key wrapper wrapping keys with the KEK
+// of the key ID and the algorithm, returning the secret data expected by the
+// AES secret key handler. The filename is stored with the key if not empty.
+func NewAESSecretKeyWrapper(kid string, kek []byte, alg, filename string) (sechandlers.SecretKeyWrapper, error) {
+	if kid == "" {
+		return nil, errors.New("kid required to wrap keys")
+	}
+	if alg != AlgGCM && alg != AlgKWP {
+		return nil, errors.Errorf("unsupported algorithm %q", alg)
+	}
+	if _, err := aes.NewCipher(kek); err != nil {
+		return nil, errors.Wrap(err, "invalid KEK")
+	}
+	if filename != "" {
+		if err := sechandlers.ValidateFilename(filename); err != nil {
+			return nil, err
+		}
+	}
+
+	return func(key []byte) (map[string][]byte, error) {
+		ciphertext, err := wrap(alg, kek, additionalData(kid, filename), key)
+		if err != nil {
+			return nil, err
+		}
+
+		data := map[string][]byte{
+			KidField:        []byte(kid),
+			AlgField:        []byte(alg),
+			CiphertextField: ciphertext,
+		}
+		if filename != "" {
+			data[FilenameField] = []byte(filename)
+		}
+		return data, nil
+	}, nil
+}
+
+// additionalData returns the additional data authenticated with AlgGCM, the
+// key ID, followed by a NUL and the filename if the secret names the key file
+func additionalData(kid, filename string) []byte {
+	if filename == "" {
+		return []byte(kid)
+	}
+	return []byte(kid + "\x00" + filename)
+}
+
+// wrap wraps the key with the KEK and the algorithm, authenticating the
+// additional data if the algorithm supports it
+func wrap(alg string, kek, ad, key []byte) ([]byte, error) {
+	switch alg {
+	case AlgGCM:
+		aead, err := newGCM(kek)
+		if err != nil {
+			return nil, err
+		}
+		nonce := make([]byte, aead.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return nil, err
+		}
+		return aead.Seal(nonce, nonce, key, ad), nil
+	case AlgKWP:
+		return wrapKWP(kek, key)
+	default:
+		return nil, errors.Errorf("unsupported algorithm %q", alg)
+	}
+}
+
+// unwrap unwraps the key with the KEK and the algorithm, authenticating the
+// additional data if the algorithm supports it
+func unwrap(alg string, kek, ad, ciphertext []byte) ([]byte, error) {
+	switch alg {
+	case AlgGCM:
+		aead, err := newGCM(kek)
+		if err != nil {
+			return nil, err
+		}
+		if len(ciphertext) < aead.NonceSize() {
+			return nil, errors.New("ciphertext too short")
+		}
+		key, err := aead.Open(nil, ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():], ad)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to unwrap key")
+		}
+		return key, nil
+	case AlgKWP:
+		return unwrapKWP(kek, ciphertext)
+	default:
+		return nil, errors.Errorf("unsupported algorithm %q", alg)
+	}
+}
+
+// newGCM returns AES-GCM with the KEK
+func newGCM(kek []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,117 @@
+// Copyright 2026 k8s-enc-image-operator authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aeswrap
+
+import (
+	"context"
+	"crypto/aes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/lumjjb/k8s-enc-image-operator/keysync/sechandlers"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// KEKLoader is a function type that loads the KEKs mapped by key ID
+type KEKLoader func() (map[string][]byte, error)
+
+// LoadKEKDir loads the KEKs from the files of the directory, i.e. a mounted
+// secret, each file holding a raw 16, 24 or 32 byte KEK named by its key ID
+func LoadKEKDir(dir string) (map[string][]byte, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	data := map[string][]byte{}
+	for _, e := range entries {
+		// Mounted secrets contain hidden ..data links and directories
+		if strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		kek, err := os.ReadFile(filepath.Join(dir, filepath.Clean(e.Name())))
+		if err != nil {
+			return nil, err
+		}
+		data[e.Name()] = kek
+	}
+
+	return keksFromData(data)
+}
+
+// KubeSecretKEKLoader returns a loader of the KEKs from the data of the kube
+// secret, each entry holding a raw 16, 24 or 32 byte KEK named by its key ID
+func KubeSecretKEKLoader(clientset kubernetes.Interface, namespace, secretName string) KEKLoader {
+	return func() (map[string][]byte, error) {
+		s, err := clientset.CoreV1().Secrets(namespace).Get(context.Background(), secretName, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		return keksFromData(s.Data)
+	}
+}
+
+// keksFromData validates the KEKs mapped by key ID
+func keksFromData(data map[string][]byte) (map[string][]byte, error) {
+	if len(data) == 0 {
+		return nil, errors.New("no KEKs found")
+	}
+	for kid, kek := range data {
+		if _, err := aes.NewCipher(kek); err != nil {
+			return nil, errors.Wrapf(err, "invalid KEK %q", kid)
+		}
+	}
+	return data, nil
+}
+
+// KEKThread is a helper function that loads the KEKs and adds the AES handler
+// to the server, and again whenever the KEKs change, i.e. when a new KEK
+// version is added for rotation. Meant to run as a thread.
+func KEKThread(load KEKLoader, adder sechandlers.SecretKeyHandlerAdder, interval time.Duration) {
+	first := true
+	var oldKEKs map[string][]byte
+	for {
+		if !first {
+			<-time.After(interval)
+		} else {
+			first = false
+		}
+
+		keks, err := load()
+		if err != nil {
+			logrus.Errorf("Unable to load AES KEKs: %v", err)
+			continue
+		}
+
+		if reflect.DeepEqual(keks, oldKEKs) {
+			continue
+		}
+		oldKEKs = keks
+
+		kids := []string{}
+		for kid := range keks {
+			kids = append(kids, kid)
+		}
+		logrus.Printf("New AES KEKs detected, configuring key IDs %v...", kids)
+		adder.AddSecretKeyHandler(SecretType, NewAESSecretKeyHandler(keks))
+	}
+}
@@ -0,0 +1,108 @@
+// Copyright 2026 k8s-enc-image-operator authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aeswrap
+
+import (
+	"crypto/aes"
+	"crypto/subtle"
+	"encoding/binary"
+
+	"github.com/pkg/errors"
+)
+
+// kwpAIV is the alternative initial value of AES key wrap with padding, the
+// 32-bit message length indicator is appended to it
+var kwpAIV = []byte{0xa6, 0x59, 0x59, 0xa6}
+
+// wrapKWP wraps the key with AES key wrap with padding (RFC 5649), which
+// supports keys of any length unlike AES key wrap (RFC 3394)
+func wrapKWP(kek, key []byte) ([]byte, error) {
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) == 0 || uint64(len(key)) > 0xffffffff {
+		return nil, errors.New("invalid key length")
+	}
+
+	// The key is padded with zeros to a multiple of 8 octets
+	n := (len(key) + 7) / 8
+	out := make([]byte, 8*(n+1))
+	copy(out[:4], kwpAIV)
+	binary.BigEndian.PutUint32(out[4:8], uint32(len(key))) // #nosec G115 Length is checked above
+	copy(out[8:], key)
+
+	if n == 1 {
+		block.Encrypt(out, out)
+		return out, nil
+	}
+
+	// RFC 3394 wrapping process with the alternative initial value
+	b := make([]byte, 16)
+	for j := 0; j < 6; j++ {
+		for i := 1; i <= n; i++ {
+			copy(b[:8], out[:8])
+			copy(b[8:], out[8*i:8*i+8])
+			block.Encrypt(b, b)
+			t := uint64(n*j + i) // #nosec G115 Counter is positive
+			binary.BigEndian.PutUint64(out[:8], binary.BigEndian.Uint64(b[:8])^t)
+			copy(out[8*i:8*i+8], b[8:])
+		}
+	}
+	return out, nil
+}
+
+// unwrapKWP unwraps a key wrapped with AES key wrap with padding (RFC 5649)
+func unwrapKWP(kek, wrapped []byte) ([]byte, error) {
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrapped) < 16 || len(wrapped)%8 != 0 {
+		return nil, errors.New("invalid wrapped key length")
+	}
+
+	n := len(wrapped)/8 - 1
+	out := make([]byte, len(wrapped))
+	copy(out, wrapped)
+
+	if n == 1 {
+		block.Decrypt(out, out)
+	} else {
+		// RFC 3394 unwrapping process
+		b := make([]byte, 16)
+		for j := 5; j >= 0; j-- {
+			for i := n; i >= 1; i-- {
+				t := uint64(n*j + i) // #nosec G115 Counter is positive
+				binary.BigEndian.PutUint64(b[:8], binary.BigEndian.Uint64(out[:8])^t)
+				copy(b[8:], out[8*i:8*i+8])
+				block.Decrypt(b, b)
+				copy(out[:8], b[:8])
+				copy(out[8*i:8*i+8], b[8:])
+			}
+		}
+	}
+
+	// Integrity check of the alternative initial value and the padding
+	mli := int(binary.BigEndian.Uint32(out[4:8]))
+	valid := subtle.ConstantTimeCompare(out[:4], kwpAIV) == 1 &&
+		mli > 8*(n-1) && mli <= 8*n &&
+		subtle.ConstantTimeCompare(out[8+mli:], make([]byte, 8*n-mli)) == 1
+	if !valid {
+		return nil, errors.New("unable to unwrap key: integrity check failed")
+	}
+
+	return out[8 : 8+mli], nil
+}
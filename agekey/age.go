@@ -0,0 +1,75 @@
+// Copyright 2026 k8s-enc-image-operator authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package agekey handles keys encrypted to an age recipient, either directly
+// or as SOPS documents, so that keys can flow through the same GitOps
+// pipelines as other secrets.
+package agekey
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"github.com/lumjjb/k8s-enc-image-operator/keysync/sechandlers"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// AgeSecretType is the type of the secrets holding keys encrypted to an
+	// age recipient, one key file per entry
+	AgeSecretType = "age-key"
+
+	// SOPSSecretType is the type of the secrets holding SOPS documents
+	// encrypted to an age recipient, one key file per document entry
+	SOPSSecretType = "sops-key"
+)
+
+// NewAgeSecretKeyHandler returns a secret handler decrypting every entry of the
+// secret, binary or ASCII armored, with the age identities into the key file
+// of the same name
+func NewAgeSecretKeyHandler(identities []age.Identity) sechandlers.SecretKeyHandler {
+	return func(data map[string][]byte) (map[string][]byte, error) {
+		if len(data) == 0 {
+			return nil, errors.New("no encrypted keys in secret")
+		}
+
+		retdata := map[string][]byte{}
+		for name, ciphertext := range data {
+			key, err := decryptAge(ciphertext, identities)
+			if err != nil {
+				return nil, errors.Wrapf(err, "unable to decrypt %s", name)
+			}
+			retdata[name] = key
+		}
+		return retdata, nil
+	}
+}
+
+// decryptAge decrypts the binary or ASCII armored age file with the identities
+func decryptAge(ciphertext []byte, identities []age.Identity) ([]byte, error) {
+	var r io.Reader = bytes.NewReader(ciphertext)
+	if bytes.HasPrefix(bytes.TrimSpace(ciphertext), []byte(armor.Header)) {
+		r = armor.NewReader(bytes.NewReader(bytes.TrimSpace(ciphertext)))
+	}
+
+	dr, err := age.Decrypt(r, identities...)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(bufio.NewReader(dr))
+}
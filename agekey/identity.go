@@ -0,0 +1,99 @@
+// Copyright 2026 k8s-enc-image-operator authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agekey
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"time"
+
+	"filippo.io/age"
+	"github.com/lumjjb/k8s-enc-image-operator/keysync/sechandlers"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// DefaultIdentityKubeSecretKey is the default key of the age identities in
+// the kube secret, as written by age-keygen
+const DefaultIdentityKubeSecretKey = "keys.txt"
+
+// IdentityLoader is a function type that loads the age identities file, in
+// the format written by age-keygen
+type IdentityLoader func() ([]byte, error)
+
+// FileIdentityLoader returns a loader of the age identities from the file,
+// i.e. a node-local file or a mounted secret
+func FileIdentityLoader(filename string) IdentityLoader {
+	return func() ([]byte, error) {
+		return os.ReadFile(filename)
+	}
+}
+
+// KubeSecretIdentityLoader returns a loader of the age identities from the
+// entry of the kube secret
+func KubeSecretIdentityLoader(clientset kubernetes.Interface, namespace, secretName, secretKey string) IdentityLoader {
+	return func() ([]byte, error) {
+		s, err := clientset.CoreV1().Secrets(namespace).Get(context.Background(), secretName, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		data, ok := s.Data[secretKey]
+		if !ok {
+			return nil, errors.Errorf("key %s not found in secret %s", secretKey, secretName)
+		}
+		return data, nil
+	}
+}
+
+// IdentityThread is a helper function that loads the age identities and adds
+// the age and SOPS handlers to the server, and again whenever the identities
+// change, i.e. when a new identity is added for rotation. Meant to run as a
+// thread.
+func IdentityThread(load IdentityLoader, adder sechandlers.SecretKeyHandlerAdder, interval time.Duration) {
+	first := true
+	var oldData []byte
+	for {
+		if !first {
+			<-time.After(interval)
+		} else {
+			first = false
+		}
+
+		data, err := load()
+		if err != nil {
+			logrus.Errorf("Unable to load age identities: %v", err)
+			continue
+		}
+
+		if oldData != nil && bytes.Equal(data, oldData) {
+			continue
+		}
+
+		identities, err := age.ParseIdentities(bytes.NewReader(data))
+		if err != nil {
+			logrus.Errorf("Unable to parse age identities: %v", err)
+			continue
+		}
+		oldData = data
+
+		logrus.Printf("New age identities detected, configuring %d identities...", len(identities))
+		adder.AddSecretKeyHandler(AgeSecretType, NewAgeSecretKeyHandler(identities))
+		adder.AddSecretKeyHandler(SOPSSecretType, NewSOPSSecretKeyHandler(identities))
+	}
+}
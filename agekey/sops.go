@@ -0,0 +1,269 @@
+// Copyright 2026 k8s-enc-image-operator authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agekey
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"filippo.io/age"
+	"github.com/lumjjb/k8s-enc-image-operator/keysync/sechandlers"
+
+	"github.com/pkg/errors"
+	"go.yaml.in/yaml/v3"
+)
+
+// sopsMetadataKey is the key of the SOPS metadata in a SOPS document
+const sopsMetadataKey = "sops"
+
+// sopsValueRegexp matches a value encrypted by SOPS
+var sopsValueRegexp = regexp.MustCompile(`^ENC\[AES256_GCM,data:(.*),iv:(.+),tag:(.+),type:(.+)\]$`)
+
+// sopsMetadata is the SOPS metadata relevant to decrypting a document with age
+type sopsMetadata struct {
+	Age []struct {
+		Recipient string `yaml:"recipient"`
+		Enc       string `yaml:"enc"`
+	} `yaml:"age"`
+	LastModified     string `yaml:"lastmodified"`
+	MAC              string `yaml:"mac"`
+	MACOnlyEncrypted bool   `yaml:"mac_only_encrypted"`
+}
+
+// NewSOPSSecretKeyHandler returns a secret handler decrypting the SOPS YAML or
+// JSON documents in the entries of the secret with the age identities. Every
+// top level entry of the documents is written to the key file of the same
+// name, the integrity of the documents is verified with their MAC. Anyone may
+// encrypt to the recipients, so the entry names are untrusted, and the key sync
+// server rejects names that are not valid secret data keys.
+func NewSOPSSecretKeyHandler(identities []age.Identity) sechandlers.SecretKeyHandler {
+	return func(data map[string][]byte) (map[string][]byte, error) {
+		if len(data) == 0 {
+			return nil, errors.New("no SOPS documents in secret")
+		}
+
+		retdata := map[string][]byte{}
+		for name, doc := range data {
+			entries, err := decryptSOPS(doc, identities)
+			if err != nil {
+				return nil, errors.Wrapf(err, "unable to decrypt SOPS document %s", name)
+			}
+			for k, v := range entries {
+				if _, ok := retdata[k]; ok {
+					return nil, errors.Errorf("duplicate entry %s", k)
+				}
+				retdata[k] = v
+			}
+		}
+		return retdata, nil
+	}
+}
+
+// decryptSOPS decrypts the SOPS document and returns its top level entries
+func decryptSOPS(doc []byte, identities []age.Identity) (map[string][]byte, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(doc, &root); err != nil {
+		return nil, err
+	}
+	if len(root.Content) != 1 || root.Content[0].Kind != yaml.MappingNode {
+		return nil, errors.New("document is not a mapping")
+	}
+	tree := root.Content[0]
+
+	var metadata *sopsMetadata
+	for i := 0; i+1 < len(tree.Content); i += 2 {
+		if tree.Content[i].Value == sopsMetadataKey {
+			metadata = &sopsMetadata{}
+			if err := tree.Content[i+1].Decode(metadata); err != nil {
+				return nil, errors.Wrap(err, "invalid SOPS metadata")
+			}
+		}
+	}
+	if metadata == nil {
+		return nil, errors.New("no SOPS metadata in document")
+	}
+
+	dataKey, err := sopsDataKey(metadata, identities)
+	if err != nil {
+		return nil, err
+	}
+
+	// The MAC is computed over all values in document order, or only over
+	// the encrypted values
+	hash := sha512.New()
+	entries := map[string][]byte{}
+
+	var walk func(n *yaml.Node, path []string) ([]byte, error)
+	walk = func(n *yaml.Node, path []string) ([]byte, error) {
+		switch n.Kind {
+		case yaml.MappingNode:
+			for i := 0; i+1 < len(n.Content); i += 2 {
+				if _, err := walk(n.Content[i+1], append(path, n.Content[i].Value)); err != nil {
+					return nil, err
+				}
+			}
+			return nil, nil
+		case yaml.SequenceNode:
+			// Sequence items share the path of the sequence
+			for _, item := range n.Content {
+				if _, err := walk(item, path); err != nil {
+					return nil, err
+				}
+			}
+			return nil, nil
+		case yaml.ScalarNode:
+			value, encrypted, err := sopsScalar(n, dataKey, strings.Join(path, ":")+":")
+			if err != nil {
+				return nil, errors.Wrapf(err, "unable to decrypt %s", strings.Join(path, ":"))
+			}
+			if encrypted || !metadata.MACOnlyEncrypted {
+				hash.Write(value)
+			}
+			return value, nil
+		default:
+			return nil, errors.Errorf("unsupported node at %s", strings.Join(path, ":"))
+		}
+	}
+
+	for i := 0; i+1 < len(tree.Content); i += 2 {
+		key, value := tree.Content[i].Value, tree.Content[i+1]
+		if key == sopsMetadataKey {
+			continue
+		}
+
+		plaintext, err := walk(value, []string{key})
+		if err != nil {
+			return nil, err
+		}
+		if value.Kind != yaml.ScalarNode {
+			return nil, errors.Errorf("entry %s is not a scalar", key)
+		}
+		entries[key] = plaintext
+	}
+
+	// Verify the MAC, which is encrypted with the last modified time as
+	// additional data
+	mac, _, err := decryptSOPSValue(metadata.MAC, dataKey, metadata.LastModified)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to decrypt MAC")
+	}
+	computed := fmt.Sprintf("%X", hash.Sum(nil))
+	if subtle.ConstantTimeCompare([]byte(strings.ToUpper(string(mac))), []byte(computed)) != 1 {
+		return nil, errors.New("MAC mismatch, document has been tampered with")
+	}
+
+	return entries, nil
+}
+
+// sopsDataKey decrypts the data key of the document with the age identities
+func sopsDataKey(metadata *sopsMetadata, identities []age.Identity) ([]byte, error) {
+	if len(metadata.Age) == 0 {
+		return nil, errors.New("document is not encrypted to an age recipient")
+	}
+
+	var lastErr error
+	for _, a := range metadata.Age {
+		dataKey, err := decryptAge([]byte(a.Enc), identities)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		return dataKey, nil
+	}
+	return nil, errors.Wrap(lastErr, "unable to decrypt data key")
+}
+
+// sopsScalar returns the plaintext of the scalar as SOPS hashes it for the MAC,
+// and whether it was encrypted
+func sopsScalar(n *yaml.Node, dataKey []byte, additionalData string) ([]byte, bool, error) {
+	if n.Tag == "!!str" && sopsValueRegexp.MatchString(n.Value) {
+		value, _, err := decryptSOPSValue(n.Value, dataKey, additionalData)
+		return value, true, err
+	}
+
+	switch n.Tag {
+	case "!!str":
+		return []byte(n.Value), false, nil
+	case "!!int":
+		var i int
+		if err := n.Decode(&i); err != nil {
+			return nil, false, err
+		}
+		return []byte(strconv.Itoa(i)), false, nil
+	case "!!float":
+		var f float64
+		if err := n.Decode(&f); err != nil {
+			return nil, false, err
+		}
+		return []byte(strconv.FormatFloat(f, 'f', -1, 64)), false, nil
+	case "!!bool":
+		var b bool
+		if err := n.Decode(&b); err != nil {
+			return nil, false, err
+		}
+		// SOPS formats booleans like Python
+		if b {
+			return []byte("True"), false, nil
+		}
+		return []byte("False"), false, nil
+	default:
+		return nil, false, errors.Errorf("unsupported value of type %s", n.Tag)
+	}
+}
+
+// decryptSOPSValue decrypts a value encrypted by SOPS with the data key and the
+// additional data, returning the plaintext and its type
+func decryptSOPSValue(value string, dataKey []byte, additionalData string) ([]byte, string, error) {
+	m := sopsValueRegexp.FindStringSubmatch(value)
+	if m == nil {
+		return nil, "", errors.New("value is not encrypted by SOPS")
+	}
+
+	data, err := base64.StdEncoding.DecodeString(m[1])
+	if err != nil {
+		return nil, "", err
+	}
+	iv, err := base64.StdEncoding.DecodeString(m[2])
+	if err != nil {
+		return nil, "", err
+	}
+	tag, err := base64.StdEncoding.DecodeString(m[3])
+	if err != nil {
+		return nil, "", err
+	}
+
+	block, err := aes.NewCipher(dataKey)
+	if err != nil {
+		return nil, "", err
+	}
+	// SOPS uses 32 byte nonces
+	aead, err := cipher.NewGCMWithNonceSize(block, len(iv))
+	if err != nil {
+		return nil, "", err
+	}
+
+	plaintext, err := aead.Open(nil, iv, append(data, tag...), []byte(additionalData))
+	if err != nil {
+		return nil, "", err
+	}
+	return plaintext, m[4], nil
+}
@@ -0,0 +1,234 @@
+// Copyright 2026 k8s-enc-image-operator authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package v1alpha1 contains the types of the oci.crypt/v1alpha1 API group
+package v1alpha1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	// GroupName is the API group of the enc key sync resources
+	GroupName = "oci.crypt"
+
+	// Version is the API version of the enc key sync resources
+	Version = "v1alpha1"
+)
+
+var (
+	// SchemeGroupVersion is the group version of the enc key sync resources
+	SchemeGroupVersion = schema.GroupVersion{Group: GroupName, Version: Version}
+
+	// EncKeySyncResource is the group version resource of EncKeySync
+	EncKeySyncResource = SchemeGroupVersion.WithResource("enckeysyncs")
+
+	// EncKeyNodeStatusResource is the group version resource of EncKeyNodeStatus
+	EncKeyNodeStatusResource = SchemeGroupVersion.WithResource("enckeynodestatuses")
+)
+
+const (
+	// HandlerTypeKeyprotect configures the keyprotect handler for kp-key
+	// secrets
+	HandlerTypeKeyprotect = "keyprotect"
+)
+
+const (
+	// ConditionValid reports whether the spec of the EncKeySync is valid
+	ConditionValid = "Valid"
+
+	// ConditionProgressing reports whether the key sync daemonset is
+	// being rolled out
+	ConditionProgressing = "Progressing"
+
+	// ConditionAvailable reports whether the key sync daemonset is
+	// available on all scheduled nodes
+	ConditionAvailable = "Available"
+)
+
+// EncKeySync describes a deployment of the key sync daemon, which syncs
+// decryption keys from secrets to the nodes of the cluster
+type EncKeySync struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   EncKeySyncSpec   `json:"spec,omitempty"`
+	Status EncKeySyncStatus `json:"status,omitempty"`
+}
+
+// EncKeySyncList is a list of EncKeySync
+type EncKeySyncList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []EncKeySync `json:"items"`
+}
+
+// EncKeySyncSpec contains the parameters of the key sync daemon
+type EncKeySyncSpec struct {
+	// Interval is the query interval in which to sync the decryption keys
+	Interval *metav1.Duration `json:"interval,omitempty"`
+
+	// KeysDir is the host directory where keys are synced to
+	KeysDir string `json:"keysDir,omitempty"`
+
+	// KeyFilePermissions are the permissions to set on the created files,
+	// in octal, i.e. "0600"
+	KeyFilePermissions string `json:"keyFilePermissions,omitempty"`
+
+	// KeyFileOwnership is the ownership to set on the created files in
+	// UID:GID format, if empty files are created with the UID:GID of the
+	// daemon
+	KeyFileOwnership string `json:"keyFileOwnership,omitempty"`
+
+	// Handlers configure the handlers for secret types that require
+	// additional configuration, such as keyprotect
+	Handlers []HandlerConfig `json:"handlers,omitempty"`
+
+	// NodeSelector selects the nodes that keys are synced to
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+
+	// Tolerations are the tolerations of the key sync daemon pods
+	Tolerations []corev1.Toleration `json:"tolerations,omitempty"`
+
+	// Image is the key sync daemon image, if empty the controller default
+	// is used
+	Image string `json:"image,omitempty"`
+
+	// IsOpenShift runs the key sync daemon privileged, which is required
+	// on OpenShift because of SELinux restricting access to hostPaths
+	IsOpenShift bool `json:"isOpenShift,omitempty"`
+}
+
+// HandlerConfig configures a secret key handler of the key sync daemon
+type HandlerConfig struct {
+	// Type is the type of the handler, i.e. "keyprotect"
+	Type string `json:"type"`
+
+	// ConfigSecretRef references the secret, in the namespace of the
+	// EncKeySync, containing the handler configuration
+	ConfigSecretRef SecretKeyRef `json:"configSecretRef"`
+}
+
+// SecretKeyRef references a key of a secret
+type SecretKeyRef struct {
+	// Name is the name of the secret
+	Name string `json:"name"`
+
+	// Key is the key of the secret data, if empty a handler specific
+	// default is used
+	Key string `json:"key,omitempty"`
+}
+
+// EncKeySyncStatus reports the state of the key sync daemon
+type EncKeySyncStatus struct {
+	// ObservedGeneration is the generation of the spec last reconciled
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// DesiredNumberScheduled is the number of nodes that should run the
+	// key sync daemon
+	DesiredNumberScheduled int32 `json:"desiredNumberScheduled,omitempty"`
+
+	// UpdatedNumberScheduled is the number of nodes running the current
+	// spec of the key sync daemon
+	UpdatedNumberScheduled int32 `json:"updatedNumberScheduled,omitempty"`
+
+	// NumberAvailable is the number of nodes running an available key
+	// sync daemon
+	NumberAvailable int32 `json:"numberAvailable,omitempty"`
+
+	// Conditions are the Valid, Progressing and Available conditions
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// EncKeyNodeStatus reports the keys synced to a node by the key sync daemon,
+// it is cluster scoped, named after the node and owned by the node
+type EncKeyNodeStatus struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Status EncKeyNodeStatusStatus `json:"status,omitempty"`
+}
+
+// EncKeyNodeStatusList is a list of EncKeyNodeStatus
+type EncKeyNodeStatusList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []EncKeyNodeStatus `json:"items"`
+}
+
+// EncKeyNodeStatusStatus contains the keys synced to the node and the errors
+// of the last sync
+type EncKeyNodeStatusStatus struct {
+	// LastSyncTime is the time of the last sync reported
+	LastSyncTime metav1.Time `json:"lastSyncTime,omitempty"`
+
+	// LastChangeTime is the time the synced keys or errors last changed
+	LastChangeTime metav1.Time `json:"lastChangeTime,omitempty"`
+
+	// Keys are the key files synced to the node
+	Keys []SyncedKey `json:"keys,omitempty"`
+
+	// Errors are the errors of the last sync
+	Errors []SyncError `json:"errors,omitempty"`
+
+	// PublicKey is the base64 encoded X25519 public key of the node-local
+	// keypair of the node daemon, which the KeySync hub wraps the keys
+	// served to the node with
+	PublicKey string `json:"publicKey,omitempty"`
+}
+
+// SyncedKey is a key file synced to a node
+type SyncedKey struct {
+	// Sink is the name of the additional directory the key file was synced
+	// to, empty for the keys directory
+	Sink string `json:"sink,omitempty"`
+
+	// Filename is the name of the key file in the keys directory
+	Filename string `json:"filename"`
+
+	// Hash is the SHA-256 of the key file contents
+	Hash string `json:"hash"`
+
+	// Secret is the secret the key file was synced from
+	Secret SecretReference `json:"secret"`
+}
+
+// SyncError is an error syncing keys to a node
+type SyncError struct {
+	// Secret is the secret that failed to sync, the name is empty for
+	// errors listing the secrets of a type
+	Secret SecretReference `json:"secret"`
+
+	// Message describes the error
+	Message string `json:"message"`
+}
+
+// SecretReference identifies a version of a key secret
+type SecretReference struct {
+	// Namespace is the namespace of the secret
+	Namespace string `json:"namespace,omitempty"`
+
+	// Name is the name of the secret
+	Name string `json:"name,omitempty"`
+
+	// Type is the type of the secret
+	Type string `json:"type,omitempty"`
+
+	// ResourceVersion is the resource version of the secret synced
+	ResourceVersion string `json:"resourceVersion,omitempty"`
+}
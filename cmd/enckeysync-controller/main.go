@@ -0,0 +1,87 @@
+// Copyright 2026 k8s-enc-image-operator authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"math"
+	"os"
+	"time"
+
+	"github.com/lumjjb/k8s-enc-image-operator/controller"
+	"github.com/sirupsen/logrus"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const (
+	NamespaceEnv = "WATCH_NAMESPACE"
+)
+
+func main() {
+	inputFlags := struct {
+		kubeconfig string
+		interval   uint
+		image      string
+	}{
+		kubeconfig: "",
+		interval:   10,
+		image:      "lumjjb/keysync:latest",
+	}
+
+	flag.StringVar(&inputFlags.kubeconfig, "kubeconfig", inputFlags.kubeconfig,
+		"(optional) kubeconfig file to use, defaults to in-cluster config otherwise")
+	flag.UintVar(&inputFlags.interval, "interval", inputFlags.interval,
+		"(optional) interval to reconcile EncKeySyncs (in seconds)")
+	flag.StringVar(&inputFlags.image, "image", inputFlags.image,
+		"(optional) default key sync daemon image for EncKeySyncs not specifying one")
+	flag.Parse()
+
+	config, err := clientcmd.BuildConfigFromFlags("", inputFlags.kubeconfig)
+	if err != nil {
+		panic(err)
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		panic(err)
+	}
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		panic(err)
+	}
+
+	if inputFlags.interval > math.MaxInt64 {
+		panic("input interval caused conversion overflow")
+	}
+
+	ekscc := controller.EncKeySyncControllerConfig{
+		K8sClient:     clientset,
+		DynamicClient: dynamicClient,
+		Interval:      time.Duration(inputFlags.interval) * time.Second,
+		Namespace:     os.Getenv(NamespaceEnv),
+		DefaultImage:  inputFlags.image,
+	}
+	c := controller.NewEncKeySyncController(ekscc)
+
+	logrus.Printf("Starting EncKeySync controller with interval %v s, namespace %q, default image %v",
+		ekscc.Interval/time.Second,
+		ekscc.Namespace,
+		ekscc.DefaultImage)
+
+	if err := c.Start(); err != nil {
+		logrus.Fatalf("EncKeySync controller failure: %v", err)
+	}
+}
@@ -0,0 +1,359 @@
+// Copyright 2026 k8s-enc-image-operator authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"math"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/lumjjb/k8s-enc-image-operator/aeswrap"
+	"github.com/lumjjb/k8s-enc-image-operator/agekey"
+	"github.com/lumjjb/k8s-enc-image-operator/hub"
+	keyprotect "github.com/lumjjb/k8s-enc-image-operator/keyprotect"
+	"github.com/lumjjb/k8s-enc-image-operator/multiwrap"
+	"github.com/lumjjb/k8s-enc-image-operator/pgpkey"
+	"github.com/lumjjb/k8s-enc-image-operator/secsign"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+const (
+	NamespaceEnv = "POD_NAMESPACE"
+	PodNameEnv   = "POD_NAME"
+)
+
+func main() {
+	inputFlags := struct {
+		kubeconfig                    string
+		interval                      uint
+		addr                          string
+		tlsCertFile                   string
+		tlsKeyFile                    string
+		clientCAFile                  string
+		audience                      string
+		serviceAccounts               string
+		keyprotectConfigFile          string
+		keyprotectConfigKubeSecret    string
+		keyprotectConfigKubeSecretKey string
+		aesKEKDir                     string
+		aesKEKKubeSecret              string
+		ageIdentityFile               string
+		ageIdentityKubeSecret         string
+		ageIdentityKubeSecretKey      string
+		pgpKeyringFile                string
+		pgpPassphraseFile             string
+		pgpTrustedKeyringFile         string
+		pgpKeyringKubeSecret          string
+		multiConfigFile               string
+		multiConfigKubeSecret         string
+		multiConfigKubeSecretKey      string
+		signingPublicKeysFile         string
+		metricsAddr                   string
+		leaderElect                   bool
+		leaseName                     string
+		requireNodeKeyWrapping        bool
+	}{
+		kubeconfig:                    "",
+		interval:                      10,
+		addr:                          ":8443",
+		tlsCertFile:                   "/etc/hub/certs/tls.crt",
+		tlsKeyFile:                    "/etc/hub/certs/tls.key",
+		clientCAFile:                  "/etc/hub/certs/ca.crt",
+		audience:                      hub.DefaultAudience,
+		serviceAccounts:               "enc-key-sync/enc-key-sync-sa",
+		keyprotectConfigFile:          "",
+		keyprotectConfigKubeSecret:    "",
+		keyprotectConfigKubeSecretKey: "config.json",
+		aesKEKDir:                     "",
+		aesKEKKubeSecret:              "",
+		ageIdentityFile:               "",
+		ageIdentityKubeSecret:         "",
+		ageIdentityKubeSecretKey:      agekey.DefaultIdentityKubeSecretKey,
+		pgpKeyringFile:                "",
+		pgpPassphraseFile:             "",
+		pgpTrustedKeyringFile:         "",
+		pgpKeyringKubeSecret:          "",
+		multiConfigFile:               "",
+		multiConfigKubeSecret:         "",
+		multiConfigKubeSecretKey:      "config.json",
+		signingPublicKeysFile:         "",
+		metricsAddr:                   "",
+		leaderElect:                   true,
+		leaseName:                     "enc-key-sync-hub",
+		requireNodeKeyWrapping:        false,
+	}
+
+	flag.StringVar(&inputFlags.kubeconfig, "kubeconfig", inputFlags.kubeconfig,
+		"(optional) kubeconfig file to use, defaults to in-cluster config otherwise")
+	flag.UintVar(&inputFlags.interval, "interval", inputFlags.interval,
+		"(optional) interval to refresh the keys (in seconds)")
+	flag.StringVar(&inputFlags.addr, "addr", inputFlags.addr,
+		"(optional) address to serve the keys on")
+	flag.StringVar(&inputFlags.tlsCertFile, "tlsCertFile", inputFlags.tlsCertFile,
+		"(optional) TLS certificate file to serve the keys with")
+	flag.StringVar(&inputFlags.tlsKeyFile, "tlsKeyFile", inputFlags.tlsKeyFile,
+		"(optional) TLS private key file to serve the keys with")
+	flag.StringVar(&inputFlags.clientCAFile, "clientCAFile", inputFlags.clientCAFile,
+		"(optional) CA file to verify the client certificates of the node daemons with")
+	flag.StringVar(&inputFlags.audience, "audience", inputFlags.audience,
+		"(optional) audience the service account tokens of the node daemons are bound to")
+	flag.StringVar(&inputFlags.serviceAccounts, "serviceAccounts", inputFlags.serviceAccounts,
+		"(optional) comma separated list of service accounts entitled to keys, in namespace/name format")
+	flag.StringVar(&inputFlags.keyprotectConfigFile, "keyprotectConfigFile", inputFlags.keyprotectConfigFile,
+		"(optional) config file for keyprotect enablement")
+	flag.StringVar(&inputFlags.keyprotectConfigKubeSecret, "keyprotectConfigKubeSecret", inputFlags.keyprotectConfigKubeSecret,
+		"(optional) kube secret name for config file for keyprotect enablement")
+	flag.StringVar(&inputFlags.keyprotectConfigKubeSecretKey, "keyprotectConfigKubeSecretKey", inputFlags.keyprotectConfigKubeSecretKey,
+		"(optional) key of the config file in the kube secret for keyprotect enablement (defaults to config.json)")
+	flag.StringVar(&inputFlags.aesKEKDir, "aesKEKDir", inputFlags.aesKEKDir,
+		"(optional) directory of AES KEK files named by key ID for aes-wrapped-key enablement")
+	flag.StringVar(&inputFlags.aesKEKKubeSecret, "aesKEKKubeSecret", inputFlags.aesKEKKubeSecret,
+		"(optional) kube secret name of AES KEKs named by key ID for aes-wrapped-key enablement")
+	flag.StringVar(&inputFlags.ageIdentityFile, "ageIdentityFile", inputFlags.ageIdentityFile,
+		"(optional) age identities file for age-key and sops-key enablement")
+	flag.StringVar(&inputFlags.ageIdentityKubeSecret, "ageIdentityKubeSecret", inputFlags.ageIdentityKubeSecret,
+		"(optional) kube secret name of the age identities for age-key and sops-key enablement")
+	flag.StringVar(&inputFlags.ageIdentityKubeSecretKey, "ageIdentityKubeSecretKey", inputFlags.ageIdentityKubeSecretKey,
+		"(optional) key of the age identities in the kube secret (defaults to keys.txt)")
+	flag.StringVar(&inputFlags.pgpKeyringFile, "pgpKeyringFile", inputFlags.pgpKeyringFile,
+		"(optional) OpenPGP private keyring file for pgp-key enablement")
+	flag.StringVar(&inputFlags.pgpPassphraseFile, "pgpPassphraseFile", inputFlags.pgpPassphraseFile,
+		"(optional) file with the passphrase of the OpenPGP private keyring")
+	flag.StringVar(&inputFlags.pgpTrustedKeyringFile, "pgpTrustedKeyringFile", inputFlags.pgpTrustedKeyringFile,
+		"(optional) OpenPGP public keyring file of the senders trusted to sign pgp-key messages")
+	flag.StringVar(&inputFlags.pgpKeyringKubeSecret, "pgpKeyringKubeSecret", inputFlags.pgpKeyringKubeSecret,
+		"(optional) kube secret name of the OpenPGP keyrings (secring.asc, passphrase, pubring.asc) for pgp-key enablement")
+	flag.StringVar(&inputFlags.multiConfigFile, "multiConfigFile", inputFlags.multiConfigFile,
+		"(optional) config file of the ordered backends for multi-wrapped-key enablement")
+	flag.StringVar(&inputFlags.multiConfigKubeSecret, "multiConfigKubeSecret", inputFlags.multiConfigKubeSecret,
+		"(optional) kube secret name for config file of the ordered backends for multi-wrapped-key enablement")
+	flag.StringVar(&inputFlags.multiConfigKubeSecretKey, "multiConfigKubeSecretKey", inputFlags.multiConfigKubeSecretKey,
+		"(optional) key of the config file in the kube secret for multi-wrapped-key enablement (defaults to config.json)")
+	flag.StringVar(&inputFlags.signingPublicKeysFile, "signingPublicKeysFile", inputFlags.signingPublicKeysFile,
+		"(optional) PEM file of the trusted public keys, only secrets signed by one of them are synced")
+	flag.StringVar(&inputFlags.metricsAddr, "metricsAddr", inputFlags.metricsAddr,
+		"(optional) address to serve the prometheus metrics on, i.e. :9090")
+	flag.BoolVar(&inputFlags.leaderElect, "leaderElect", inputFlags.leaderElect,
+		"(optional) only serve keys from the elected leader of the replicas")
+	flag.StringVar(&inputFlags.leaseName, "leaseName", inputFlags.leaseName,
+		"(optional) name of the lease used for leader election")
+	flag.BoolVar(&inputFlags.requireNodeKeyWrapping, "requireNodeKeyWrapping", inputFlags.requireNodeKeyWrapping,
+		"(optional) only serve keys wrapped to the public key published by the node of the caller")
+	flag.Parse()
+
+	config, err := clientcmd.BuildConfigFromFlags("", inputFlags.kubeconfig)
+	if err != nil {
+		panic(err)
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		panic(err)
+	}
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		panic(err)
+	}
+
+	tlsConfig, err := hub.NewServerTLSConfig(inputFlags.tlsCertFile, inputFlags.tlsKeyFile, inputFlags.clientCAFile)
+	if err != nil {
+		panic(err)
+	}
+
+	namespace := os.Getenv(NamespaceEnv)
+	if inputFlags.interval > math.MaxInt64 {
+		panic("input interval caused conversion overflow")
+	}
+	interval := time.Duration(inputFlags.interval) * time.Second
+
+	hsc := hub.HubServerConfig{
+		K8sClient:              clientset,
+		Interval:               interval,
+		Namespace:              namespace,
+		Audience:               inputFlags.audience,
+		ServiceAccounts:        strings.Split(inputFlags.serviceAccounts, ","),
+		DynamicClient:          dynamicClient,
+		RequireNodeKeyWrapping: inputFlags.requireNodeKeyWrapping,
+	}
+
+	if inputFlags.signingPublicKeysFile != "" {
+		publicKeys, err := secsign.LoadPublicKeysFile(inputFlags.signingPublicKeysFile)
+		if err != nil {
+			panic(err)
+		}
+		verifier, err := secsign.NewVerifier(secsign.VerifierConfig{
+			PublicKeys: publicKeys,
+			Recorder:   secsign.NewEventRecorder(clientset, namespace, "enc-key-sync-hub", os.Getenv(PodNameEnv)),
+		})
+		if err != nil {
+			panic(err)
+		}
+		hsc.SecretVerifier = verifier
+		logrus.Printf("Only serving secrets signed by one of %d trusted keys", len(publicKeys))
+	}
+
+	if inputFlags.metricsAddr != "" {
+		go func() {
+			mux := http.NewServeMux()
+			mux.Handle("/metrics", promhttp.Handler())
+			server := &http.Server{Addr: inputFlags.metricsAddr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+			if err := server.ListenAndServe(); err != nil {
+				logrus.Errorf("Metrics server failure: %v", err)
+			}
+		}()
+	}
+
+	// The keyprotect handler is added asynchronously when its config is
+	// loaded from a kube secret
+	if inputFlags.keyprotectConfigFile == "" && inputFlags.keyprotectConfigKubeSecret != "" {
+		hsc.RequiredSecretTypes = append(hsc.RequiredSecretTypes, "kp-key")
+	}
+
+	// The AES handler is added asynchronously when its KEKs are loaded
+	if inputFlags.aesKEKDir != "" || inputFlags.aesKEKKubeSecret != "" {
+		hsc.RequiredSecretTypes = append(hsc.RequiredSecretTypes, aeswrap.SecretType)
+	}
+
+	// The age and SOPS handlers are added asynchronously when the identities
+	// are loaded
+	if inputFlags.ageIdentityFile != "" || inputFlags.ageIdentityKubeSecret != "" {
+		hsc.RequiredSecretTypes = append(hsc.RequiredSecretTypes, agekey.AgeSecretType, agekey.SOPSSecretType)
+	}
+
+	// The OpenPGP handler is added asynchronously when the keyrings are loaded
+	if inputFlags.pgpKeyringFile != "" || inputFlags.pgpKeyringKubeSecret != "" {
+		hsc.RequiredSecretTypes = append(hsc.RequiredSecretTypes, pgpkey.SecretType)
+	}
+
+	// The multi backend handler is added asynchronously when its config is
+	// loaded from a kube secret
+	if inputFlags.multiConfigFile == "" && inputFlags.multiConfigKubeSecret != "" {
+		hsc.RequiredSecretTypes = append(hsc.RequiredSecretTypes, multiwrap.SecretType)
+	}
+
+	hs := hub.NewHubServer(hsc)
+
+	if inputFlags.keyprotectConfigFile != "" {
+		kpskh, err := keyprotect.GetSecKeyHandlerFromConfigFile(inputFlags.keyprotectConfigFile)
+		if err != nil {
+			panic(err)
+		}
+		hs.AddSecretKeyHandler("kp-key", kpskh)
+	} else if inputFlags.keyprotectConfigKubeSecret != "" {
+		go keyprotect.ConfigKubeSecretThread(clientset, namespace, inputFlags.keyprotectConfigKubeSecret, inputFlags.keyprotectConfigKubeSecretKey, hs, interval)
+	}
+
+	if inputFlags.aesKEKDir != "" {
+		go aeswrap.KEKThread(func() (map[string][]byte, error) {
+			return aeswrap.LoadKEKDir(inputFlags.aesKEKDir)
+		}, hs, interval)
+	} else if inputFlags.aesKEKKubeSecret != "" {
+		go aeswrap.KEKThread(aeswrap.KubeSecretKEKLoader(clientset, namespace, inputFlags.aesKEKKubeSecret), hs, interval)
+	}
+
+	if inputFlags.ageIdentityFile != "" {
+		go agekey.IdentityThread(agekey.FileIdentityLoader(inputFlags.ageIdentityFile), hs, interval)
+	} else if inputFlags.ageIdentityKubeSecret != "" {
+		go agekey.IdentityThread(agekey.KubeSecretIdentityLoader(clientset, namespace, inputFlags.ageIdentityKubeSecret, inputFlags.ageIdentityKubeSecretKey), hs, interval)
+	}
+
+	if inputFlags.pgpKeyringFile != "" {
+		go pgpkey.KeyringThread(pgpkey.FileKeyringLoader(inputFlags.pgpKeyringFile, inputFlags.pgpPassphraseFile, inputFlags.pgpTrustedKeyringFile), hs, interval)
+	} else if inputFlags.pgpKeyringKubeSecret != "" {
+		go pgpkey.KeyringThread(pgpkey.KubeSecretKeyringLoader(clientset, namespace, inputFlags.pgpKeyringKubeSecret), hs, interval)
+	}
+
+	if inputFlags.multiConfigFile != "" {
+		mskh, err := multiwrap.GetSecKeyHandlerFromConfigFile(inputFlags.multiConfigFile)
+		if err != nil {
+			panic(err)
+		}
+		hs.AddSecretKeyHandler(multiwrap.SecretType, mskh)
+	} else if inputFlags.multiConfigKubeSecret != "" {
+		go multiwrap.ConfigThread(multiwrap.KubeSecretConfigLoader(clientset, namespace, inputFlags.multiConfigKubeSecret, inputFlags.multiConfigKubeSecretKey), hs, interval)
+	}
+
+	server := &http.Server{
+		Addr:              inputFlags.addr,
+		Handler:           hs,
+		TLSConfig:         tlsConfig,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	// run refreshes the keys and serves them, only the leader listens so
+	// that the service only routes to the leader
+	run := func(ctx context.Context) {
+		go hs.Start(ctx)
+
+		logrus.Printf("Starting KeySync hub on %v, interval %v s, namespace %v, service accounts %v",
+			inputFlags.addr,
+			interval/time.Second,
+			namespace,
+			hsc.ServiceAccounts)
+
+		if err := server.ListenAndServeTLS("", ""); err != nil {
+			logrus.Fatalf("KeySync hub failure: %v", err)
+		}
+	}
+
+	if !inputFlags.leaderElect {
+		run(context.Background())
+		return
+	}
+
+	identity := os.Getenv(PodNameEnv)
+	if identity == "" {
+		identity, err = os.Hostname()
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	leaderelection.RunOrDie(context.Background(), leaderelection.LeaderElectionConfig{
+		Lock: &resourcelock.LeaseLock{
+			LeaseMeta: metav1.ObjectMeta{
+				Name:      inputFlags.leaseName,
+				Namespace: namespace,
+			},
+			Client: clientset.CoordinationV1(),
+			LockConfig: resourcelock.ResourceLockConfig{
+				Identity: identity,
+			},
+		},
+		ReleaseOnCancel: true,
+		LeaseDuration:   15 * time.Second,
+		RenewDeadline:   10 * time.Second,
+		RetryPeriod:     2 * time.Second,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: run,
+			OnStoppedLeading: func() {
+				logrus.Fatalf("KeySync hub lost leadership")
+			},
+			OnNewLeader: func(leader string) {
+				logrus.Printf("KeySync hub leader is %v", leader)
+			},
+		},
+	})
+}
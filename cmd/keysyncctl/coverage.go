@@ -0,0 +1,93 @@
+// Copyright 2026 k8s-enc-image-operator authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/lumjjb/k8s-enc-image-operator/nodestatus"
+	"github.com/pkg/errors"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// coverageCmd prints which nodes hold the keys of a secret
+func coverageCmd(args []string) error {
+	fs := flag.NewFlagSet("coverage", flag.ExitOnError)
+	kubeconfig := fs.String("kubeconfig", os.Getenv("KUBECONFIG"),
+		"(optional) kubeconfig file to use, defaults to $KUBECONFIG or in-cluster config otherwise")
+	secret := fs.String("secret", "",
+		"secret to report the coverage of, in namespace/name format")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	namespace, name, ok := strings.Cut(*secret, "/")
+	if !ok || namespace == "" || name == "" {
+		return errors.New("secret must be specified in namespace/name format")
+	}
+
+	config, err := clientcmd.BuildConfigFromFlags("", *kubeconfig)
+	if err != nil {
+		return err
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return err
+	}
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return err
+	}
+
+	report, err := nodestatus.Coverage(context.Background(), clientset, dynamicClient, namespace, name)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NODE\tSTATE\tRESOURCE VERSION\tLAST SYNC\tFILES\tMESSAGE")
+	for _, nc := range report.Nodes {
+		lastSync := "-"
+		if !nc.LastSyncTime.IsZero() {
+			lastSync = nc.LastSyncTime.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			nc.Node, nc.State, nc.ResourceVersion, lastSync, strings.Join(nc.Files, ","), nc.Message)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	current := report.Secret.ResourceVersion
+	if current == "" {
+		current = "secret not found"
+	}
+	fmt.Printf("\n%d/%d nodes hold the current keys of %s/%s (resource version %s), %d stale, %d missing, %d errors, %d unknown\n",
+		report.Counts[nodestatus.CoverageCurrent], len(report.Nodes), namespace, name, current,
+		report.Counts[nodestatus.CoverageStale],
+		report.Counts[nodestatus.CoverageMissing],
+		report.Counts[nodestatus.CoverageError],
+		report.Counts[nodestatus.CoverageUnknown])
+
+	return nil
+}
@@ -0,0 +1,78 @@
+// Copyright 2026 k8s-enc-image-operator authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// keysyncctl is a command line tool for the key sync operator, it can be
+// installed as the kubectl plugin kubectl-keysync
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+)
+
+// command is a keysyncctl subcommand
+type command struct {
+	description string
+	run         func(args []string) error
+}
+
+var commands = map[string]command{
+	"coverage": {
+		description: "summarize which nodes hold the keys of a secret",
+		run:         coverageCmd,
+	},
+	"sign": {
+		description: "sign a key secret manifest for the secret verification of the key sync",
+		run:         signCmd,
+	},
+	"split": {
+		description: "split a key into shamir-share secrets of which a threshold reconstructs it",
+		run:         splitCmd,
+	},
+	"wrap": {
+		description: "wrap a key with a local AES KEK into an aes-wrapped-key secret",
+		run:         wrapCmd,
+	},
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s <command> [flags]\n\nCommands:\n", os.Args[0])
+	names := []string{}
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-12s %s\n", name, commands[name].description)
+	}
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		usage()
+		os.Exit(2)
+	}
+
+	if err := cmd.run(os.Args[2:]); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright 2026 k8s-enc-image-operator authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/lumjjb/k8s-enc-image-operator/secsign"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// signCmd signs a key secret manifest and prints it with the signature
+// annotation, or prints the payload to sign with an external tool
+func signCmd(args []string) error {
+	fs := flag.NewFlagSet("sign", flag.ExitOnError)
+	in := fs.String("in", "",
+		"file of the secret manifest to sign")
+	keyFile := fs.String("keyFile", "",
+		"PEM file of the ECDSA, RSA or Ed25519 private signing key")
+	namespace := fs.String("namespace", "",
+		"(optional) namespace of the secret if not set in the manifest, the namespace is signed")
+	payload := fs.Bool("payload", false,
+		"(optional) print the payload to sign instead, e.g. with cosign sign-blob, and add the base64 signature as the "+
+			secsign.SignatureAnnotation+" annotation")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *in == "" || (*keyFile == "" && !*payload) {
+		return errors.New("in and keyFile are required")
+	}
+
+	data, err := os.ReadFile(filepath.Clean(*in))
+	if err != nil {
+		return err
+	}
+	secret := &corev1.Secret{}
+	if err := yaml.Unmarshal(data, secret); err != nil {
+		return errors.Wrap(err, "unable to parse secret")
+	}
+	if secret.Namespace == "" {
+		secret.Namespace = *namespace
+	}
+
+	if *payload {
+		p, err := secsign.Payload(secret)
+		if err != nil {
+			return err
+		}
+		_, err = os.Stdout.Write(p)
+		return err
+	}
+
+	priv, err := loadPrivateKeyFile(*keyFile)
+	if err != nil {
+		return err
+	}
+	sig, err := secsign.Sign(secret, priv)
+	if err != nil {
+		return err
+	}
+
+	if secret.Annotations == nil {
+		secret.Annotations = map[string]string{}
+	}
+	secret.Annotations[secsign.SignatureAnnotation] = sig
+
+	out, err := yaml.Marshal(secret)
+	if err != nil {
+		return err
+	}
+	fmt.Print(string(out))
+	return nil
+}
+
+// loadPrivateKeyFile loads a PKCS8, EC or PKCS1 PEM private key
+func loadPrivateKeyFile(filename string) (crypto.Signer, error) {
+	data, err := os.ReadFile(filepath.Clean(filename))
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.Errorf("no PEM block in %v", filename)
+	}
+
+	var key interface{}
+	switch block.Type {
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to parse private key %v", filename)
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, errors.Errorf("unsupported private key type %T", key)
+	}
+	return signer, nil
+}
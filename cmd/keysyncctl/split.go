@@ -0,0 +1,100 @@
+// Copyright 2026 k8s-enc-image-operator authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/lumjjb/k8s-enc-image-operator/keysync"
+	"github.com/lumjjb/k8s-enc-image-operator/shamir"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// splitCmd splits a key into Shamir shares and prints the manifests of the
+// shamir-share secrets, one per share
+func splitCmd(args []string) error {
+	fs := flag.NewFlagSet("split", flag.ExitOnError)
+	in := fs.String("in", "",
+		"file of the key to split")
+	group := fs.String("group", "",
+		"name of the group of the shares, the secrets are named <group>-<n>")
+	shares := fs.Int("shares", 3,
+		"(optional) number of shares to split the key into")
+	threshold := fs.Int("threshold", 2,
+		"(optional) number of shares required to reconstruct the key")
+	namespace := fs.String("namespace", "enc-key-sync",
+		"(optional) namespace of the secrets")
+	filename := fs.String("filename", "",
+		"(optional) name of the synced key file, defaults to the name of the key file")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *in == "" || *group == "" {
+		return errors.New("in and group are required")
+	}
+
+	key, err := os.ReadFile(filepath.Clean(*in))
+	if err != nil {
+		return err
+	}
+
+	if *filename == "" {
+		*filename = filepath.Base(*in)
+	}
+
+	parts, err := shamir.Split(key, *shares, *threshold)
+	if err != nil {
+		return err
+	}
+	digest := sha256.Sum256(key)
+
+	for i, part := range parts {
+		secret := &corev1.Secret{
+			TypeMeta: metav1.TypeMeta{
+				APIVersion: "v1",
+				Kind:       "Secret",
+			},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      fmt.Sprintf("%s-%d", *group, i+1),
+				Namespace: *namespace,
+			},
+			Type: keysync.ShamirSecretType,
+			Data: map[string][]byte{
+				keysync.ShamirGroupField:     []byte(*group),
+				keysync.ShamirThresholdField: []byte(strconv.Itoa(*threshold)),
+				keysync.ShamirFilenameField:  []byte(*filename),
+				keysync.ShamirDigestField:    []byte(hex.EncodeToString(digest[:])),
+				keysync.ShamirShareField:     part,
+			},
+		}
+
+		out, err := yaml.Marshal(secret)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("---\n%s", out)
+	}
+	return nil
+}
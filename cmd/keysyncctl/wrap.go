@@ -0,0 +1,97 @@
+// Copyright 2026 k8s-enc-image-operator authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/lumjjb/k8s-enc-image-operator/aeswrap"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// wrapCmd wraps a key with a local AES KEK and prints the aes-wrapped-key
+// secret manifest
+func wrapCmd(args []string) error {
+	fs := flag.NewFlagSet("wrap", flag.ExitOnError)
+	kekFile := fs.String("kekFile", "",
+		"file of the raw 16, 24 or 32 byte AES KEK to wrap the key with")
+	kid := fs.String("kid", "",
+		"key ID of the KEK, i.e. its name in the KEK directory or secret")
+	alg := fs.String("alg", aeswrap.AlgGCM,
+		"(optional) algorithm to wrap the key with, aes-gcm or aes-kwp")
+	in := fs.String("in", "",
+		"file of the key to wrap")
+	name := fs.String("name", "",
+		"name of the secret")
+	namespace := fs.String("namespace", "enc-key-sync",
+		"(optional) namespace of the secret")
+	filename := fs.String("filename", "",
+		"(optional) name of the synced key file, defaults to the name of the key file")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *kekFile == "" || *kid == "" || *in == "" || *name == "" {
+		return errors.New("kekFile, kid, in and name are required")
+	}
+
+	kek, err := os.ReadFile(filepath.Clean(*kekFile))
+	if err != nil {
+		return err
+	}
+	key, err := os.ReadFile(filepath.Clean(*in))
+	if err != nil {
+		return err
+	}
+
+	if *filename == "" {
+		*filename = filepath.Base(*in)
+	}
+
+	wrapper, err := aeswrap.NewAESSecretKeyWrapper(*kid, kek, *alg, *filename)
+	if err != nil {
+		return err
+	}
+	data, err := wrapper(key)
+	if err != nil {
+		return err
+	}
+
+	secret := &corev1.Secret{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "v1",
+			Kind:       "Secret",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      *name,
+			Namespace: *namespace,
+		},
+		Type: aeswrap.SecretType,
+		Data: data,
+	}
+
+	out, err := yaml.Marshal(secret)
+	if err != nil {
+		return err
+	}
+	fmt.Print(string(out))
+	return nil
+}
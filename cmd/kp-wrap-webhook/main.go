@@ -0,0 +1,95 @@
+// Copyright 2026 k8s-enc-image-operator authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"net/http"
+	"strings"
+	"time"
+
+	keyprotect "github.com/lumjjb/k8s-enc-image-operator/keyprotect"
+	"github.com/lumjjb/k8s-enc-image-operator/webhook"
+	"github.com/sirupsen/logrus"
+)
+
+func main() {
+	inputFlags := struct {
+		addr                 string
+		tlsCertFile          string
+		tlsKeyFile           string
+		namespaces           string
+		keyprotectConfigFile string
+		rootKeyID            string
+	}{
+		addr:                 ":8443",
+		tlsCertFile:          "/etc/webhook/certs/tls.crt",
+		tlsKeyFile:           "/etc/webhook/certs/tls.key",
+		namespaces:           "",
+		keyprotectConfigFile: "",
+		rootKeyID:            "",
+	}
+
+	flag.StringVar(&inputFlags.addr, "addr", inputFlags.addr,
+		"(optional) address to serve the webhook on")
+	flag.StringVar(&inputFlags.tlsCertFile, "tlsCertFile", inputFlags.tlsCertFile,
+		"(optional) TLS certificate file to serve the webhook with")
+	flag.StringVar(&inputFlags.tlsKeyFile, "tlsKeyFile", inputFlags.tlsKeyFile,
+		"(optional) TLS private key file to serve the webhook with")
+	flag.StringVar(&inputFlags.namespaces, "namespaces", inputFlags.namespaces,
+		"comma separated list of namespaces where plaintext key secrets are wrapped")
+	flag.StringVar(&inputFlags.keyprotectConfigFile, "keyprotectConfigFile", inputFlags.keyprotectConfigFile,
+		"config file for keyprotect enablement")
+	flag.StringVar(&inputFlags.rootKeyID, "rootKeyID", inputFlags.rootKeyID,
+		"keyprotect root key id to wrap keys with")
+	flag.Parse()
+
+	if inputFlags.namespaces == "" {
+		panic("no namespaces specified")
+	}
+
+	if inputFlags.keyprotectConfigFile == "" {
+		panic("no keyprotect config file specified")
+	}
+
+	kpskw, err := keyprotect.GetSecKeyWrapperFromConfigFile(inputFlags.keyprotectConfigFile, inputFlags.rootKeyID)
+	if err != nil {
+		panic(err)
+	}
+
+	kwc := webhook.KeyWrapWebhookConfig{
+		Namespaces:        strings.Split(inputFlags.namespaces, ","),
+		WrappedSecretType: "kp-key",
+		KeyWrapper:        kpskw,
+	}
+	wh := webhook.NewKeyWrapWebhook(kwc)
+
+	mux := http.NewServeMux()
+	mux.Handle("/wrap", wh)
+
+	server := &http.Server{
+		Addr:              inputFlags.addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	logrus.Printf("Starting keyprotect key wrapping webhook on %v for namespaces %v",
+		inputFlags.addr,
+		kwc.Namespaces)
+
+	if err := server.ListenAndServeTLS(inputFlags.tlsCertFile, inputFlags.tlsKeyFile); err != nil {
+		logrus.Fatalf("Webhook failure: %v", err)
+	}
+}
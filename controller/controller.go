@@ -0,0 +1,319 @@
+// Copyright 2026 k8s-enc-image-operator authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/lumjjb/k8s-enc-image-operator/apis/v1alpha1"
+
+	"github.com/sirupsen/logrus"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic"
+	clientset "k8s.io/client-go/kubernetes"
+)
+
+// EncKeySyncControllerConfig contains the parameters required for operation of
+// the EncKeySync controller
+type EncKeySyncControllerConfig struct {
+	// K8sClient is the k8s clientset to interface with the kubernetes
+	// cluster
+	K8sClient clientset.Interface
+
+	// DynamicClient is the k8s dynamic client to interface with the
+	// EncKeySync custom resources
+	DynamicClient dynamic.Interface
+
+	// Interval is the interval in which to reconcile the EncKeySyncs
+	Interval time.Duration
+
+	// Namespace specifies the namespace of the EncKeySyncs to reconcile,
+	// if empty EncKeySyncs of all namespaces are reconciled
+	Namespace string
+
+	// DefaultImage is the key sync daemon image used when the EncKeySync
+	// does not specify one
+	DefaultImage string
+}
+
+// EncKeySyncController reconciles EncKeySync custom resources into the key
+// sync daemonset and its RBAC resources
+type EncKeySyncController struct {
+	// k8sClient is the k8s clientset to interface with the kubernetes
+	// cluster
+	k8sClient clientset.Interface
+
+	// dynamicClient is the k8s dynamic client to interface with the
+	// EncKeySync custom resources
+	dynamicClient dynamic.Interface
+
+	// interval is the interval in which to reconcile the EncKeySyncs
+	interval time.Duration
+
+	// namespace specifies the namespace of the EncKeySyncs to reconcile
+	namespace string
+
+	// defaultImage is the key sync daemon image used when the EncKeySync
+	// does not specify one
+	defaultImage string
+}
+
+func NewEncKeySyncController(ekscc EncKeySyncControllerConfig) *EncKeySyncController {
+	return &EncKeySyncController{
+		k8sClient:     ekscc.K8sClient,
+		dynamicClient: ekscc.DynamicClient,
+		interval:      ekscc.Interval,
+		namespace:     ekscc.Namespace,
+		defaultImage:  ekscc.DefaultImage,
+	}
+}
+
+// Start begins running the EncKeySyncController according to the parameters
+// specified.
+// Only one instance of Start should be run per EncKeySyncController
+func (c *EncKeySyncController) Start() error {
+	for {
+		c.reconcileAll(context.Background())
+		<-time.After(c.interval)
+	}
+}
+
+// reconcileAll reconciles all the EncKeySyncs, errors are logged and
+// reconciling is done on a best effort basis
+func (c *EncKeySyncController) reconcileAll(ctx context.Context) {
+	list, err := c.dynamicClient.Resource(v1alpha1.EncKeySyncResource).Namespace(c.namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		logrus.Errorf("Error listing EncKeySyncs: %v", err)
+		return
+	}
+
+	for i := range list.Items {
+		var eks v1alpha1.EncKeySync
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(list.Items[i].Object, &eks); err != nil {
+			logrus.Errorf("Unable to decode EncKeySync %s/%s: %v", list.Items[i].GetNamespace(), list.Items[i].GetName(), err)
+			continue
+		}
+
+		if eks.GetDeletionTimestamp() != nil {
+			continue
+		}
+
+		if err := c.reconcile(ctx, &eks); err != nil {
+			logrus.Errorf("Unable to reconcile EncKeySync %s/%s: %v", eks.GetNamespace(), eks.GetName(), err)
+		}
+	}
+}
+
+// reconcile applies the resources of the EncKeySync and updates its status
+func (c *EncKeySyncController) reconcile(ctx context.Context, eks *v1alpha1.EncKeySync) error {
+	status := eks.Status
+	status.Conditions = append([]metav1.Condition{}, eks.Status.Conditions...)
+	status.ObservedGeneration = eks.GetGeneration()
+
+	if err := validateSpec(eks.Spec); err != nil {
+		meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+			Type:               v1alpha1.ConditionValid,
+			Status:             metav1.ConditionFalse,
+			Reason:             "InvalidSpec",
+			Message:            err.Error(),
+			ObservedGeneration: eks.GetGeneration(),
+		})
+		return c.updateStatus(ctx, eks, &status)
+	}
+
+	meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+		Type:               v1alpha1.ConditionValid,
+		Status:             metav1.ConditionTrue,
+		Reason:             "ValidSpec",
+		ObservedGeneration: eks.GetGeneration(),
+	})
+
+	if err := c.applyServiceAccount(ctx, desiredServiceAccount(eks)); err != nil {
+		return err
+	}
+	if err := c.applyRole(ctx, desiredRole(eks)); err != nil {
+		return err
+	}
+	if err := c.applyRoleBinding(ctx, desiredRoleBinding(eks)); err != nil {
+		return err
+	}
+	ds, err := c.applyDaemonSet(ctx, c.desiredDaemonSet(eks))
+	if err != nil {
+		return err
+	}
+
+	setRolloutStatus(&status, ds, eks.GetGeneration())
+
+	return c.updateStatus(ctx, eks, &status)
+}
+
+// setRolloutStatus sets the rollout counts and the Progressing and Available
+// conditions of the status from the daemonset
+func setRolloutStatus(status *v1alpha1.EncKeySyncStatus, ds *appsv1.DaemonSet, generation int64) {
+	dss := ds.Status
+	status.DesiredNumberScheduled = dss.DesiredNumberScheduled
+	status.UpdatedNumberScheduled = dss.UpdatedNumberScheduled
+	status.NumberAvailable = dss.NumberAvailable
+
+	progressing := dss.ObservedGeneration < ds.GetGeneration() ||
+		dss.UpdatedNumberScheduled < dss.DesiredNumberScheduled
+	available := !progressing && dss.NumberAvailable >= dss.DesiredNumberScheduled
+
+	rollout := fmt.Sprintf("%d of %d nodes updated, %d available",
+		dss.UpdatedNumberScheduled, dss.DesiredNumberScheduled, dss.NumberAvailable)
+
+	progressingCond := metav1.Condition{
+		Type:               v1alpha1.ConditionProgressing,
+		Status:             metav1.ConditionFalse,
+		Reason:             "RolloutComplete",
+		Message:            rollout,
+		ObservedGeneration: generation,
+	}
+	if progressing {
+		progressingCond.Status = metav1.ConditionTrue
+		progressingCond.Reason = "RollingOut"
+	}
+	meta.SetStatusCondition(&status.Conditions, progressingCond)
+
+	availableCond := metav1.Condition{
+		Type:               v1alpha1.ConditionAvailable,
+		Status:             metav1.ConditionFalse,
+		Reason:             "NodesUnavailable",
+		Message:            rollout,
+		ObservedGeneration: generation,
+	}
+	if available {
+		availableCond.Status = metav1.ConditionTrue
+		availableCond.Reason = "AllNodesAvailable"
+	}
+	meta.SetStatusCondition(&status.Conditions, availableCond)
+}
+
+// updateStatus updates the status of the EncKeySync if it changed
+func (c *EncKeySyncController) updateStatus(ctx context.Context, eks *v1alpha1.EncKeySync, status *v1alpha1.EncKeySyncStatus) error {
+	if equality.Semantic.DeepEqual(&eks.Status, status) {
+		return nil
+	}
+
+	updated := *eks
+	updated.Status = *status
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&updated)
+	if err != nil {
+		return err
+	}
+
+	u := &unstructured.Unstructured{Object: obj}
+	u.SetAPIVersion(v1alpha1.SchemeGroupVersion.String())
+	u.SetKind("EncKeySync")
+
+	_, err = c.dynamicClient.Resource(v1alpha1.EncKeySyncResource).Namespace(eks.GetNamespace()).UpdateStatus(ctx, u, metav1.UpdateOptions{})
+	return err
+}
+
+// applyServiceAccount creates the service account if it does not exist
+func (c *EncKeySyncController) applyServiceAccount(ctx context.Context, desired *corev1.ServiceAccount) error {
+	client := c.k8sClient.CoreV1().ServiceAccounts(desired.GetNamespace())
+	_, err := client.Get(ctx, desired.GetName(), metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		logrus.Printf("Creating service account %s/%s", desired.GetNamespace(), desired.GetName())
+		_, err = client.Create(ctx, desired, metav1.CreateOptions{})
+	}
+	return err
+}
+
+// applyRole creates the role or updates its rules if they differ
+func (c *EncKeySyncController) applyRole(ctx context.Context, desired *rbacv1.Role) error {
+	client := c.k8sClient.RbacV1().Roles(desired.GetNamespace())
+	existing, err := client.Get(ctx, desired.GetName(), metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		logrus.Printf("Creating role %s/%s", desired.GetNamespace(), desired.GetName())
+		_, err = client.Create(ctx, desired, metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	if equality.Semantic.DeepEqual(existing.Rules, desired.Rules) {
+		return nil
+	}
+
+	logrus.Printf("Updating role %s/%s", desired.GetNamespace(), desired.GetName())
+	existing.Rules = desired.Rules
+	_, err = client.Update(ctx, existing, metav1.UpdateOptions{})
+	return err
+}
+
+// applyRoleBinding creates the role binding or updates it if it differs
+func (c *EncKeySyncController) applyRoleBinding(ctx context.Context, desired *rbacv1.RoleBinding) error {
+	client := c.k8sClient.RbacV1().RoleBindings(desired.GetNamespace())
+	existing, err := client.Get(ctx, desired.GetName(), metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		logrus.Printf("Creating role binding %s/%s", desired.GetNamespace(), desired.GetName())
+		_, err = client.Create(ctx, desired, metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	if equality.Semantic.DeepEqual(existing.Subjects, desired.Subjects) &&
+		equality.Semantic.DeepEqual(existing.RoleRef, desired.RoleRef) {
+		return nil
+	}
+
+	// The role ref of a role binding is immutable, so it is recreated
+	logrus.Printf("Recreating role binding %s/%s", desired.GetNamespace(), desired.GetName())
+	if err = client.Delete(ctx, desired.GetName(), metav1.DeleteOptions{}); err != nil {
+		return err
+	}
+	_, err = client.Create(ctx, desired, metav1.CreateOptions{})
+	return err
+}
+
+// applyDaemonSet creates the daemonset or updates its spec if it differs, and
+// returns the current daemonset
+func (c *EncKeySyncController) applyDaemonSet(ctx context.Context, desired *appsv1.DaemonSet) (*appsv1.DaemonSet, error) {
+	client := c.k8sClient.AppsV1().DaemonSets(desired.GetNamespace())
+	existing, err := client.Get(ctx, desired.GetName(), metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		logrus.Printf("Creating daemonset %s/%s", desired.GetNamespace(), desired.GetName())
+		return client.Create(ctx, desired, metav1.CreateOptions{})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// The existing spec contains defaulted fields, so compare the hash of
+	// the desired spec it was last applied with instead
+	if existing.GetAnnotations()[specHashAnnotation] == desired.GetAnnotations()[specHashAnnotation] {
+		return existing, nil
+	}
+
+	logrus.Printf("Updating daemonset %s/%s", desired.GetNamespace(), desired.GetName())
+	existing.Spec = desired.Spec
+	existing.SetAnnotations(desired.GetAnnotations())
+	return client.Update(ctx, existing, metav1.UpdateOptions{})
+}
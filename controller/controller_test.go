@@ -0,0 +1,171 @@
+// Copyright 2026 k8s-enc-image-operator authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lumjjb/k8s-enc-image-operator/apis/v1alpha1"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// newEncKeySync returns an unstructured EncKeySync with the spec
+func newEncKeySync(t *testing.T, name string, spec v1alpha1.EncKeySyncSpec) *unstructured.Unstructured {
+	eks := &v1alpha1.EncKeySync{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: v1alpha1.SchemeGroupVersion.String(),
+			Kind:       "EncKeySync",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       name,
+			Namespace:  "enc-key-sync",
+			Generation: 1,
+		},
+		Spec: spec,
+	}
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(eks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &unstructured.Unstructured{Object: obj}
+}
+
+// getEncKeySync returns the typed EncKeySync from the dynamic client
+func getEncKeySync(t *testing.T, c *EncKeySyncController, name string) *v1alpha1.EncKeySync {
+	u, err := c.dynamicClient.Resource(v1alpha1.EncKeySyncResource).Namespace("enc-key-sync").Get(context.Background(), name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var eks v1alpha1.EncKeySync
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, &eks); err != nil {
+		t.Fatal(err)
+	}
+	return &eks
+}
+
+// TestReconcile runs through reconciling a valid and an invalid EncKeySync
+func TestReconcile(t *testing.T) {
+	valid := newEncKeySync(t, "valid", v1alpha1.EncKeySyncSpec{
+		Interval:           &metav1.Duration{Duration: 30 * time.Second},
+		KeysDir:            "/etc/crio/keys/enc-key-sync",
+		KeyFilePermissions: "0640",
+		Handlers: []v1alpha1.HandlerConfig{
+			{
+				Type:            v1alpha1.HandlerTypeKeyprotect,
+				ConfigSecretRef: v1alpha1.SecretKeyRef{Name: "kp-config", Key: "kp.json"},
+			},
+		},
+		NodeSelector: map[string]string{"encrypted-images": "true"},
+	})
+	invalid := newEncKeySync(t, "invalid", v1alpha1.EncKeySyncSpec{
+		KeysDir: "relative/keys",
+	})
+
+	scheme := runtime.NewScheme()
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(scheme,
+		map[schema.GroupVersionResource]string{v1alpha1.EncKeySyncResource: "EncKeySyncList"},
+		valid, invalid)
+	fakeClient := fake.NewClientset()
+
+	c := NewEncKeySyncController(EncKeySyncControllerConfig{
+		K8sClient:     fakeClient,
+		DynamicClient: dynamicClient,
+		Namespace:     "enc-key-sync",
+		DefaultImage:  "keysync:test",
+	})
+
+	c.reconcileAll(context.Background())
+
+	// The valid EncKeySync has its resources created
+	ds, err := fakeClient.AppsV1().DaemonSets("enc-key-sync").Get(context.Background(), "valid", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Daemonset should have been created: %v", err)
+	}
+	podSpec := ds.Spec.Template.Spec
+	args := strings.Join(podSpec.Containers[0].Args, " ")
+	expectedArgs := "-dir /keys -lock -lockFile /run/enc-key-sync/%2Fetc%2Fcrio%2Fkeys%2Fenc-key-sync.lock -interval 30 -keyFilePermissions 0640 -keyprotectConfigKubeSecret kp-config -keyprotectConfigKubeSecretKey kp.json"
+	if args != expectedArgs {
+		t.Fatalf("Unexpected daemon args, expected %q, got %q", expectedArgs, args)
+	}
+	if podSpec.Containers[0].Image != "keysync:test" || podSpec.NodeSelector["encrypted-images"] != "true" {
+		t.Fatalf("Unexpected pod spec: %+v", podSpec)
+	}
+	if podSpec.Volumes[0].HostPath.Path != "/etc/crio/keys/enc-key-sync" {
+		t.Fatalf("Unexpected host path %v", podSpec.Volumes[0].HostPath.Path)
+	}
+	if podSpec.Volumes[1].HostPath.Path != "/run/enc-key-sync" || podSpec.Containers[0].VolumeMounts[1].MountPath != "/run/enc-key-sync" {
+		t.Fatalf("Unexpected lock directory volume %+v", podSpec.Volumes[1])
+	}
+	if _, err := fakeClient.RbacV1().RoleBindings("enc-key-sync").Get(context.Background(), "valid-rb", metav1.GetOptions{}); err != nil {
+		t.Fatalf("Role binding should have been created: %v", err)
+	}
+
+	eks := getEncKeySync(t, c, "valid")
+	if !meta.IsStatusConditionTrue(eks.Status.Conditions, v1alpha1.ConditionValid) {
+		t.Fatalf("Valid EncKeySync should have Valid condition: %+v", eks.Status.Conditions)
+	}
+	if eks.Status.ObservedGeneration != 1 {
+		t.Fatalf("Unexpected observed generation %v", eks.Status.ObservedGeneration)
+	}
+
+	// The invalid EncKeySync reports the validation error
+	if _, err := fakeClient.AppsV1().DaemonSets("enc-key-sync").Get(context.Background(), "invalid", metav1.GetOptions{}); err == nil {
+		t.Fatal("Daemonset should not have been created for invalid EncKeySync")
+	}
+	eks = getEncKeySync(t, c, "invalid")
+	cond := meta.FindStatusCondition(eks.Status.Conditions, v1alpha1.ConditionValid)
+	if cond == nil || cond.Status != metav1.ConditionFalse || !strings.Contains(cond.Message, "keysDir") {
+		t.Fatalf("Invalid EncKeySync should report validation error: %+v", eks.Status.Conditions)
+	}
+
+	// Reconciling again without changes does not update the daemonset
+	c.reconcileAll(context.Background())
+	ds2, err := fakeClient.AppsV1().DaemonSets("enc-key-sync").Get(context.Background(), "valid", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ds2.GetResourceVersion() != ds.GetResourceVersion() {
+		t.Fatal("Daemonset should not be updated without spec changes")
+	}
+}
+
+// TestLockFile tests that the daemons syncing the same keys directory share
+// the lock file, and the others do not
+func TestLockFile(t *testing.T) {
+	for _, tc := range []struct {
+		a, b string
+		same bool
+	}{
+		{"/etc/crio/keys", "/etc/crio/keys/", true},
+		{"/etc/crio/keys", "/etc/crio//keys", true},
+		{"/etc/crio/keys", "/etc/crio-keys", false},
+		{"/etc/crio/keys", "/etc/crio%2Fkeys", false},
+		{"/etc/crio/keys", "/etc/crio/keys/enc-key-sync", false},
+	} {
+		if same := lockFile(tc.a) == lockFile(tc.b); same != tc.same {
+			t.Fatalf("Expected the lock files of %v and %v to be the same %v, got %v and %v", tc.a, tc.b, tc.same, lockFile(tc.a), lockFile(tc.b))
+		}
+	}
+}
@@ -0,0 +1,302 @@
+// Copyright 2026 k8s-enc-image-operator authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/lumjjb/k8s-enc-image-operator/apis/v1alpha1"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// keysMountPath is the path the host keys directory is mounted at in
+	// the key sync daemon container
+	keysMountPath = "/keys"
+
+	// lockDir is the host directory of the lock files of the key
+	// directories, mounted at the same path in the key sync daemon
+	// container, so that the daemons of every EncKeySync on the node
+	// syncing the same keys directory take the same lock
+	lockDir = "/run/enc-key-sync"
+
+	// enckeysyncLabel is the label identifying the EncKeySync the resources
+	// belong to
+	enckeysyncLabel = "oci.crypt/enckeysync"
+
+	// specHashAnnotation is the annotation containing the hash of the
+	// desired daemonset spec, used to detect changes to the spec
+	specHashAnnotation = "oci.crypt/spec-hash"
+
+	// namespaceEnv is the environment variable the key sync daemon reads
+	// its namespace from
+	namespaceEnv = "POD_NAMESPACE"
+
+	// nodeNameEnv is the environment variable the key sync daemon reads
+	// its node name from
+	nodeNameEnv = "NODE_NAME"
+)
+
+// resourceNames returns the names of the daemonset, service account, role and
+// role binding of the EncKeySync
+func resourceNames(eks *v1alpha1.EncKeySync) (ds, sa, role, rb string) {
+	name := eks.GetName()
+	return name, name + "-sa", name + "-r", name + "-rb"
+}
+
+// objectMeta returns the object metadata for a resource belonging to the
+// EncKeySync, which is owned by the EncKeySync so that it is garbage collected
+// on deletion
+func objectMeta(eks *v1alpha1.EncKeySync, name string) metav1.ObjectMeta {
+	controller := true
+	return metav1.ObjectMeta{
+		Name:      name,
+		Namespace: eks.GetNamespace(),
+		Labels: map[string]string{
+			"app":           "enc-key-sync",
+			enckeysyncLabel: eks.GetName(),
+		},
+		OwnerReferences: []metav1.OwnerReference{
+			{
+				APIVersion: v1alpha1.SchemeGroupVersion.String(),
+				Kind:       "EncKeySync",
+				Name:       eks.GetName(),
+				UID:        eks.GetUID(),
+				Controller: &controller,
+			},
+		},
+	}
+}
+
+// desiredServiceAccount returns the service account of the key sync daemon
+func desiredServiceAccount(eks *v1alpha1.EncKeySync) *corev1.ServiceAccount {
+	_, sa, _, _ := resourceNames(eks)
+	return &corev1.ServiceAccount{
+		ObjectMeta: objectMeta(eks, sa),
+	}
+}
+
+// desiredRole returns the role of the key sync daemon, which allows reading
+// the key secrets
+func desiredRole(eks *v1alpha1.EncKeySync) *rbacv1.Role {
+	_, _, role, _ := resourceNames(eks)
+	r := &rbacv1.Role{
+		ObjectMeta: objectMeta(eks, role),
+		Rules: []rbacv1.PolicyRule{
+			{
+				APIGroups: []string{""},
+				Resources: []string{"secrets"},
+				Verbs:     []string{"get", "list", "watch"},
+			},
+		},
+	}
+
+	// Used for openshift to mount hostPath
+	if eks.Spec.IsOpenShift {
+		r.Rules = append(r.Rules, rbacv1.PolicyRule{
+			APIGroups:     []string{"security.openshift.io"},
+			Resources:     []string{"securitycontextconstraints"},
+			Verbs:         []string{"use"},
+			ResourceNames: []string{"privileged"},
+		})
+	}
+
+	return r
+}
+
+// desiredRoleBinding returns the role binding of the key sync daemon role to
+// its service account
+func desiredRoleBinding(eks *v1alpha1.EncKeySync) *rbacv1.RoleBinding {
+	_, sa, role, rb := resourceNames(eks)
+	return &rbacv1.RoleBinding{
+		ObjectMeta: objectMeta(eks, rb),
+		Subjects: []rbacv1.Subject{
+			{
+				Kind:      rbacv1.ServiceAccountKind,
+				Name:      sa,
+				Namespace: eks.GetNamespace(),
+			},
+		},
+		RoleRef: rbacv1.RoleRef{
+			APIGroup: rbacv1.GroupName,
+			Kind:     "Role",
+			Name:     role,
+		},
+	}
+}
+
+// desiredDaemonSet returns the daemonset running the key sync daemon on the
+// selected nodes
+func (c *EncKeySyncController) desiredDaemonSet(eks *v1alpha1.EncKeySync) *appsv1.DaemonSet {
+	ds, sa, _, _ := resourceNames(eks)
+	spec := eks.Spec
+
+	image := spec.Image
+	if image == "" {
+		image = c.defaultImage
+	}
+
+	podLabels := map[string]string{
+		"name":          ds,
+		enckeysyncLabel: eks.GetName(),
+	}
+
+	container := corev1.Container{
+		Name:            "enc-key-sync",
+		Image:           image,
+		ImagePullPolicy: corev1.PullAlways,
+		Args:            daemonArgs(spec),
+		Env: []corev1.EnvVar{
+			{
+				Name: namespaceEnv,
+				ValueFrom: &corev1.EnvVarSource{
+					FieldRef: &corev1.ObjectFieldSelector{
+						FieldPath: "metadata.namespace",
+					},
+				},
+			},
+			{
+				Name: nodeNameEnv,
+				ValueFrom: &corev1.EnvVarSource{
+					FieldRef: &corev1.ObjectFieldSelector{
+						FieldPath: "spec.nodeName",
+					},
+				},
+			},
+		},
+		VolumeMounts: []corev1.VolumeMount{
+			{
+				Name:      "hostkeys",
+				MountPath: keysMountPath,
+			},
+			{
+				Name:      "hostlock",
+				MountPath: lockDir,
+			},
+		},
+	}
+
+	// privileged required for openshift because of SELinux restricting access to certain hostPaths
+	if spec.IsOpenShift {
+		privileged := true
+		container.SecurityContext = &corev1.SecurityContext{
+			Privileged: &privileged,
+		}
+	}
+
+	hostPathType := corev1.HostPathDirectoryOrCreate
+	terminationGracePeriod := int64(30)
+
+	d := &appsv1.DaemonSet{
+		ObjectMeta: objectMeta(eks, ds),
+		Spec: appsv1.DaemonSetSpec{
+			Selector: &metav1.LabelSelector{
+				MatchLabels: podLabels,
+			},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: podLabels,
+				},
+				Spec: corev1.PodSpec{
+					ServiceAccountName:            sa,
+					Containers:                    []corev1.Container{container},
+					NodeSelector:                  spec.NodeSelector,
+					Tolerations:                   spec.Tolerations,
+					TerminationGracePeriodSeconds: &terminationGracePeriod,
+					Volumes: []corev1.Volume{
+						{
+							Name: "hostkeys",
+							VolumeSource: corev1.VolumeSource{
+								HostPath: &corev1.HostPathVolumeSource{
+									Path: spec.KeysDir,
+									Type: &hostPathType,
+								},
+							},
+						},
+						{
+							Name: "hostlock",
+							VolumeSource: corev1.VolumeSource{
+								HostPath: &corev1.HostPathVolumeSource{
+									Path: lockDir,
+									Type: &hostPathType,
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	d.SetAnnotations(map[string]string{
+		specHashAnnotation: specHash(d.Spec),
+	})
+
+	return d
+}
+
+// specHash returns the hash of the daemonset spec
+func specHash(spec appsv1.DaemonSetSpec) string {
+	// Marshalling of the spec does not fail, and an empty hash only causes
+	// an additional update
+	data, _ := json.Marshal(spec)
+	return fmt.Sprintf("%x", sha256.Sum256(data))
+}
+
+// daemonArgs returns the arguments of the key sync daemon for the spec, the
+// spec is expected to have been validated
+func daemonArgs(spec v1alpha1.EncKeySyncSpec) []string {
+	args := []string{"-dir", keysMountPath, "-lock", "-lockFile", lockFile(spec.KeysDir)}
+
+	if spec.Interval != nil {
+		args = append(args, "-interval", fmt.Sprintf("%d", spec.Interval.Duration/time.Second))
+	}
+
+	if spec.KeyFilePermissions != "" {
+		args = append(args, "-keyFilePermissions", spec.KeyFilePermissions)
+	}
+
+	if spec.KeyFileOwnership != "" {
+		args = append(args, "-keyFileOwnership", spec.KeyFileOwnership)
+	}
+
+	for _, h := range spec.Handlers {
+		switch h.Type {
+		case v1alpha1.HandlerTypeKeyprotect:
+			args = append(args, "-keyprotectConfigKubeSecret", h.ConfigSecretRef.Name)
+			if h.ConfigSecretRef.Key != "" {
+				args = append(args, "-keyprotectConfigKubeSecretKey", h.ConfigSecretRef.Key)
+			}
+		}
+	}
+
+	return args
+}
+
+// lockFile returns the lock file of the keys directory on the host, in the
+// lockDir named after the escaped path of the keys directory
+func lockFile(keysDir string) string {
+	escaped := strings.NewReplacer("%", "%25", "/", "%2F").Replace(path.Clean(keysDir))
+	return path.Join(lockDir, escaped+".lock")
+}
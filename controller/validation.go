@@ -0,0 +1,75 @@
+// Copyright 2026 k8s-enc-image-operator authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/lumjjb/k8s-enc-image-operator/apis/v1alpha1"
+
+	"github.com/pkg/errors"
+)
+
+// validateSpec returns an error describing the first invalid field of the
+// EncKeySync spec
+func validateSpec(spec v1alpha1.EncKeySyncSpec) error {
+	if spec.KeysDir == "" || !filepath.IsAbs(spec.KeysDir) {
+		return errors.Errorf("keysDir must be an absolute path, got %q", spec.KeysDir)
+	}
+
+	if spec.Interval != nil {
+		if spec.Interval.Duration < time.Second || spec.Interval.Duration%time.Second != 0 {
+			return errors.Errorf("interval must be a positive number of seconds, got %v", spec.Interval.Duration)
+		}
+	}
+
+	if spec.KeyFilePermissions != "" {
+		var perm uint32
+		n, err := fmt.Sscanf(spec.KeyFilePermissions, "%o", &perm)
+		if err != nil || n != 1 || perm > 0777 {
+			return errors.Errorf("invalid keyFilePermissions %q", spec.KeyFilePermissions)
+		}
+	}
+
+	if spec.KeyFileOwnership != "" {
+		var uid, gid int
+		n, err := fmt.Sscanf(spec.KeyFileOwnership, "%d:%d", &uid, &gid)
+		if err != nil || n != 2 || uid < 0 || gid < 0 {
+			return errors.Errorf("invalid keyFileOwnership %q, expected UID:GID", spec.KeyFileOwnership)
+		}
+	}
+
+	seen := map[string]bool{}
+	for _, h := range spec.Handlers {
+		switch h.Type {
+		case v1alpha1.HandlerTypeKeyprotect:
+		default:
+			return errors.Errorf("unknown handler type %q", h.Type)
+		}
+
+		if seen[h.Type] {
+			return errors.Errorf("handler type %q configured more than once", h.Type)
+		}
+		seen[h.Type] = true
+
+		if h.ConfigSecretRef.Name == "" {
+			return errors.Errorf("handler %q requires configSecretRef name", h.Type)
+		}
+	}
+
+	return nil
+}
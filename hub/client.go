@@ -0,0 +1,138 @@
+// Copyright 2026 k8s-enc-image-operator authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hub
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/lumjjb/k8s-enc-image-operator/apis/v1alpha1"
+	"github.com/lumjjb/k8s-enc-image-operator/keysync"
+	"github.com/lumjjb/k8s-enc-image-operator/nodekey"
+
+	"github.com/pkg/errors"
+)
+
+// HubClientConfig contains the parameters required for retrieving the keys
+// from the hub
+type HubClientConfig struct {
+	// URL is the base URL of the hub
+	URL string
+
+	// TokenFile is the file of the projected service account token to
+	// authenticate with, it is read on every request as it is rotated
+	TokenFile string
+
+	// TLSConfig is the TLS config with the CA of the hub and the client
+	// certificate
+	TLSConfig *tls.Config
+
+	// Timeout is the timeout of the requests to the hub
+	Timeout time.Duration
+
+	// KeyPair is the node-local keypair the keys have to be wrapped to, if
+	// nil keys are expected unwrapped
+	KeyPair *nodekey.KeyPair
+}
+
+// HubClient retrieves the keys the node is entitled to from the hub, and
+// unwraps them with the node-local keypair. It implements keysync.KeySource.
+type HubClient struct {
+	// url is the url of the keys of the hub
+	url string
+
+	// tokenFile is the file of the service account token
+	tokenFile string
+
+	// httpClient is the client to interface with the hub
+	httpClient *http.Client
+
+	// keyPair is the node-local keypair the keys have to be wrapped to
+	keyPair *nodekey.KeyPair
+}
+
+func NewHubClient(hcc HubClientConfig) *HubClient {
+	return &HubClient{
+		url:       strings.TrimSuffix(hcc.URL, "/") + KeysPath,
+		tokenFile: hcc.TokenFile,
+		keyPair:   hcc.KeyPair,
+		httpClient: &http.Client{
+			Timeout: hcc.Timeout,
+			Transport: &http.Transport{
+				TLSClientConfig: hcc.TLSConfig,
+			},
+		},
+	}
+}
+
+// Keys retrieves the keys and the errors processing the secrets from the hub
+func (c *HubClient) Keys(ctx context.Context) ([]keysync.SourceKey, []v1alpha1.SyncError, error) {
+	token, err := os.ReadFile(filepath.Clean(c.tokenFile))
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "unable to read service account token")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, nil, errors.Errorf("hub returned %v: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	var kr KeysResponse
+	if err := json.NewDecoder(resp.Body).Decode(&kr); err != nil {
+		return nil, nil, errors.Wrap(err, "unable to decode keys")
+	}
+
+	if c.keyPair == nil {
+		if kr.PublicKey != "" {
+			return nil, nil, errors.New("hub returned wrapped keys without a node keypair")
+		}
+		return kr.Keys, kr.Errors, nil
+	}
+
+	// Keys wrapped to a previous keypair of the node are retrieved again
+	// once the public key of the current keypair has been published
+	if kr.PublicKey != c.keyPair.PublicKey() {
+		return nil, nil, errors.Errorf("hub returned keys not wrapped to the public key of the node (wrapped to %q)", kr.PublicKey)
+	}
+
+	for i := range kr.Keys {
+		data, err := c.keyPair.Unwrap(kr.Keys[i].Data)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "unable to unwrap key of secret %s", kr.Keys[i].Secret.Name)
+		}
+		kr.Keys[i].Data = data
+	}
+
+	return kr.Keys, kr.Errors, nil
+}
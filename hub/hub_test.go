@@ -0,0 +1,258 @@
+// Copyright 2026 k8s-enc-image-operator authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hub
+
+import (
+	"context"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lumjjb/k8s-enc-image-operator/apis/v1alpha1"
+	"github.com/lumjjb/k8s-enc-image-operator/nodekey"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	coretesting "k8s.io/client-go/testing"
+)
+
+// fakeTokenReviews reviews tokens of the form <namespace>:<service account>:<node>
+func fakeTokenReviews(fakeClient *fake.Clientset) {
+	fakeClient.PrependReactor("create", "tokenreviews", func(action coretesting.Action) (handled bool, ret runtime.Object, err error) {
+		tr := action.(coretesting.CreateAction).GetObject().(*authenticationv1.TokenReview)
+		parts := strings.Split(tr.Spec.Token, ":")
+		if len(parts) != 3 || len(tr.Spec.Audiences) != 1 || tr.Spec.Audiences[0] != DefaultAudience {
+			tr.Status.Error = "invalid token"
+			return true, tr, nil
+		}
+		tr.Status.Authenticated = true
+		tr.Status.User = authenticationv1.UserInfo{
+			Username: serviceAccountUsernamePrefix + parts[0] + ":" + parts[1],
+			Extra:    map[string]authenticationv1.ExtraValue{nodeNameExtra: {parts[2]}},
+		}
+		return true, tr, nil
+	})
+}
+
+// writeToken writes the token to a token file in the directory
+func writeToken(t *testing.T, dir, token string) string {
+	tokenFile := filepath.Join(dir, token)
+	if err := os.WriteFile(tokenFile, []byte(token), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return tokenFile
+}
+
+// TestHubEntitlements runs through refreshing the keys on the hub and nodes
+// retrieving the keys they are entitled to
+func TestHubEntitlements(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "hub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err = os.RemoveAll(tmpDir) // clean up
+		if err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	namespace := "enc-key-sync"
+	fakeClient := fake.NewClientset(
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-a", Labels: map[string]string{"zone": "a"}}},
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-b", Labels: map[string]string{"zone": "b"}}},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "all-nodes", Namespace: namespace, ResourceVersion: "1"},
+			Data:       map[string][]byte{"key1": []byte("this is key 1")},
+			Type:       "wrapped-key",
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            "zone-a",
+				Namespace:       namespace,
+				ResourceVersion: "1",
+				Annotations:     map[string]string{NodeSelectorAnnotation: "zone=a"},
+			},
+			Data: map[string][]byte{"key2": []byte("this is key 2")},
+			Type: "wrapped-key",
+		},
+	)
+
+	// Only return secrets of the type listed
+	fakeClient.PrependReactor("list", "secrets", func(action coretesting.Action) (handled bool, ret runtime.Object, err error) {
+		la := action.(coretesting.ListAction)
+		if la.GetListRestrictions().Fields.String() != "type=wrapped-key" {
+			return true, &corev1.SecretList{}, nil
+		}
+		return false, nil, nil
+	})
+
+	fakeTokenReviews(fakeClient)
+
+	hs := NewHubServer(HubServerConfig{
+		K8sClient:           fakeClient,
+		Interval:            time.Second,
+		Namespace:           namespace,
+		ServiceAccounts:     []string{namespace + "/enc-key-sync-sa"},
+		RequiredSecretTypes: []string{"wrapped-key"},
+	})
+
+	server := httptest.NewServer(hs)
+	defer server.Close()
+
+	newClient := func(token string) *HubClient {
+		return NewHubClient(HubClientConfig{URL: server.URL, TokenFile: writeToken(t, tmpDir, token), Timeout: time.Second})
+	}
+
+	clientA := newClient(namespace + ":enc-key-sync-sa:node-a")
+	clientB := newClient(namespace + ":enc-key-sync-sa:node-b")
+
+	// Keys are not served before the required handler is added
+	if err := hs.refresh(context.Background()); err == nil {
+		t.Fatal("Refresh should fail without required handler")
+	}
+	if _, _, err := clientA.Keys(context.Background()); err == nil || !strings.Contains(err.Error(), "503") {
+		t.Fatalf("Keys should not be served before refresh, got %v", err)
+	}
+
+	processed := 0
+	hs.AddSecretKeyHandler("wrapped-key", func(data map[string][]byte) (map[string][]byte, error) {
+		processed++
+		return data, nil
+	})
+	for i := 0; i < 2; i++ {
+		if err := hs.refresh(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if processed != 2 {
+		t.Fatalf("Secrets should be processed once, processed %d times", processed)
+	}
+
+	keys, _, err := clientA.Keys(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 {
+		t.Fatalf("Node in zone a should get 2 keys, got %v", keys)
+	}
+
+	keys, _, err = clientB.Keys(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || keys[0].Secret.Name != "all-nodes" || string(keys[0].Data) != "this is key 1" {
+		t.Fatalf("Node in zone b should only get the key of all nodes, got %v", keys)
+	}
+
+	// Other service accounts and invalid tokens are rejected
+	if _, _, err := newClient("default:default:node-a").Keys(context.Background()); err == nil || !strings.Contains(err.Error(), "403") {
+		t.Fatalf("Other service account should be forbidden, got %v", err)
+	}
+	if _, _, err := newClient("invalid").Keys(context.Background()); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Fatalf("Invalid token should be unauthorized, got %v", err)
+	}
+}
+
+// TestHubNodeKeyWrapping tests that keys are served wrapped to the public key
+// published by the node, and can only be unwrapped by the node
+func TestHubNodeKeyWrapping(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "hub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err = os.RemoveAll(tmpDir) // clean up
+		if err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	namespace := "enc-key-sync"
+	fakeClient := fake.NewClientset(
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "my-secret", Namespace: namespace, ResourceVersion: "1"},
+			Data:       map[string][]byte{"mykey": []byte("this is a key")},
+			Type:       "key",
+		},
+	)
+	fakeTokenReviews(fakeClient)
+
+	keyPair, err := nodekey.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nodeStatus := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": v1alpha1.SchemeGroupVersion.String(),
+		"kind":       "EncKeyNodeStatus",
+		"metadata":   map[string]interface{}{"name": "node-a"},
+		"status":     map[string]interface{}{"publicKey": keyPair.PublicKey()},
+	}}
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{v1alpha1.EncKeyNodeStatusResource: "EncKeyNodeStatusList"}, nodeStatus)
+
+	hs := NewHubServer(HubServerConfig{
+		K8sClient:              fakeClient,
+		DynamicClient:          dynamicClient,
+		Interval:               time.Second,
+		Namespace:              namespace,
+		ServiceAccounts:        []string{namespace + "/enc-key-sync-sa"},
+		RequireNodeKeyWrapping: true,
+	})
+	if err := hs.refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(hs)
+	defer server.Close()
+
+	newClient := func(token string, kp *nodekey.KeyPair) *HubClient {
+		return NewHubClient(HubClientConfig{URL: server.URL, TokenFile: writeToken(t, tmpDir, token), Timeout: time.Second, KeyPair: kp})
+	}
+
+	keys, _, err := newClient(namespace+":enc-key-sync-sa:node-a", keyPair).Keys(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || string(keys[0].Data) != "this is a key" {
+		t.Fatalf("Node should get the unwrapped key, got %v", keys)
+	}
+
+	// The response is wrapped to the node
+	token := writeToken(t, tmpDir, namespace+":enc-key-sync-sa:node-a")
+	otherKeyPair, err := nodekey.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := NewHubClient(HubClientConfig{URL: server.URL, TokenFile: token, Timeout: time.Second, KeyPair: otherKeyPair})
+	if _, _, err := other.Keys(context.Background()); err == nil {
+		t.Fatal("Keys wrapped to the node should not unwrap with another keypair")
+	}
+
+	// Nodes without a published public key are not served
+	if _, _, err := newClient(namespace+":enc-key-sync-sa:node-b", nil).Keys(context.Background()); err == nil || !strings.Contains(err.Error(), "403") {
+		t.Fatalf("Node without public key should be forbidden, got %v", err)
+	}
+}
@@ -0,0 +1,575 @@
+// Copyright 2026 k8s-enc-image-operator authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hub
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lumjjb/k8s-enc-image-operator/apis/v1alpha1"
+	"github.com/lumjjb/k8s-enc-image-operator/keysync"
+	"github.com/lumjjb/k8s-enc-image-operator/keysync/sechandlers"
+	"github.com/lumjjb/k8s-enc-image-operator/nodekey"
+	"github.com/lumjjb/k8s-enc-image-operator/secsign"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/dynamic"
+	clientset "k8s.io/client-go/kubernetes"
+)
+
+const (
+	// KeysPath is the path the hub serves the keys the caller is entitled to
+	KeysPath = "/v1/keys"
+
+	// HealthzPath is the path the hub serves its readiness on, it is ready
+	// once the keys have been refreshed with all of the required handlers
+	HealthzPath = "/healthz"
+
+	// DefaultAudience is the audience the service account tokens of the
+	// node daemons are bound to
+	DefaultAudience = "enc-key-sync-hub"
+
+	// NodeSelectorAnnotation on a secret restricts the nodes entitled to
+	// its keys to the nodes matching the label selector
+	NodeSelectorAnnotation = "oci.crypt/node-selector"
+
+	keyTypeFieldSelectorPrefix = "type="
+
+	// nodeNameExtra is the extra info of a service account token bound to
+	// a pod containing the name of the node of the pod
+	nodeNameExtra = "authentication.kubernetes.io/node-name"
+
+	serviceAccountUsernamePrefix = "system:serviceaccount:"
+)
+
+// KeysResponse is the response of the hub to a request for keys
+type KeysResponse struct {
+	// Keys are the key files the caller is entitled to
+	Keys []keysync.SourceKey `json:"keys"`
+
+	// Errors are the errors processing the secrets the caller is entitled
+	// to
+	Errors []v1alpha1.SyncError `json:"errors"`
+
+	// PublicKey is the public key of the node the key data is wrapped to
+	// with nodekey.Wrap, empty if the key data is not wrapped
+	PublicKey string `json:"publicKey,omitempty"`
+}
+
+// HubServerConfig contains the parameters required for operation of the hub
+// server
+type HubServerConfig struct {
+	// K8sClient is the k8s clientset to interface with the kubernetes
+	// cluster
+	K8sClient clientset.Interface
+
+	// Interval is the query interval in which to refresh the keys
+	Interval time.Duration
+
+	// Namespace specifies the namespace where key secrets are stored
+	Namespace string
+
+	// Audience is the audience the service account tokens of the callers
+	// have to be bound to
+	Audience string
+
+	// ServiceAccounts are the service accounts entitled to keys, in
+	// namespace/name format
+	ServiceAccounts []string
+
+	// RequiredSecretTypes specifies the secret types whose handlers are
+	// added asynchronously, and that the hub waits for before serving keys,
+	// so that nodes do not remove the keys of these types
+	RequiredSecretTypes []string
+
+	// DynamicClient is the k8s dynamic client to retrieve the public keys
+	// of the nodes from their EncKeyNodeStatus, if nil keys are not wrapped
+	DynamicClient dynamic.Interface
+
+	// RequireNodeKeyWrapping specifies that keys are only served wrapped to
+	// the public key of the node of the caller
+	RequireNodeKeyWrapping bool
+
+	// SecretVerifier verifies the secrets before they are processed, secrets
+	// failing verification are not served, if nil secrets are not verified
+	SecretVerifier keysync.SecretVerifier
+}
+
+// HubServer unwraps the key secrets with the key handlers centrally and serves
+// the key files to the node daemons, so that the nodes do not require the
+// handler configs. Callers authenticate with service account tokens, which are
+// validated with a TokenReview.
+type HubServer struct {
+	// k8sClient is the k8s clientset to interface with the kubernetes
+	// cluster
+	k8sClient clientset.Interface
+
+	// interval is the query interval in which to refresh the keys
+	interval time.Duration
+
+	// namespace specifies the namespace where key secrets are stored
+	namespace string
+
+	// audience is the audience the service account tokens of the callers
+	// have to be bound to
+	audience string
+
+	// serviceAccounts are the service accounts entitled to keys
+	serviceAccounts map[string]bool
+
+	// requiredSecretTypes specifies the secret types whose handlers the hub
+	// waits for before serving keys
+	requiredSecretTypes []string
+
+	// dynamicClient is the k8s dynamic client to retrieve the public keys
+	// of the nodes
+	dynamicClient dynamic.Interface
+
+	// requireNodeKeyWrapping specifies that keys are only served wrapped
+	requireNodeKeyWrapping bool
+
+	// secretVerifier verifies the secrets before they are processed
+	secretVerifier keysync.SecretVerifier
+
+	// keyHandlers maps the secret types to the handlers processing them,
+	// see keysync.KeySyncServer
+	keyHandlers map[string]sechandlers.SecretKeyHandler
+
+	// keyHandlersMutex to handle concurrency for keyHandlers
+	keyHandlersMutex *sync.Mutex
+
+	// secrets are the processed secrets of the last refresh, nil if the
+	// keys have not been refreshed with all of the required handlers yet
+	secrets []*hubSecret
+
+	// secretsMutex to handle concurrency for secrets
+	secretsMutex *sync.RWMutex
+}
+
+// hubSecret is a secret processed by the hub
+type hubSecret struct {
+	// secret is the reference to the secret version
+	secret v1alpha1.SecretReference
+
+	// nodeSelector selects the nodes entitled to the keys of the secret,
+	// nil if all nodes are entitled
+	nodeSelector labels.Selector
+
+	// keys are the key files of the secret
+	keys []keysync.SourceKey
+
+	// err is the error processing the secret, empty if processed
+	err string
+}
+
+// caller is the authenticated caller of the hub
+type caller struct {
+	// serviceAccount is the service account of the caller, in
+	// namespace/name format
+	serviceAccount string
+
+	// nodeName is the node of the pod the token is bound to, empty if the
+	// token is not bound to a pod
+	nodeName string
+}
+
+func NewHubServer(hsc HubServerConfig) *HubServer {
+	hs := HubServer{
+		k8sClient:              hsc.K8sClient,
+		interval:               hsc.Interval,
+		namespace:              hsc.Namespace,
+		audience:               hsc.Audience,
+		serviceAccounts:        map[string]bool{},
+		requiredSecretTypes:    hsc.RequiredSecretTypes,
+		dynamicClient:          hsc.DynamicClient,
+		keyHandlers:            map[string]sechandlers.SecretKeyHandler{},
+		keyHandlersMutex:       &sync.Mutex{},
+		secretsMutex:           &sync.RWMutex{},
+		requireNodeKeyWrapping: hsc.RequireNodeKeyWrapping,
+		secretVerifier:         hsc.SecretVerifier,
+	}
+
+	if hs.audience == "" {
+		hs.audience = DefaultAudience
+	}
+
+	for _, sa := range hsc.ServiceAccounts {
+		hs.serviceAccounts[sa] = true
+	}
+
+	// add the regular key type to the list of special key handlers
+	hs.keyHandlers["key"] = sechandlers.RegularKeyHandler
+
+	return &hs
+}
+
+// AddSecretKeyHandler adds a handler to the hub that will take effect on the
+// next refresh, see keysync.KeySyncServer.AddSecretKeyHandler
+func (hs *HubServer) AddSecretKeyHandler(secretType string, skh sechandlers.SecretKeyHandler) {
+	hs.keyHandlersMutex.Lock()
+	defer hs.keyHandlersMutex.Unlock()
+
+	hs.keyHandlers[secretType] = skh
+}
+
+// Start begins refreshing the keys according to the parameters specified.
+// Only one instance of Start should be run per HubServer
+func (hs *HubServer) Start(ctx context.Context) {
+	for {
+		if err := hs.refresh(ctx); err != nil {
+			logrus.Errorf("Unable to refresh keys: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(hs.interval):
+		}
+	}
+}
+
+// refresh lists and processes the secrets of all handled types. Secrets of the
+// same version are not processed again, so that the keys are not unwrapped on
+// every refresh. The keys are not replaced if any of the secrets could not be
+// listed, so that nodes do not remove keys.
+func (hs *HubServer) refresh(ctx context.Context) error {
+	hs.keyHandlersMutex.Lock()
+	handlers := map[string]sechandlers.SecretKeyHandler{}
+	for k, v := range hs.keyHandlers {
+		handlers[k] = v
+	}
+	hs.keyHandlersMutex.Unlock()
+
+	missing := []string{}
+	for _, secType := range hs.requiredSecretTypes {
+		if handlers[secType] == nil {
+			missing = append(missing, secType)
+		}
+	}
+	if len(missing) > 0 {
+		return errors.Errorf("handlers for secret types %v not configured", missing)
+	}
+
+	hs.secretsMutex.RLock()
+	previous := map[v1alpha1.SecretReference]*hubSecret{}
+	for _, s := range hs.secrets {
+		if s.err == "" {
+			previous[s.secret] = s
+		}
+	}
+	hs.secretsMutex.RUnlock()
+
+	secClient := hs.k8sClient.CoreV1().Secrets(hs.namespace)
+	secrets := []*hubSecret{}
+	for secType, skh := range handlers {
+		secList, err := secClient.List(ctx, metav1.ListOptions{
+			FieldSelector: keyTypeFieldSelectorPrefix + secType,
+		})
+		if err != nil {
+			return errors.Wrapf(err, "unable to list secrets of type %s", secType)
+		}
+
+		for _, s := range secList.Items {
+			secrets = append(secrets, hs.processSecret(&s, skh, previous))
+		}
+	}
+
+	sort.Slice(secrets, func(i, j int) bool {
+		a, b := secrets[i].secret, secrets[j].secret
+		if a.Type != b.Type {
+			return a.Type < b.Type
+		}
+		return a.Name < b.Name
+	})
+
+	hs.secretsMutex.Lock()
+	hs.secrets = secrets
+	hs.secretsMutex.Unlock()
+
+	return nil
+}
+
+// processSecret verifies the secret and processes it with the handler, unless
+// the same version was processed previously
+func (hs *HubServer) processSecret(s *corev1.Secret, skh sechandlers.SecretKeyHandler, previous map[v1alpha1.SecretReference]*hubSecret) *hubSecret {
+	namespace := s.GetNamespace()
+	if namespace == "" {
+		namespace = metav1.NamespaceDefault
+	}
+
+	secRef := v1alpha1.SecretReference{
+		Namespace:       namespace,
+		Name:            s.GetName(),
+		Type:            string(s.Type),
+		ResourceVersion: s.GetResourceVersion(),
+	}
+
+	hsec := &hubSecret{secret: secRef}
+
+	if hs.secretVerifier != nil {
+		if err := hs.secretVerifier.Verify(s); err != nil {
+			hsec.err = "secret verification failed: " + err.Error()
+			return hsec
+		}
+	}
+
+	if selector, ok := s.GetAnnotations()[NodeSelectorAnnotation]; ok {
+		sel, err := labels.Parse(selector)
+		if err != nil {
+			logrus.Errorf("Invalid node selector of secret %s: %v", s.GetName(), err)
+			hsec.err = "invalid node selector: " + err.Error()
+			return hsec
+		}
+		hsec.nodeSelector = sel
+	}
+
+	notBefore, notAfter, err := keysync.ValidityWindow(s)
+	if err != nil {
+		logrus.Errorf("Invalid validity window of secret %s: %v", s.GetName(), err)
+		hsec.err = "invalid validity window: " + err.Error()
+		return hsec
+	}
+
+	if p, ok := previous[secRef]; ok && secRef.ResourceVersion != "" {
+		hsec.keys = p.keys
+		return hsec
+	}
+
+	// The signature is not a key
+	files, err := skh(secsign.SignedData(s))
+	if err != nil {
+		logrus.Errorf("Unable to process secret %s: %v", s.GetName(), err)
+		hsec.err = "unable to process secret: " + err.Error()
+		return hsec
+	}
+
+	filenames := []string{}
+	for filename := range files {
+		filenames = append(filenames, filename)
+	}
+	sort.Strings(filenames)
+
+	for _, filename := range filenames {
+		hsec.keys = append(hsec.keys, keysync.SourceKey{
+			Filename:  filename,
+			Data:      files[filename],
+			Secret:    secRef,
+			Labels:    s.GetLabels(),
+			NotBefore: notBefore,
+			NotAfter:  notAfter,
+			Delivery:  keysync.DeliveryAnnotations(s.GetAnnotations()),
+		})
+	}
+	return hsec
+}
+
+// ServeHTTP serves the keys the caller is entitled to on KeysPath, requiring a
+// verified client certificate when served over TLS, and the readiness of the
+// hub on HealthzPath
+func (hs *HubServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	hs.secretsMutex.RLock()
+	secrets := hs.secrets
+	hs.secretsMutex.RUnlock()
+
+	switch r.URL.Path {
+	case HealthzPath:
+		if secrets == nil {
+			http.Error(w, "keys not refreshed", http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		return
+	case KeysPath:
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if r.TLS != nil && len(r.TLS.VerifiedChains) == 0 {
+		logrus.Errorf("No client certificate in request from %v", r.RemoteAddr)
+		http.Error(w, "client certificate required", http.StatusUnauthorized)
+		return
+	}
+
+	c, err := hs.authenticate(r)
+	if err != nil {
+		logrus.Errorf("Unable to authenticate request from %v: %v", r.RemoteAddr, err)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if !hs.serviceAccounts[c.serviceAccount] {
+		logrus.Errorf("Service account %v is not entitled to keys", c.serviceAccount)
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	// Serving no keys would remove the keys from the nodes
+	if secrets == nil {
+		http.Error(w, "keys not refreshed", http.StatusServiceUnavailable)
+		return
+	}
+
+	resp, err := hs.entitledKeys(r.Context(), c, secrets)
+	if err != nil {
+		logrus.Errorf("Unable to determine keys of %v on node %v: %v", c.serviceAccount, c.nodeName, err)
+		http.Error(w, "unable to determine keys", http.StatusInternalServerError)
+		return
+	}
+
+	if err := hs.wrapKeys(r.Context(), c, resp); err != nil {
+		logrus.Errorf("Unable to wrap keys of %v on node %v: %v", c.serviceAccount, c.nodeName, err)
+		http.Error(w, "unable to wrap keys", http.StatusForbidden)
+		return
+	}
+
+	logrus.Printf("Serving %d keys to %v on node %v (wrapped: %v)", len(resp.Keys), c.serviceAccount, c.nodeName, resp.PublicKey != "")
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		logrus.Errorf("Unable to write response: %v", err)
+	}
+}
+
+// authenticate validates the bearer token of the request with a TokenReview
+// and returns the service account and node of the token
+func (hs *HubServer) authenticate(r *http.Request) (*caller, error) {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" || token == r.Header.Get("Authorization") {
+		return nil, errors.New("no bearer token")
+	}
+
+	tr, err := hs.k8sClient.AuthenticationV1().TokenReviews().Create(r.Context(), &authenticationv1.TokenReview{
+		Spec: authenticationv1.TokenReviewSpec{
+			Token:     token,
+			Audiences: []string{hs.audience},
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to review token")
+	}
+
+	if !tr.Status.Authenticated {
+		return nil, errors.Errorf("token not authenticated: %v", tr.Status.Error)
+	}
+
+	if !strings.HasPrefix(tr.Status.User.Username, serviceAccountUsernamePrefix) {
+		return nil, errors.Errorf("user %v is not a service account", tr.Status.User.Username)
+	}
+
+	c := &caller{
+		serviceAccount: strings.Replace(strings.TrimPrefix(tr.Status.User.Username, serviceAccountUsernamePrefix), ":", "/", 1),
+	}
+	if nodeName := tr.Status.User.Extra[nodeNameExtra]; len(nodeName) == 1 {
+		c.nodeName = nodeName[0]
+	}
+
+	return c, nil
+}
+
+// entitledKeys returns the keys and errors of the secrets the caller is
+// entitled to. Secrets with a node selector are only served to callers with
+// tokens bound to a pod on a matching node.
+func (hs *HubServer) entitledKeys(ctx context.Context, c *caller, secrets []*hubSecret) (*KeysResponse, error) {
+	resp := &KeysResponse{
+		Keys:   []keysync.SourceKey{},
+		Errors: []v1alpha1.SyncError{},
+	}
+
+	var nodeLabels labels.Set
+	nodeFetched := false
+	for _, s := range secrets {
+		if s.nodeSelector != nil {
+			if c.nodeName == "" {
+				continue
+			}
+			if !nodeFetched {
+				node, err := hs.k8sClient.CoreV1().Nodes().Get(ctx, c.nodeName, metav1.GetOptions{})
+				if err != nil {
+					return nil, err
+				}
+				nodeLabels = labels.Set(node.GetLabels())
+				nodeFetched = true
+			}
+			if !s.nodeSelector.Matches(nodeLabels) {
+				continue
+			}
+		}
+
+		if s.err != "" {
+			resp.Errors = append(resp.Errors, v1alpha1.SyncError{Secret: s.secret, Message: s.err})
+			continue
+		}
+		resp.Keys = append(resp.Keys, s.keys...)
+	}
+
+	return resp, nil
+}
+
+// wrapKeys wraps the key data of the response to the public key published in
+// the EncKeyNodeStatus of the node of the caller. An error is returned if the
+// keys have to be wrapped but the node has not published a public key.
+func (hs *HubServer) wrapKeys(ctx context.Context, c *caller, resp *KeysResponse) error {
+	publicKey := ""
+	if hs.dynamicClient != nil && c.nodeName != "" {
+		ns, err := hs.dynamicClient.Resource(v1alpha1.EncKeyNodeStatusResource).Get(ctx, c.nodeName, metav1.GetOptions{})
+		if err != nil && !k8serrors.IsNotFound(err) {
+			return err
+		}
+		if err == nil {
+			publicKey, _, _ = unstructured.NestedString(ns.Object, "status", "publicKey")
+		}
+	}
+
+	if publicKey == "" {
+		if hs.requireNodeKeyWrapping {
+			return errors.Errorf("no public key published for node %q", c.nodeName)
+		}
+		return nil
+	}
+
+	// The keys are shared with the refreshed secrets, so they are copied
+	// instead of wrapped in place
+	keys := make([]keysync.SourceKey, 0, len(resp.Keys))
+	for _, k := range resp.Keys {
+		wrapped, err := nodekey.Wrap(publicKey, k.Data)
+		if err != nil {
+			return err
+		}
+		k.Data = wrapped
+		keys = append(keys, k)
+	}
+
+	resp.Keys = keys
+	resp.PublicKey = publicKey
+	return nil
+}
@@ -64,3 +64,29 @@ func GetSecKeyHandlerFromConfig(data []byte) (sechandlers.SecretKeyHandler, erro
 
 	return secHandler, nil
 }
+
+// GetSecKeyWrapperFromConfigFile returns a secret key wrapper for key protect given a
+// configuration file for key protect and the root key id to wrap keys with
+func GetSecKeyWrapperFromConfigFile(kpconfigPath, rootKeyID string) (sechandlers.SecretKeyWrapper, error) {
+	data, err := os.ReadFile(filepath.Clean(kpconfigPath))
+	if err != nil {
+		return nil, err
+	}
+
+	return GetSecKeyWrapperFromConfig(data, rootKeyID)
+}
+
+// GetSecKeyWrapperFromConfig returns a secret key wrapper for key protect given a
+// configuration data for key protect and the root key id to wrap keys with
+func GetSecKeyWrapperFromConfig(data []byte, rootKeyID string) (sechandlers.SecretKeyWrapper, error) {
+	var kpc keyprotectConfig
+	err := json.Unmarshal(data, &kpc)
+	if err != nil {
+		return nil, err
+	}
+
+	return kpsh.NewKeyprotectSecretKeyWrapper(kpc.KeyprotectUrl,
+		kpc.InstanceId,
+		kpc.Apikey,
+		rootKeyID)
+}
@@ -0,0 +1,66 @@
+// Copyright 2026 k8s-enc-image-operator authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keyprotect
+
+import (
+	"context"
+	"time"
+
+	sechandlers "github.com/lumjjb/k8s-enc-image-operator/keysync/sechandlers"
+
+	"github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// ConfigKubeSecretThread is a helper function that tries to retrieve the kube secret containing the
+// keyprotect config and add the handler to the server, and again whenever the config changes.
+// Meant to run as a thread.
+func ConfigKubeSecretThread(clientset kubernetes.Interface, namespace string, secretName string, secretKey string, adder sechandlers.SecretKeyHandlerAdder, interval time.Duration) {
+	first := true
+	oldData := ""
+	for {
+		if !first {
+			<-time.After(interval)
+		} else {
+			first = false
+		}
+
+		secClient := clientset.CoreV1().Secrets(namespace)
+		s, err := secClient.Get(context.Background(), secretName, metav1.GetOptions{})
+		if err != nil {
+			continue
+		}
+
+		if s.Data != nil {
+			d := s.Data[secretKey]
+			if len(d) > 0 {
+				if string(d) == oldData {
+					continue
+				}
+				oldData = string(d)
+
+				logrus.Printf("New keyprotect config detected in secrets, configuring...")
+				kpskh, err := GetSecKeyHandlerFromConfig(d)
+				if err != nil {
+					// log err
+					logrus.Errorf("Unable to parse keyprotect config: %v", err)
+					continue
+				}
+				adder.AddSecretKeyHandler("kp-key", kpskh)
+			}
+		}
+	}
+}
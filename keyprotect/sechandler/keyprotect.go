@@ -26,6 +26,11 @@ type keyprotectSecretKeyHandler struct {
 	kpClient *kp.Client
 }
 
+type keyprotectSecretKeyWrapper struct {
+	kpClient  *kp.Client
+	rootKeyID string
+}
+
 // handleSecret unwraps the keys by calling the key protect unwrap service, returning a
 // map of key filenames to data to store. It returns a single key filename -> data map
 // in the keyprotect implementation to meet the sechandlers.SecretKeyHandler func definition
@@ -59,15 +64,27 @@ func (skh *keyprotectSecretKeyHandler) handleSecret(data map[string][]byte) (map
 	return retdata, nil
 }
 
-// NewKeyprotectSecretKeyHandler returns a secret handler for keyprotect given the keyprotect configuration
-func NewKeyprotectSecretKeyHandler(kpUrl, instanceid, apikey string) (sechandlers.SecretKeyHandler, error) {
-	cc := kp.ClientConfig{
-		BaseURL:    kpUrl,
-		APIKey:     apikey,
-		InstanceID: instanceid,
+// wrapKey wraps the key by calling the key protect wrap service, returning the
+// secret data expected by the keyprotect secret key handler, i.e. the
+// rootkeyid and the ciphertext
+func (skw *keyprotectSecretKeyWrapper) wrapKey(key []byte) (map[string][]byte, error) {
+	// Key protect requires a base64 payload, which is decoded again on unwrap
+	b64content := []byte(base64.StdEncoding.EncodeToString(key))
+
+	ciphertext, err := skw.kpClient.Wrap(context.TODO(), skw.rootKeyID, b64content, nil)
+	if err != nil {
+		return nil, err
 	}
 
-	kpClient, err := kp.New(cc, kp.DefaultTransport())
+	return map[string][]byte{
+		"rootkeyid":  []byte(skw.rootKeyID),
+		"ciphertext": ciphertext,
+	}, nil
+}
+
+// NewKeyprotectSecretKeyHandler returns a secret handler for keyprotect given the keyprotect configuration
+func NewKeyprotectSecretKeyHandler(kpUrl, instanceid, apikey string) (sechandlers.SecretKeyHandler, error) {
+	kpClient, err := newKeyprotectClient(kpUrl, instanceid, apikey)
 	if err != nil {
 		return nil, err
 	}
@@ -80,3 +97,36 @@ func NewKeyprotectSecretKeyHandler(kpUrl, instanceid, apikey string) (sechandler
 		return kpskh.handleSecret(data)
 	}, nil
 }
+
+// NewKeyprotectSecretKeyWrapper returns a secret key wrapper for keyprotect given the keyprotect
+// configuration and the root key to wrap keys with
+func NewKeyprotectSecretKeyWrapper(kpUrl, instanceid, apikey, rootkeyid string) (sechandlers.SecretKeyWrapper, error) {
+	if rootkeyid == "" {
+		return nil, errors.New("root key id required to wrap keys")
+	}
+
+	kpClient, err := newKeyprotectClient(kpUrl, instanceid, apikey)
+	if err != nil {
+		return nil, err
+	}
+
+	kpskw := keyprotectSecretKeyWrapper{
+		kpClient:  kpClient,
+		rootKeyID: rootkeyid,
+	}
+
+	return func(key []byte) (map[string][]byte, error) {
+		return kpskw.wrapKey(key)
+	}, nil
+}
+
+// newKeyprotectClient returns a keyprotect client given the keyprotect configuration
+func newKeyprotectClient(kpUrl, instanceid, apikey string) (*kp.Client, error) {
+	cc := kp.ClientConfig{
+		BaseURL:    kpUrl,
+		APIKey:     apikey,
+		InstanceID: instanceid,
+	}
+
+	return kp.New(cc, kp.DefaultTransport())
+}
@@ -0,0 +1,161 @@
+// Copyright 2026 k8s-enc-image-operator authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keysync
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// AtomicLayout is the layout of the key sync directory with the key files
+	// of each secret in <namespace>/<secret>/<file>, published together by
+	// atomically replacing the ..data symlink to a new version directory
+	AtomicLayout = "atomic"
+
+	// atomicDataLink is the symlink to the current version directory, the
+	// key files are symlinks into it
+	atomicDataLink = "..data"
+
+	// atomicVersionPrefix is the prefix of the version directories
+	atomicVersionPrefix = "..version_"
+
+	// atomicTmpPrefix is the prefix of the symlinks created before being
+	// renamed into place
+	atomicTmpPrefix = "..tmp_"
+)
+
+// atomicGroup is the key files of a secret in the AtomicLayout
+type atomicGroup struct {
+	// dir is the directory of the secret relative to the key sync directory
+	dir string
+
+	// keyFiles are the key files of the secret
+	keyFiles []keyFile
+}
+
+// currentAtomicFiles returns the files of the current version of the secret
+// directory relative to the key sync directory, the ..data symlink and the
+// key files in the version directory, nil if there is no current version
+func (s *sink) currentAtomicFiles(g atomicGroup) []string {
+	version, err := os.Readlink(filepath.Join(s.keySyncDir, g.dir, atomicDataLink))
+	if err != nil {
+		return nil
+	}
+
+	files := []string{path.Join(g.dir, atomicDataLink)}
+	for _, kf := range g.keyFiles {
+		files = append(files, path.Join(g.dir, version, path.Base(kf.filename)))
+	}
+	return files
+}
+
+// atomicGroupDrift returns the first drift of the key files of the secret,
+// empty if all the key files are symlinks into the current version directory
+// with their contents, permissions, owner and label
+func (s *sink) atomicGroupDrift(g atomicGroup) (keyFile, string) {
+	for _, kf := range g.keyFiles {
+		link, err := os.Readlink(filepath.Join(s.keySyncDir, kf.filename))
+		if err != nil || link != path.Join(atomicDataLink, path.Base(kf.filename)) {
+			return kf, DriftMissing
+		}
+		if drift := s.keyFileDrift(filepath.Join(s.keySyncDir, kf.filename), kf); drift != "" {
+			return kf, drift
+		}
+	}
+	return keyFile{}, ""
+}
+
+// atomicGroupUpdated returns whether any key file of the secret is new or was
+// updated since the previous sync, rather than drifted on disk
+func (s *sink) atomicGroupUpdated(g atomicGroup) bool {
+	for _, kf := range g.keyFiles {
+		if syncedHash, synced := s.syncedFiles[kf.filename]; !synced || syncedHash != kf.hash {
+			return true
+		}
+	}
+	return false
+}
+
+// syncAtomicKeyFiles writes the key files of the secret to a new version
+// directory and publishes them by atomically replacing the ..data symlink,
+// unless they are in sync. Returns the files of the current version, the
+// previous versions are removed by cleanupKeys.
+func (s *sink) syncAtomicKeyFiles(g atomicGroup) ([]string, error) {
+	kf, drift := s.atomicGroupDrift(g)
+	if drift == "" {
+		return s.currentAtomicFiles(g), nil
+	}
+	if s.atomicGroupUpdated(g) {
+		logrus.Printf("Syncing new keys of secret %v: %v", kf.secret.Name, g.dir)
+	} else {
+		logrus.Warnf("Restoring key %v, drifted on disk: %v", s.logName(kf.filename), drift)
+		keyFileDrifts.WithLabelValues(drift).Inc()
+	}
+
+	dir := filepath.Join(s.keySyncDir, g.dir)
+	if err := os.MkdirAll(dir, 0755); err != nil { // #nosec G301
+		return nil, err
+	}
+
+	// The key files themselves are protected by their permissions
+	versionDir, err := os.MkdirTemp(dir, atomicVersionPrefix)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(versionDir, 0755); err != nil { // #nosec G302
+		return nil, err
+	}
+	version := filepath.Base(versionDir)
+
+	for _, kf := range g.keyFiles {
+		if err := s.writeKeyFile(filepath.Join(versionDir, path.Base(kf.filename)), kf); err != nil {
+			return nil, errors.Wrapf(err, "unable to write key file %s", kf.filename)
+		}
+	}
+
+	if err := replaceSymlink(version, filepath.Join(dir, atomicDataLink)); err != nil {
+		return nil, errors.Wrap(err, "unable to publish version")
+	}
+
+	for _, kf := range g.keyFiles {
+		name := path.Base(kf.filename)
+		target := path.Join(atomicDataLink, name)
+		if link, err := os.Readlink(filepath.Join(dir, name)); err == nil && link == target {
+			continue
+		}
+		if err := replaceSymlink(target, filepath.Join(dir, name)); err != nil {
+			return nil, errors.Wrapf(err, "unable to link key file %s", kf.filename)
+		}
+	}
+
+	return s.currentAtomicFiles(g), nil
+}
+
+// replaceSymlink atomically replaces the file with a symlink to the target
+func replaceSymlink(target, file string) error {
+	tmpLink := filepath.Join(filepath.Dir(file), atomicTmpPrefix+filepath.Base(file))
+	if err := os.Remove(tmpLink); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Symlink(target, tmpLink); err != nil {
+		return err
+	}
+	return os.Rename(tmpLink, file)
+}
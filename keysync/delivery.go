@@ -0,0 +1,345 @@
+// Copyright 2026 k8s-enc-image-operator authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keysync
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/lumjjb/k8s-enc-image-operator/apis/v1alpha1"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"sigs.k8s.io/yaml"
+)
+
+// Annotations of a secret controlling the delivery of its key files, all but
+// KeysAnnotation have to be permitted by the delivery allowlist
+const (
+	// FileModeAnnotation is the octal permissions of the key files
+	FileModeAnnotation = "oci.crypt/file-mode"
+
+	// OwnerUIDAnnotation is the owner UID of the key files
+	OwnerUIDAnnotation = "oci.crypt/owner-uid"
+
+	// OwnerGIDAnnotation is the owner GID of the key files
+	OwnerGIDAnnotation = "oci.crypt/owner-gid"
+
+	// KeysAnnotation is the comma separated list of the key files of the
+	// secret to sync, the others are ignored
+	KeysAnnotation = "oci.crypt/keys"
+
+	// FilenameAnnotation is the name of the key file in the key sync
+	// directory instead of <sha256>-namespace-secret-key, the secret must have a
+	// single key file
+	FilenameAnnotation = "oci.crypt/filename"
+
+	// SubdirectoryAnnotation is the subdirectory of the key sync directory
+	// to sync the key files to
+	SubdirectoryAnnotation = "oci.crypt/subdirectory"
+)
+
+// deliveryAnnotations are the annotations controlling the delivery
+var deliveryAnnotations = []string{
+	FileModeAnnotation,
+	OwnerUIDAnnotation,
+	OwnerGIDAnnotation,
+	KeysAnnotation,
+	FilenameAnnotation,
+	SubdirectoryAnnotation,
+}
+
+// DeliveryAnnotations returns the delivery annotations of the annotations of a
+// secret, nil if there are none
+func DeliveryAnnotations(annotations map[string]string) map[string]string {
+	var delivery map[string]string
+	for _, a := range deliveryAnnotations {
+		if v, ok := annotations[a]; ok {
+			if delivery == nil {
+				delivery = map[string]string{}
+			}
+			delivery[a] = v
+		}
+	}
+	return delivery
+}
+
+// DeliveryAllowlist is the admin defined allowlist of the delivery options
+// secrets may request, i.e.
+//
+//	fileModes: ["0400", "0440"]
+//	ownerUIDs: [0]
+//	ownerGIDs: [0, 1000]
+//	filenames: ["*.pem"]
+//	subdirectories: ["crio", "containerd"]
+type DeliveryAllowlist struct {
+	// FileModes are the octal permissions secrets may set
+	FileModes []string `json:"fileModes,omitempty"`
+
+	// OwnerUIDs are the owner UIDs secrets may set
+	OwnerUIDs []int `json:"ownerUIDs,omitempty"`
+
+	// OwnerGIDs are the owner GIDs secrets may set
+	OwnerGIDs []int `json:"ownerGIDs,omitempty"`
+
+	// Filenames are the glob patterns of the filenames secrets may set
+	Filenames []string `json:"filenames,omitempty"`
+
+	// Subdirectories are the subdirectories of the key sync directory
+	// secrets may sync to, the key sync server removes obsolete files in
+	// these subdirectories
+	Subdirectories []string `json:"subdirectories,omitempty"`
+}
+
+// LoadDeliveryAllowlistFile loads the delivery allowlist from a YAML or JSON
+// file
+func LoadDeliveryAllowlistFile(filename string) (*DeliveryAllowlist, error) {
+	data, err := os.ReadFile(filepath.Clean(filename))
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to read delivery allowlist %v", filename)
+	}
+
+	var da DeliveryAllowlist
+	if err := yaml.UnmarshalStrict(data, &da); err != nil {
+		return nil, errors.Wrapf(err, "unable to parse delivery allowlist %v", filename)
+	}
+
+	for _, m := range da.FileModes {
+		if _, err := parseFileMode(m); err != nil {
+			return nil, err
+		}
+	}
+	for _, p := range da.Filenames {
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, errors.Wrapf(err, "invalid filename pattern %q", p)
+		}
+	}
+	for _, d := range da.Subdirectories {
+		if err := validPathComponent(d); err != nil {
+			return nil, errors.Wrapf(err, "invalid subdirectory %q", d)
+		}
+	}
+	return &da, nil
+}
+
+// deliveryOptions are the delivery options of the key files of a secret
+type deliveryOptions struct {
+	fileMode *os.FileMode
+	ownerUID *int
+	ownerGID *int
+	keys     []string
+	filename string
+	subdir   string
+}
+
+// parseDeliveryOptions parses the delivery annotations of a secret, and
+// validates them against the allowlist
+func parseDeliveryOptions(annotations map[string]string, allowlist *DeliveryAllowlist) (*deliveryOptions, error) {
+	if allowlist == nil {
+		allowlist = &DeliveryAllowlist{}
+	}
+	do := &deliveryOptions{}
+
+	if v, ok := annotations[FileModeAnnotation]; ok {
+		mode, err := parseFileMode(v)
+		if err != nil {
+			return nil, err
+		}
+		allowed := false
+		for _, m := range allowlist.FileModes {
+			if am, _ := parseFileMode(m); am == mode {
+				allowed = true
+			}
+		}
+		if !allowed {
+			return nil, errors.Errorf("file mode %v is not allowed", v)
+		}
+		do.fileMode = &mode
+	}
+
+	for _, o := range []struct {
+		annotation string
+		allowed    []int
+		id         **int
+	}{
+		{OwnerUIDAnnotation, allowlist.OwnerUIDs, &do.ownerUID},
+		{OwnerGIDAnnotation, allowlist.OwnerGIDs, &do.ownerGID},
+	} {
+		v, ok := annotations[o.annotation]
+		if !ok {
+			continue
+		}
+		id, err := strconv.Atoi(v)
+		if err != nil || id < 0 {
+			return nil, errors.Errorf("invalid %s annotation %q", o.annotation, v)
+		}
+		if !containsInt(o.allowed, id) {
+			return nil, errors.Errorf("%s %d is not allowed", o.annotation, id)
+		}
+		*o.id = &id
+	}
+
+	if v, ok := annotations[KeysAnnotation]; ok {
+		for _, k := range strings.Split(v, ",") {
+			if k = strings.TrimSpace(k); k != "" {
+				do.keys = append(do.keys, k)
+			}
+		}
+		if len(do.keys) == 0 {
+			return nil, errors.Errorf("no keys in %s annotation", KeysAnnotation)
+		}
+	}
+
+	if v, ok := annotations[FilenameAnnotation]; ok {
+		if err := validPathComponent(v); err != nil {
+			return nil, errors.Wrapf(err, "invalid filename %q", v)
+		}
+		allowed := false
+		for _, p := range allowlist.Filenames {
+			if match, _ := path.Match(p, v); match {
+				allowed = true
+			}
+		}
+		if !allowed {
+			return nil, errors.Errorf("filename %v is not allowed", v)
+		}
+		do.filename = v
+	}
+
+	if v, ok := annotations[SubdirectoryAnnotation]; ok {
+		if !containsString(allowlist.Subdirectories, v) {
+			return nil, errors.Errorf("subdirectory %v is not allowed", v)
+		}
+		do.subdir = v
+	}
+
+	return do, nil
+}
+
+// deliverKeyFiles returns the key files of the files of a secret, according to
+// the delivery annotations of the secret
+func (ks *KeySyncServer) deliverKeyFiles(annotations map[string]string, files map[string][]byte, secret v1alpha1.SecretReference, labels map[string]string) ([]keyFile, error) {
+	do, err := parseDeliveryOptions(annotations, ks.deliveryAllowlist)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid delivery options")
+	}
+
+	if do.keys != nil {
+		selected := map[string][]byte{}
+		for _, k := range do.keys {
+			data, ok := files[k]
+			if !ok {
+				return nil, errors.Errorf("key %v of %s annotation not in secret", k, KeysAnnotation)
+			}
+			selected[k] = data
+		}
+		files = selected
+	}
+
+	if do.filename != "" && len(files) != 1 {
+		return nil, errors.Errorf("%s annotation requires a single key file, secret has %d", FilenameAnnotation, len(files))
+	}
+
+	keyFiles := []keyFile{}
+	for filename, data := range files {
+		kf, err := newKeyFile(filename, data, secret, labels)
+		if err != nil {
+			return nil, err
+		}
+		kf.customFilename = do.filename
+		kf.subdir = do.subdir
+		kf.fileMode = do.fileMode
+		kf.ownerUID = do.ownerUID
+		kf.ownerGID = do.ownerGID
+		keyFiles = append(keyFiles, kf)
+	}
+	return keyFiles, nil
+}
+
+// hasLegacyKeyFile returns whether the key file named by the MD5 of the contents
+// by previous versions exists with the contents of the key file
+func (s *sink) hasLegacyKeyFile(kf keyFile) bool {
+	if kf.legacyFilename == "" {
+		return false
+	}
+
+	data, err := os.ReadFile(filepath.Clean(filepath.Join(s.keySyncDir, kf.legacyFilename)))
+	return err == nil && fmt.Sprintf("%x", sha256.Sum256(data)) == kf.hash
+}
+
+// renameLegacyKeyFile renames the key file named by the MD5 of the contents by
+// previous versions to its path, so that upgrades do not rewrite the keys.
+// Returns whether the key file was renamed.
+func (s *sink) renameLegacyKeyFile(path string, kf keyFile) bool {
+	if !s.hasLegacyKeyFile(kf) {
+		return false
+	}
+
+	legacyPath := filepath.Join(s.keySyncDir, kf.legacyFilename)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil { // #nosec G301
+		logrus.Errorf("Unable to rename legacy key %s: %v", s.logName(kf.legacyFilename), err)
+		return false
+	}
+	if err := os.Rename(legacyPath, path); err != nil {
+		logrus.Errorf("Unable to rename legacy key %s: %v", s.logName(kf.legacyFilename), err)
+		return false
+	}
+	logrus.Printf("Renamed legacy key %v to %v", s.logName(kf.legacyFilename), s.logName(kf.filename))
+	if err := s.labelPath(path); err != nil {
+		logrus.Errorf("Unable to label key %s: %v", s.logName(kf.filename), err)
+	}
+	return true
+}
+
+// parseFileMode parses octal file permissions
+func parseFileMode(s string) (os.FileMode, error) {
+	mode, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, errors.Errorf("invalid file mode %q", s)
+	}
+	return os.FileMode(mode), nil
+}
+
+// validPathComponent checks that the name is a single path component that is
+// not hidden
+func validPathComponent(name string) error {
+	if name == "" || strings.ContainsAny(name, `/\`) || strings.HasPrefix(name, ".") {
+		return errors.New("must be a single path component not starting with a dot")
+	}
+	return nil
+}
+
+func containsInt(list []int, i int) bool {
+	for _, l := range list {
+		if l == i {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,155 @@
+// Copyright 2026 k8s-enc-image-operator authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keysync
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+// Drifts of a key file on disk from the synced key file
+const (
+	// DriftMissing is the drift of a synced key file that was deleted
+	DriftMissing = "missing"
+
+	// DriftContent is the drift of a key file whose contents changed
+	DriftContent = "content"
+
+	// DriftMode is the drift of a key file whose permissions changed
+	DriftMode = "mode"
+
+	// DriftOwner is the drift of a key file whose owner changed
+	DriftOwner = "owner"
+
+	// DriftLabel is the drift of a key file whose SELinux label changed
+	DriftLabel = "label"
+)
+
+// keyFileDrifts counts the key files restored after drifting on disk
+var keyFileDrifts = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "enc_key_sync_key_file_drift_total",
+	Help: "Number of times a key file was restored after it was deleted or modified on disk.",
+}, []string{"drift"})
+
+func init() {
+	prometheus.MustRegister(keyFileDrifts)
+}
+
+// keyFileDrift returns the drift of the key file on disk from the key file,
+// the contents, permissions, owner and SELinux label, empty if the file is in
+// sync
+func (s *sink) keyFileDrift(path string, kf keyFile) string {
+	data, err := os.ReadFile(filepath.Clean(path))
+	if err != nil {
+		return DriftMissing
+	}
+	if fmt.Sprintf("%x", sha256.Sum256(data)) != kf.hash {
+		return DriftContent
+	}
+
+	fileInfo, err := os.Stat(path)
+	if err != nil {
+		return DriftMissing
+	}
+
+	permissions, ownerUID, ownerGID := s.keyFileAttributes(kf)
+	if fileInfo.Mode() != permissions {
+		return DriftMode
+	}
+
+	// #nosec G115 userid and groupid should not be bigger then uint32
+	if ((ownerUID != nil) && (fileInfo.Sys().(*syscall.Stat_t).Uid != uint32(*ownerUID))) ||
+		((ownerGID != nil) && (fileInfo.Sys().(*syscall.Stat_t).Gid != uint32(*ownerGID))) {
+		return DriftOwner
+	}
+
+	if s.seLinuxLabel != "" {
+		if label, err := getSELinuxLabel(path); err != nil || label != s.seLinuxLabel {
+			return DriftLabel
+		}
+	}
+	return ""
+}
+
+// startWatcher triggers a sync when the files in the directories of the sinks,
+// or in the directories of the synced key files, are changed, so that drifts
+// are restored immediately rather than on the next interval
+func (ks *KeySyncServer) startWatcher() error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	for _, s := range ks.sinks {
+		if err := watcher.Add(s.keySyncDir); err != nil {
+			_ = watcher.Close()
+			return err
+		}
+	}
+
+	ks.watcher = watcher
+	for _, s := range ks.sinks {
+		s.watcher = watcher
+	}
+
+	go func() {
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				logrus.Debugf("Key sync directory changed: %v", event)
+				select {
+				case ks.syncTrigger <- struct{}{}:
+				default:
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				logrus.Errorf("Error watching key sync directory: %v", err)
+			}
+		}
+	}()
+	return nil
+}
+
+// watchKeyFileDirs adds the directories of the synced key files to the
+// watcher, if any. Watches of removed directories are dropped by the watcher,
+// so the directories are added on every sync.
+func (s *sink) watchKeyFileDirs(filenameMap map[string]bool) {
+	if s.watcher == nil {
+		return
+	}
+
+	dirs := map[string]bool{}
+	for filename := range filenameMap {
+		if dir := filepath.Dir(filename); dir != "." {
+			dirs[dir] = true
+		}
+	}
+	for dir := range dirs {
+		if err := s.watcher.Add(filepath.Join(s.keySyncDir, dir)); err != nil {
+			logrus.Errorf("Unable to watch key directory %s: %v", dir, err)
+		}
+	}
+}
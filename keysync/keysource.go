@@ -0,0 +1,102 @@
+// Copyright 2026 k8s-enc-image-operator authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keysync
+
+import (
+	"context"
+	"time"
+
+	"github.com/lumjjb/k8s-enc-image-operator/apis/v1alpha1"
+
+	"github.com/sirupsen/logrus"
+)
+
+// SourceKey is a key file of a secret, already processed by the handler of
+// the secret type
+type SourceKey struct {
+	// Filename is the name of the key file in the secret
+	Filename string `json:"filename"`
+
+	// Data is the key file contents
+	Data []byte `json:"data"`
+
+	// Secret is the secret the key file was processed from
+	Secret v1alpha1.SecretReference `json:"secret"`
+	// Labels are the labels of the secret, for the secret policy
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// NotBefore is the time from which the key file is synced, if set
+	NotBefore *time.Time `json:"notBefore,omitempty"`
+
+	// NotAfter is the time after which the key file is removed, if set
+	NotAfter *time.Time `json:"notAfter,omitempty"`
+	// Delivery are the delivery annotations of the secret
+	Delivery map[string]string `json:"delivery,omitempty"`
+}
+
+// KeySource provides the key files to be synced instead of the secrets of the
+// namespace and the key handlers, i.e. a central unwrap service, so that the
+// key sync server does not require the handler configs
+type KeySource interface {
+	// Keys returns the key files and the errors processing the secrets. An
+	// error is returned if the keys could not be retrieved at all, in which
+	// case no keys are written or removed.
+	Keys(ctx context.Context) ([]SourceKey, []v1alpha1.SyncError, error)
+}
+
+// sourceKeyFiles returns the key files from the key source, errors processing
+// the secrets are logged and recorded in the result
+func (ks *KeySyncServer) sourceKeyFiles(ctx context.Context, result *SyncResult) ([]keyFile, error) {
+	keys, syncErrors, err := ks.keySource.Keys(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, e := range syncErrors {
+		logrus.Errorf("Unable to process secret %s: %v", e.Secret.Name, e.Message)
+		result.Errors = append(result.Errors, e)
+	}
+
+	// The delivery options apply to all the key files of a secret
+	secrets := []v1alpha1.SecretReference{}
+	secretKeys := map[v1alpha1.SecretReference][]SourceKey{}
+	for _, k := range keys {
+		if !ks.inValidityWindow(k.Secret, k.NotBefore, k.NotAfter, result) {
+			continue
+		}
+		if _, ok := secretKeys[k.Secret]; !ok {
+			secrets = append(secrets, k.Secret)
+		}
+		secretKeys[k.Secret] = append(secretKeys[k.Secret], k)
+	}
+
+	keyFiles := []keyFile{}
+	for _, secRef := range secrets {
+		files := map[string][]byte{}
+		for _, k := range secretKeys[secRef] {
+			files[k.Filename] = k.Data
+		}
+
+		first := secretKeys[secRef][0]
+		secretKeyFiles, err := ks.deliverKeyFiles(first.Delivery, files, secRef, first.Labels)
+		if err != nil {
+			logrus.Errorf("Unable to deliver secret %s: %v", secRef.Name, err)
+			result.addError(secRef, "%v", err)
+			continue
+		}
+		keyFiles = append(keyFiles, secretKeyFiles...)
+	}
+	return keyFiles, nil
+}
@@ -0,0 +1,215 @@
+// Copyright 2026 k8s-enc-image-operator authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keysync
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"text/template/parse"
+
+	"github.com/lumjjb/k8s-enc-image-operator/apis/v1alpha1"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// FlatLayout is the default layout of the key sync directory, with the
+	// key files named <sha256>-<namespace>-<secret>-<file>
+	FlatLayout = "flat"
+
+	// HierarchicalLayout is the layout of the key sync directory with the
+	// key files in <namespace>/<secret>/<file>
+	HierarchicalLayout = "hierarchical"
+
+	// hierarchicalTemplate is the template of the HierarchicalLayout
+	hierarchicalTemplate = "{{.Namespace}}/{{.Name}}/{{.Filename}}"
+)
+
+// layoutFields are the fields of a layout template
+type layoutFields struct {
+	// Hash is the SHA-256 of the key file contents
+	Hash string
+
+	// Namespace is the namespace of the secret
+	Namespace string
+
+	// Name is the name of the secret
+	Name string
+
+	// Type is the type of the secret
+	Type string
+
+	// Filename is the name of the key file in the secret
+	Filename string
+}
+
+// Layout maps the key files of the secrets to their paths in the key sync
+// directory
+type Layout struct {
+	// spec is the layout as configured
+	spec string
+
+	// tmpl is the template of the path, nil for the FlatLayout
+	tmpl *template.Template
+
+	// separators are the characters of the template text, escaped in the
+	// field values so that paths are unambiguous
+	separators string
+
+	// hashed is whether the path contains the hash of the contents, if not
+	// updates are written to the same path
+	hashed bool
+
+	// nested is whether key files are in subdirectories
+	nested bool
+
+	// atomic is whether the key files of a secret are published together,
+	// the AtomicLayout
+	atomic bool
+}
+
+// NewLayout returns the layout of the FlatLayout, the HierarchicalLayout, the
+// AtomicLayout, or a text/template of the path with the fields .Hash,
+// .Namespace, .Name, .Type and .Filename, i.e.
+// "{{.Namespace}}/{{.Name}}-{{.Hash}}-{{.Filename}}". The field values are
+// escaped with %XX for '%', '/', the characters of the template text and a
+// leading dot, as are the fields of the names of the FlatLayout.
+func NewLayout(spec string) (*Layout, error) {
+	switch spec {
+	case "", FlatLayout:
+		return &Layout{spec: FlatLayout, hashed: true}, nil
+	case HierarchicalLayout:
+		spec = hierarchicalTemplate
+	case AtomicLayout:
+		l, err := NewLayout(hierarchicalTemplate)
+		if err != nil {
+			return nil, err
+		}
+		l.spec = AtomicLayout
+		l.atomic = true
+		return l, nil
+	}
+
+	tmpl, err := template.New("layout").Option("missingkey=error").Parse(spec)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid layout template")
+	}
+
+	separators := ""
+	for _, node := range tmpl.Tree.Root.Nodes {
+		if text, ok := node.(*parse.TextNode); ok {
+			separators += string(text.Text)
+		}
+	}
+
+	l := &Layout{
+		spec:       spec,
+		tmpl:       tmpl,
+		separators: separators,
+		nested:     strings.Contains(separators, "/"),
+	}
+
+	// Check that the layout maps different key files to different paths
+	sample := layoutFields{
+		Hash:      "0123456789abcdef0123456789abcdef",
+		Namespace: "namespace",
+		Name:      "name",
+		Type:      "key",
+		Filename:  "filename",
+	}
+	samplePath, err := l.render(sample)
+	if err != nil {
+		return nil, err
+	}
+	for field, modify := range map[string]func(*layoutFields){
+		"Namespace": func(f *layoutFields) { f.Namespace = "other-namespace" },
+		"Name":      func(f *layoutFields) { f.Name = "other-name" },
+		"Filename":  func(f *layoutFields) { f.Filename = "other-filename" },
+		"Hash":      func(f *layoutFields) { f.Hash = "fedcba9876543210fedcba9876543210" },
+	} {
+		fields := sample
+		modify(&fields)
+		path, err := l.render(fields)
+		if err != nil {
+			return nil, err
+		}
+		if field == "Hash" {
+			l.hashed = path != samplePath
+		} else if path == samplePath {
+			return nil, errors.Errorf("layout template %q does not depend on .%s", spec, field)
+		}
+	}
+	return l, nil
+}
+
+// String returns the layout as configured
+func (l *Layout) String() string {
+	return l.spec
+}
+
+// path returns the path of the key file of the secret relative to the key
+// sync directory
+func (l *Layout) path(hash string, secret v1alpha1.SecretReference, filename string) (string, error) {
+	if l.tmpl == nil {
+		return checkLayoutPath(getLocalKeyFilename(l.escape(secret.Namespace), l.escape(secret.Name), l.escape(filename), l.escape(hash)))
+	}
+
+	return l.render(layoutFields{
+		Hash:      l.escape(hash),
+		Namespace: l.escape(secret.Namespace),
+		Name:      l.escape(secret.Name),
+		Type:      l.escape(secret.Type),
+		Filename:  l.escape(filename),
+	})
+}
+
+// render executes the template and checks that the path is local to the key
+// sync directory
+func (l *Layout) render(fields layoutFields) (string, error) {
+	var buf bytes.Buffer
+	if err := l.tmpl.Execute(&buf, fields); err != nil {
+		return "", errors.Wrap(err, "unable to render layout template")
+	}
+
+	return checkLayoutPath(buf.String())
+}
+
+// checkLayoutPath checks that the path is a clean path local to the key sync
+// directory
+func checkLayoutPath(path string) (string, error) {
+	if !filepath.IsLocal(path) || filepath.Clean(path) != path {
+		return "", errors.Errorf("layout path %q is not a clean relative path", path)
+	}
+	return path, nil
+}
+
+// escape escapes the characters of the value that would make the path
+// ambiguous with %XX
+func (l *Layout) escape(value string) string {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if c == '%' || c == '/' || c == '\\' || c < ' ' || c >= 0x7f ||
+			strings.IndexByte(l.separators, c) >= 0 || (i == 0 && c == '.') {
+			fmt.Fprintf(&b, "%%%02X", c)
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
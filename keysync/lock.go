@@ -0,0 +1,164 @@
+// Copyright 2026 k8s-enc-image-operator authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keysync
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// LockFileSuffix is appended to the directories of the sinks to name
+	// their lock files by default, the lock files are next to the
+	// directories as every file in them is taken for a key
+	LockFileSuffix = ".lock"
+
+	// lockPollInterval is the interval in which the lock is retried while
+	// it is held by another instance
+	lockPollInterval = 500 * time.Millisecond
+)
+
+// lockHolder returns the identity of the instance recorded in the lock files,
+// the hostname, which is the pod name, and the pid
+func lockHolder() string {
+	hostname, _ := os.Hostname()
+	return fmt.Sprintf("%s (pid %d)", hostname, os.Getpid())
+}
+
+// defaultLockFile returns the lock file of the directory if not configured,
+// next to the directory
+func defaultLockFile(dir string) string {
+	return filepath.Clean(dir) + LockFileSuffix
+}
+
+// warnLockFileNotShared warns when the lock file is on the root filesystem of a
+// container, i.e. next to a key directory mounted alone, where it is private to
+// the pod and does not exclude the instances in other pods
+func warnLockFileNotShared(lockFile string) {
+	mountInfo, err := os.ReadFile(mountInfoFile)
+	if err != nil {
+		return
+	}
+	dir, err := filepath.Abs(filepath.Dir(lockFile))
+	if err != nil {
+		return
+	}
+	if dir, err = filepath.EvalSymlinks(dir); err != nil {
+		return
+	}
+	m, err := findMount(mountInfo, dir)
+	if err != nil {
+		return
+	}
+	if m.mountPoint == "/" && m.fsType == "overlay" {
+		logrus.Warnf("Lock file %v is on the root filesystem of the container, it does NOT exclude the instances in other pods; set -lockFile to a file of a hostPath volume shared by the pods", lockFile)
+	}
+}
+
+// lockDir takes the exclusive lock of the directory with the lock file,
+// waiting until the context is done while another instance holds it. Returns
+// the locked file.
+func lockDir(ctx context.Context, dir, path string) (*os.File, error) {
+	f, err := os.OpenFile(filepath.Clean(path), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to open lock file of %v", dir)
+	}
+
+	waiting := false
+	for {
+		err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, syscall.EWOULDBLOCK) {
+			_ = f.Close()
+			return nil, errors.Wrapf(err, "unable to lock %v", dir)
+		}
+
+		holder, _ := os.ReadFile(filepath.Clean(path))
+		if !waiting {
+			logrus.Printf("Waiting for the lock of %v held by %v", dir, strings.TrimSpace(string(holder)))
+			waiting = true
+		}
+
+		select {
+		case <-ctx.Done():
+			_ = f.Close()
+			return nil, errors.Errorf("key sync directory %v is locked by %v: %v", dir, strings.TrimSpace(string(holder)), ctx.Err())
+		case <-time.After(lockPollInterval):
+		}
+	}
+
+	// Record the holder for the instances waiting for the lock
+	if err := f.Truncate(0); err == nil {
+		_, _ = f.WriteAt([]byte(lockHolder()+"\n"), 0)
+	}
+	return f, nil
+}
+
+// unlockDir releases the lock of the directory taken with lockDir
+func unlockDir(f *os.File) {
+	_ = f.Truncate(0)
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_UN); err != nil {
+		logrus.Errorf("Unable to unlock %v: %v", f.Name(), err)
+	}
+	_ = f.Close()
+}
+
+// lock takes the locks of the directories of all the sinks, waiting until the
+// context is done while other instances hold them
+func (ks *KeySyncServer) lock(ctx context.Context) error {
+	for i, s := range ks.sinks {
+		if rel, err := filepath.Rel(s.keySyncDir, s.lockFile); err == nil && filepath.IsLocal(rel) {
+			unlockSinks(ks.sinks[:i])
+			return errors.Errorf("lock file %v must be outside of the key sync directory %v", s.lockFile, s.keySyncDir)
+		}
+		if err := os.MkdirAll(filepath.Dir(s.lockFile), 0755); err != nil { // #nosec G301
+			unlockSinks(ks.sinks[:i])
+			return err
+		}
+		warnLockFileNotShared(s.lockFile)
+		f, err := lockDir(ctx, s.keySyncDir, s.lockFile)
+		if err != nil {
+			unlockSinks(ks.sinks[:i])
+			return err
+		}
+		s.lock = f
+	}
+	return nil
+}
+
+// unlock releases the locks of the directories of all the sinks
+func (ks *KeySyncServer) unlock() {
+	unlockSinks(ks.sinks)
+}
+
+// unlockSinks releases the locks of the directories of the sinks
+func unlockSinks(sinks []*sink) {
+	for _, s := range sinks {
+		if s.lock != nil {
+			unlockDir(s.lock)
+			s.lock = nil
+		}
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright 2026 k8s-enc-image-operator authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keysync
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	"github.com/lumjjb/k8s-enc-image-operator/apis/v1alpha1"
+)
+
+// Metadata is the record of the origin of each key file in the key sync
+// directory, written after every sync
+type Metadata struct {
+	// Layout is the layout of the key sync directory
+	Layout string `json:"layout"`
+
+	// Keys are the key files synced, sorted by filename
+	Keys []v1alpha1.SyncedKey `json:"keys"`
+}
+
+// ReadMetadataFile reads the metadata record
+func ReadMetadataFile(filename string) (*Metadata, error) {
+	data, err := os.ReadFile(filepath.Clean(filename))
+	if err != nil {
+		return nil, err
+	}
+
+	var md Metadata
+	if err := json.Unmarshal(data, &md); err != nil {
+		return nil, err
+	}
+	return &md, nil
+}
+
+// writeMetadata writes the metadata record of the keys synced to the sink, if
+// changed, replacing the previous record atomically
+func (s *sink) writeMetadata(result *SyncResult) error {
+	md := Metadata{
+		Layout: s.layout.String(),
+		Keys:   []v1alpha1.SyncedKey{},
+	}
+	for _, k := range result.Keys {
+		if k.Sink == s.name {
+			md.Keys = append(md.Keys, k)
+		}
+	}
+	data, err := json.MarshalIndent(md, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	previous, err := os.ReadFile(filepath.Clean(s.metadataFile))
+	if err == nil && bytes.Equal(previous, data) {
+		return nil
+	}
+
+	tmpFile := s.metadataFile + ".tmp"
+	if err := os.WriteFile(tmpFile, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile, s.metadataFile)
+}
+
+// metadataKeys returns the filenames of the key files of the previous
+// metadata record, so that they are cleaned up after a change of the layout
+func (s *sink) metadataKeys() []string {
+	if s.metadataFile == "" {
+		return nil
+	}
+
+	md, err := ReadMetadataFile(s.metadataFile)
+	if err != nil {
+		return nil
+	}
+
+	filenames := []string{}
+	for _, k := range md.Keys {
+		if filepath.IsLocal(k.Filename) {
+			filenames = append(filenames, k.Filename)
+		}
+	}
+	return filenames
+}
@@ -0,0 +1,156 @@
+// Copyright 2026 k8s-enc-image-operator authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keysync
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+
+	"github.com/lumjjb/k8s-enc-image-operator/apis/v1alpha1"
+)
+
+// SyncPlan contains the changes a sync of the keys would make to the key sync
+// directory
+type SyncPlan struct {
+	// Add are the key files that would be written, sorted by sink and
+	// filename
+	Add []v1alpha1.SyncedKey `json:"add"`
+
+	// Rename are the key files named by the MD5 of the contents by previous
+	// versions that would be renamed to their path, sorted
+	Rename []SyncRename `json:"rename"`
+
+	// Remove are the files that would be deleted as obsolete, prefixed by
+	// the name of their sink and a colon for the additional sinks, sorted
+	Remove []string `json:"remove"`
+
+	// Errors are the errors processing the secrets, sorted by secret
+	Errors []v1alpha1.SyncError `json:"errors"`
+}
+
+// SyncRename is a key file that would be renamed, the filenames are prefixed by
+// the name of their sink and a colon for the additional sinks
+type SyncRename struct {
+	// From is the current filename
+	From string `json:"from"`
+
+	// To is the filename in the layout of the sink
+	To string `json:"to"`
+}
+
+// Plan computes the changes a sync of the keys would make to the key sync
+// directory without touching the filesystem. It first waits for the handlers
+// of the required secret types to be added. Errors processing the secrets are
+// part of the plan, as the sync would skip these secrets, but are not reported
+// by the secret verifier or exported as metrics.
+func (ks *KeySyncServer) Plan(ctx context.Context) (*SyncPlan, error) {
+	if err := ks.waitForRequiredHandlers(ctx); err != nil {
+		return nil, err
+	}
+
+	result := &SyncResult{dryRun: true}
+	plan := &SyncPlan{
+		Add:    []v1alpha1.SyncedKey{},
+		Rename: []SyncRename{},
+		Remove: []string{},
+	}
+
+	keyFiles, err := ks.keyFiles(ctx, result)
+	if err != nil {
+		return nil, err
+	}
+	allowed := ks.applyPolicy(keyFiles, result)
+
+	for _, s := range ks.sinks {
+		filenameMap := map[string]bool{}
+		for _, kf := range s.keyFiles(allowed, result) {
+			if filenameMap[kf.filename] {
+				result.addError(kf.secret, "key file %s is already synced from another secret", s.logName(kf.filename))
+				continue
+			}
+			filenameMap[kf.filename] = true
+
+			// Key files of previous versions are renamed rather than
+			// written and removed, as in syncKeyFiles
+			drift := s.keyFileDrift(filepath.Join(s.keySyncDir, kf.filename), kf)
+			_, synced := s.syncedFiles[kf.filename]
+			if drift == DriftMissing && !synced && s.hasLegacyKeyFile(kf) {
+				filenameMap[kf.legacyFilename] = true
+				plan.Rename = append(plan.Rename, SyncRename{From: s.logName(kf.legacyFilename), To: s.logName(kf.filename)})
+			} else if drift != "" {
+				result.addKey(s.name, kf.filename, kf.hash, kf.secret)
+			}
+
+			// The current version of a secret in the atomic layout is kept
+			// until the new version is published
+			if kf.atomicDir != "" {
+				for _, f := range s.currentAtomicFiles(atomicGroup{dir: kf.atomicDir, keyFiles: []keyFile{kf}}) {
+					filenameMap[f] = true
+				}
+			}
+		}
+
+		for _, f := range s.obsoleteKeys(filenameMap) {
+			plan.Remove = append(plan.Remove, s.logName(f))
+		}
+	}
+
+	if ks.secureWipe {
+		zeroizeKeyFiles(keyFiles)
+	}
+
+	result.sort()
+	plan.Add = append(plan.Add, result.Keys...)
+	plan.Errors = append([]v1alpha1.SyncError{}, result.Errors...)
+	sort.Slice(plan.Rename, func(i, j int) bool {
+		return plan.Rename[i].From < plan.Rename[j].From
+	})
+	sort.Strings(plan.Remove)
+
+	return plan, nil
+}
+
+// Empty returns whether the plan has no changes and no errors
+func (p *SyncPlan) Empty() bool {
+	return len(p.Add) == 0 && len(p.Rename) == 0 && len(p.Remove) == 0 && len(p.Errors) == 0
+}
+
+// Print writes the plan in a human readable form, one line per change
+func (p *SyncPlan) Print(w io.Writer) {
+	for _, k := range p.Add {
+		filename := k.Filename
+		if k.Sink != "" {
+			filename = k.Sink + ":" + filename
+		}
+		fmt.Fprintf(w, "+ %s (%s/%s type=%s)\n", filename, k.Secret.Namespace, k.Secret.Name, k.Secret.Type)
+	}
+	for _, r := range p.Rename {
+		fmt.Fprintf(w, "~ %s -> %s\n", r.From, r.To)
+	}
+	for _, f := range p.Remove {
+		fmt.Fprintf(w, "- %s\n", f)
+	}
+	for _, e := range p.Errors {
+		name := e.Secret.Name
+		if name == "" {
+			name = "*"
+		}
+		fmt.Fprintf(w, "! %s/%s (type=%s): %s\n", e.Secret.Namespace, name, e.Secret.Type, e.Message)
+	}
+	fmt.Fprintf(w, "%d to add, %d to rename, %d to remove, %d errors\n", len(p.Add), len(p.Rename), len(p.Remove), len(p.Errors))
+}
@@ -0,0 +1,99 @@
+// Copyright 2026 k8s-enc-image-operator authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keysync
+
+import (
+	"sort"
+
+	"github.com/lumjjb/k8s-enc-image-operator/apis/v1alpha1"
+
+	"github.com/sirupsen/logrus"
+)
+
+// PolicySecret is a secret and its processed key files, as evaluated by the
+// secret policy
+type PolicySecret struct {
+	// Secret is the reference to the secret
+	Secret v1alpha1.SecretReference
+
+	// Labels are the labels of the secret
+	Labels map[string]string
+
+	// Files maps the names of the key files of the secret to their sizes
+	Files map[string]int
+}
+
+// SecretPolicy decides which secrets may have their keys synced
+type SecretPolicy interface {
+	// Evaluate returns, for each secret in order, the reason the secret is
+	// denied or nil if it is allowed. Secrets are sorted by namespace and
+	// name, so that limits across secrets are applied deterministically.
+	Evaluate(secrets []PolicySecret) []error
+}
+
+// applyPolicy returns the key files of the secrets allowed by the secret
+// policy, denied secrets are logged and recorded in the result, and their
+// keys are neither written nor kept
+func (ks *KeySyncServer) applyPolicy(keyFiles []keyFile, result *SyncResult) []keyFile {
+	if ks.secretPolicy == nil {
+		return keyFiles
+	}
+
+	secrets := []PolicySecret{}
+	index := map[v1alpha1.SecretReference]int{}
+	for _, kf := range keyFiles {
+		i, ok := index[kf.secret]
+		if !ok {
+			i = len(secrets)
+			index[kf.secret] = i
+			secrets = append(secrets, PolicySecret{
+				Secret: kf.secret,
+				Labels: kf.labels,
+				Files:  map[string]int{},
+			})
+		}
+		secrets[i].Files[kf.secretFilename] = len(kf.data)
+	}
+
+	sort.Slice(secrets, func(i, j int) bool {
+		a, b := secrets[i].Secret, secrets[j].Secret
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.Type < b.Type
+	})
+
+	denied := map[v1alpha1.SecretReference]bool{}
+	for i, err := range ks.secretPolicy.Evaluate(secrets) {
+		if err == nil {
+			continue
+		}
+		secRef := secrets[i].Secret
+		logrus.Warnf("Secret %s/%s denied by policy: %v", secRef.Namespace, secRef.Name, err)
+		result.addError(secRef, "denied by policy: %v", err)
+		denied[secRef] = true
+	}
+
+	allowed := []keyFile{}
+	for _, kf := range keyFiles {
+		if !denied[kf.secret] {
+			allowed = append(allowed, kf)
+		}
+	}
+	return allowed
+}
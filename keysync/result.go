@@ -0,0 +1,114 @@
+// Copyright 2026 k8s-enc-image-operator authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keysync
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lumjjb/k8s-enc-image-operator/apis/v1alpha1"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// SyncResult contains the outcome of a single sync of the keys
+type SyncResult struct {
+	// Time is the time the sync started
+	Time time.Time
+
+	// Keys are the key files synced, sorted by sink and filename
+	Keys []v1alpha1.SyncedKey
+
+	// Errors are the errors of the sync, sorted by secret
+	Errors []v1alpha1.SyncError
+
+	// dryRun is whether the result is of a plan, which must not report
+	// verification failures or export metrics
+	dryRun bool
+}
+
+// SyncReporter is a function type that is called with the result of every
+// sync, i.e. to publish the status of the node
+type SyncReporter func(*SyncResult)
+
+// secretReference returns the reference to the secret version
+func secretReference(s *corev1.Secret, namespace string) v1alpha1.SecretReference {
+	return v1alpha1.SecretReference{
+		Namespace:       namespace,
+		Name:            s.GetName(),
+		Type:            string(s.Type),
+		ResourceVersion: s.GetResourceVersion(),
+	}
+}
+
+// addKey records a key file synced from the secret to the sink
+func (r *SyncResult) addKey(sink, filename, hash string, secret v1alpha1.SecretReference) {
+	r.Keys = append(r.Keys, v1alpha1.SyncedKey{
+		Sink:     sink,
+		Filename: filename,
+		Hash:     hash,
+		Secret:   secret,
+	})
+}
+
+// addError records an error syncing the secret
+func (r *SyncResult) addError(secret v1alpha1.SecretReference, format string, args ...interface{}) {
+	r.Errors = append(r.Errors, v1alpha1.SyncError{
+		Secret:  secret,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// sort sorts the keys and errors so that results can be compared
+func (r *SyncResult) sort() {
+	sort.Slice(r.Keys, func(i, j int) bool {
+		if r.Keys[i].Sink != r.Keys[j].Sink {
+			return r.Keys[i].Sink < r.Keys[j].Sink
+		}
+		return r.Keys[i].Filename < r.Keys[j].Filename
+	})
+	sort.SliceStable(r.Errors, func(i, j int) bool {
+		a, b := r.Errors[i].Secret, r.Errors[j].Secret
+		if a.Type != b.Type {
+			return a.Type < b.Type
+		}
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		return a.Name < b.Name
+	})
+}
+
+// Err returns an error summarizing the errors of the sync, or nil if the sync
+// did not have any errors
+func (r *SyncResult) Err() error {
+	if len(r.Errors) == 0 {
+		return nil
+	}
+
+	lines := []string{}
+	for _, e := range r.Errors {
+		name := e.Secret.Name
+		if name == "" {
+			name = "*"
+		}
+		lines = append(lines, fmt.Sprintf("%s/%s (type=%s): %s", e.Secret.Namespace, name, e.Secret.Type, e.Message))
+	}
+
+	return errors.Errorf("%d errors syncing %d keys:\n%s", len(r.Errors), len(r.Keys), strings.Join(lines, "\n"))
+}
@@ -0,0 +1,33 @@
+// Copyright 2026 k8s-enc-image-operator authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sechandlers
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// ValidateFilename returns an error if the filename returned by a
+// SecretKeyHandler is not a valid secret data key, [-._a-zA-Z0-9]+ and not
+// "." or "..". Handlers taking the filename from the contents of the secret
+// must not be able to name files outside of the key directory.
+func ValidateFilename(filename string) error {
+	if errs := validation.IsConfigMapKey(filename); len(errs) > 0 {
+		return errors.Errorf("invalid key filename %q: %s", filename, strings.Join(errs, ", "))
+	}
+	return nil
+}
@@ -18,3 +18,16 @@ package sechandlers
 // filename/private key data to be stored. This is useful for handling
 // secrets that may require an additional step of unwrapping, formatting, etc.
 type SecretKeyHandler func(map[string][]byte) (map[string][]byte, error)
+
+// SecretKeyWrapper is a function type that is the inverse of a
+// SecretKeyHandler. It maps private key data into the secret data of a
+// wrapped key secret, so that the private key does not need to be stored in
+// plaintext.
+type SecretKeyWrapper func([]byte) (map[string][]byte, error)
+
+// SecretKeyHandlerAdder is implemented by the servers processing secrets with
+// SecretKeyHandlers, i.e. to add a handler whose config is loaded
+// asynchronously
+type SecretKeyHandlerAdder interface {
+	AddSecretKeyHandler(secretType string, skh SecretKeyHandler)
+}
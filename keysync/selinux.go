@@ -0,0 +1,69 @@
+// Copyright 2026 k8s-enc-image-operator authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keysync
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ValidateSELinuxLabel checks that the label is a complete SELinux context,
+// i.e. "system_u:object_r:container_file_t:s0"
+func ValidateSELinuxLabel(label string) error {
+	parts := strings.SplitN(label, ":", 4)
+	if len(parts) != 4 {
+		return errors.Errorf("invalid SELinux label %q, must be user:role:type:level", label)
+	}
+	for _, p := range parts {
+		if p == "" {
+			return errors.Errorf("invalid SELinux label %q, must be user:role:type:level", label)
+		}
+	}
+	return nil
+}
+
+// labelPath sets the SELinux label of the sink on the file and the directories
+// from the directory of the sink down to the file, if any
+func (s *sink) labelPath(path string) error {
+	if s.seLinuxLabel == "" {
+		return nil
+	}
+
+	rel, err := filepath.Rel(s.keySyncDir, path)
+	if err != nil || !filepath.IsLocal(rel) {
+		return errors.Errorf("%v is not in %v", path, s.keySyncDir)
+	}
+
+	dir := s.keySyncDir
+	if err := setSELinuxLabel(dir, s.seLinuxLabel); err != nil {
+		return errors.Wrapf(err, "unable to label %v", dir)
+	}
+	if parent := filepath.Dir(rel); parent != "." {
+		for _, c := range strings.Split(parent, string(os.PathSeparator)) {
+			dir = filepath.Join(dir, c)
+			if err := setSELinuxLabel(dir, s.seLinuxLabel); err != nil {
+				return errors.Wrapf(err, "unable to label %v", dir)
+			}
+		}
+	}
+
+	if err := setSELinuxLabel(path, s.seLinuxLabel); err != nil {
+		return errors.Wrapf(err, "unable to label %v", path)
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+// Copyright 2026 k8s-enc-image-operator authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+
+package keysync
+
+import (
+	"strings"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// seLinuxXattr is the extended attribute of the SELinux label of a file
+const seLinuxXattr = "security.selinux"
+
+// getSELinuxLabel returns the SELinux label of the file
+func getSELinuxLabel(path string) (string, error) {
+	buf := make([]byte, 256)
+	for {
+		n, err := syscall.Getxattr(path, seLinuxXattr, buf)
+		if errors.Is(err, syscall.ERANGE) {
+			buf = make([]byte, 2*len(buf))
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(buf[:n]), "\x00"), nil
+	}
+}
+
+// setSELinuxLabel sets the SELinux label of the file, unless it already has it
+func setSELinuxLabel(path, label string) error {
+	if current, err := getSELinuxLabel(path); err == nil && current == label {
+		return nil
+	}
+	return syscall.Setxattr(path, seLinuxXattr, []byte(label), 0)
+}
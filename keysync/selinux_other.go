@@ -0,0 +1,36 @@
+// Copyright 2026 k8s-enc-image-operator authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !linux
+
+package keysync
+
+import (
+	"github.com/pkg/errors"
+)
+
+// errSELinuxUnsupported is the error of the SELinux functions outside of Linux
+var errSELinuxUnsupported = errors.New("SELinux labels are only supported on Linux")
+
+// getSELinuxLabel returns the SELinux label of the file, which is only
+// supported on Linux
+func getSELinuxLabel(path string) (string, error) {
+	return "", errSELinuxUnsupported
+}
+
+// setSELinuxLabel sets the SELinux label of the file, which is only supported
+// on Linux
+func setSELinuxLabel(path, label string) error {
+	return errSELinuxUnsupported
+}
@@ -16,25 +16,45 @@ package keysync
 
 import (
 	"context"
-	"crypto/md5" // #nosec G501 Usage is not related to security
+	"crypto/md5" // #nosec G501 Only used to find the files named by legacy versions
+	"crypto/sha256"
 	"fmt"
 	"io/fs"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
 
+	"github.com/lumjjb/k8s-enc-image-operator/apis/v1alpha1"
 	"github.com/lumjjb/k8s-enc-image-operator/keysync/sechandlers"
+	"github.com/lumjjb/k8s-enc-image-operator/secsign"
 
+	"github.com/fsnotify/fsnotify"
+	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/utils/clock"
 )
 
 const (
 	keyTypeFieldSelectorPrefix = "type="
+
+	// requiredHandlersPollInterval is the interval in which SyncOnce checks
+	// if the required handlers have been added
+	requiredHandlersPollInterval = 100 * time.Millisecond
+
+	// keyFileTmpPrefix is the prefix of the temporary files key files are
+	// written to before being renamed into place
+	keyFileTmpPrefix = "..keytmp_"
+
+	// keyFileWipePrefix is the prefix of the links to the previous contents
+	// of the key files, wiped once the key files are replaced
+	keyFileWipePrefix = "..keywipe_"
 )
 
 // KeySyncServerConfig contains the parameters required for operation of the
@@ -63,6 +83,98 @@ type KeySyncServerConfig struct {
 	// KeyFileOwnerGID specifies the owner GID to set on the created files
 	// if nil, owner GID won't be changed, therefore files will be created with process GID
 	KeyFileOwnerGID *int
+
+	// SyncReporter is called with the result of every sync, if nil results
+	// are not reported
+	SyncReporter SyncReporter
+
+	// RequiredSecretTypes specifies the secret types whose handlers are
+	// added asynchronously, and that SyncOnce waits for before syncing,
+	// i.e. "kp-key" when the keyprotect config is loaded from a kube secret
+	RequiredSecretTypes []string
+
+	// KeySource provides the key files instead of the secrets of the
+	// namespace and the key handlers, i.e. a central unwrap service, if nil
+	// the secrets are processed locally
+	KeySource KeySource
+
+	// SecretVerifier verifies the secrets before they are processed, secrets
+	// failing verification are not synced, if nil secrets are not verified
+	SecretVerifier SecretVerifier
+
+	// SecretPolicy decides which secrets may have their keys synced, denied
+	// secrets are reported and their keys are not written, if nil all
+	// secrets are allowed
+	SecretPolicy SecretPolicy
+
+	// Clock provides the current time to check the validity windows of the
+	// secrets against, if nil the real clock is used
+	Clock clock.PassiveClock
+
+	// ExpiryWarningPeriod is the period before the expiry of the keys of a
+	// secret in which a warning is logged on every sync
+	ExpiryWarningPeriod time.Duration
+
+	// DeliveryAllowlist specifies the delivery options secrets may request
+	// with their annotations, if nil only the subset of keys to sync may be
+	// requested
+	DeliveryAllowlist *DeliveryAllowlist
+
+	// Layout maps the key files to their paths in the key sync directory,
+	// if nil the FlatLayout is used
+	Layout *Layout
+
+	// MetadataFile is the file to record the origin of each key file in
+	// after every sync, outside of the key sync directory. If set, key
+	// files of the previous record are cleaned up after a change of the
+	// layout.
+	MetadataFile string
+
+	// WatchKeySyncDir specifies whether to watch the key sync directory with
+	// inotify, to restore key files deleted or modified on disk immediately
+	// instead of on the next interval
+	WatchKeySyncDir bool
+
+	// Sinks are additional directories the keys are synced to, each with
+	// its own permissions, ownership, layout and secret types, reconciled
+	// and cleaned up independently of the key sync directory
+	Sinks []SinkConfig
+
+	// SecureWipe specifies whether to overwrite the contents of key files
+	// with zeros and sync them to the disk before they are removed or
+	// rewritten, and to zeroize the keys in memory once they are written
+	SecureWipe bool
+
+	// LockKeySyncDirs specifies whether to take an exclusive lock on the
+	// directories of the sinks while syncing, so that several instances
+	// do not write and clean up each other's key files
+	LockKeySyncDirs bool
+
+	// LockTimeout is the time to wait for the locks held by another
+	// instance, and once they are taken over, for the handlers of the
+	// required secret types before the first sync
+	LockTimeout time.Duration
+
+	// LockFile is the lock file of KeySyncDir, outside of the directory, if
+	// empty KeySyncDir with LockFileSuffix
+	LockFile string
+
+	// SELinuxLabel is the SELinux label to set on the created files and
+	// their directories, i.e. so that the container runtime can read them
+	// under its policy, if empty the labels are not changed
+	SELinuxLabel string
+}
+
+// SecretVerifier verifies a secret before its keys are synced, i.e. its
+// signature
+type SecretVerifier interface {
+	// Verify verifies the secret, reporting a failure, i.e. as an event of
+	// the secret
+	Verify(s *corev1.Secret) error
+
+	// Check verifies the secret like Verify without reporting a failure,
+	// i.e. for a dry run
+	Check(s *corev1.Secret) error
 }
 
 // KeySyncServer represents the server to perform key syncing
@@ -74,23 +186,13 @@ type KeySyncServer struct {
 	// interval is the query interval in which to sync the decryption keys
 	interval time.Duration
 
-	// keySyncDir specifies the directory where keys are synced to
-	keySyncDir string
+	// sinks are the directories where keys are synced to, the key sync
+	// directory first
+	sinks []*sink
 
 	// namespace specifies the namespace where key secrets are stored
 	namespace string
 
-	// keyFilePermissions specifies the permissions to set on the created files
-	keyFilePermissions os.FileMode
-
-	// keyFileOwnerUID specifies the owner UID to set on the created files
-	// if nil, owner UID won't be changed, therefore files will be created with process UID
-	keyFileOwnerUID *int
-
-	// keyFileOwnerGID specifies the owner GID to set on the created files
-	// if nil, owner GID won't be changed, therefore files will be created with process GID
-	keyFileOwnerGID *int
-
 	// keyHandlers handles non-standard keys with additional requirements
 	// such as requiring a remote unwrapping service, or talking to a HSM, etc.
 	// The map contains a mapping of key type of the secret that it handles,
@@ -105,20 +207,102 @@ type KeySyncServer struct {
 
 	// addKeyHandlersMutex to handle concurrency for addKeyHandlers
 	addKeyHandlersMutex *sync.Mutex
+
+	// syncReporter is called with the result of every sync
+	syncReporter SyncReporter
+
+	// requiredSecretTypes specifies the secret types whose handlers SyncOnce
+	// waits for before syncing
+	requiredSecretTypes []string
+
+	// keySource provides the key files instead of the key handlers
+	keySource KeySource
+
+	// secretVerifier verifies the secrets before they are processed
+	secretVerifier SecretVerifier
+
+	// secretPolicy decides which secrets may have their keys synced
+	secretPolicy SecretPolicy
+
+	// clock provides the current time
+	clock clock.PassiveClock
+
+	// expiryWarningPeriod is the period before the expiry of keys in which a
+	// warning is logged
+	expiryWarningPeriod time.Duration
+
+	// deliveryAllowlist specifies the delivery options secrets may request
+	deliveryAllowlist *DeliveryAllowlist
+
+	// watchKeySyncDir specifies whether to watch the directories of the
+	// sinks
+	watchKeySyncDir bool
+
+	// secureWipe specifies whether to wipe the key files before they are
+	// removed, and the keys in memory once they are written
+	secureWipe bool
+
+	// lockKeySyncDirs specifies whether to lock the directories of the
+	// sinks while syncing
+	lockKeySyncDirs bool
+
+	// lockTimeout is the time to wait for the locks and the handover
+	lockTimeout time.Duration
+
+	// stopTrigger stops Start after the current sync, purging the keys if
+	// true
+	stopTrigger chan bool
+
+	// watcher watches the directories of the sinks, nil if not watched
+	watcher *fsnotify.Watcher
+
+	// syncTrigger triggers a sync before the next interval
+	syncTrigger chan struct{}
 }
 
 func NewKeySyncServer(ksc KeySyncServerConfig) *KeySyncServer {
 	ks := KeySyncServer{
 		k8sClient:           ksc.K8sClient,
 		interval:            ksc.Interval,
-		keySyncDir:          ksc.KeySyncDir,
 		namespace:           ksc.Namespace,
 		keyHandlers:         map[string]sechandlers.SecretKeyHandler{},
 		addKeyHandlers:      map[string]sechandlers.SecretKeyHandler{},
 		addKeyHandlersMutex: &sync.Mutex{},
-		keyFilePermissions:  ksc.KeyFilePermissions,
-		keyFileOwnerUID:     ksc.KeyFileOwnerUID,
-		keyFileOwnerGID:     ksc.KeyFileOwnerGID,
+		syncReporter:        ksc.SyncReporter,
+		requiredSecretTypes: ksc.RequiredSecretTypes,
+		keySource:           ksc.KeySource,
+		secretVerifier:      ksc.SecretVerifier,
+		secretPolicy:        ksc.SecretPolicy,
+		clock:               ksc.Clock,
+		expiryWarningPeriod: ksc.ExpiryWarningPeriod,
+		deliveryAllowlist:   ksc.DeliveryAllowlist,
+		watchKeySyncDir:     ksc.WatchKeySyncDir,
+		secureWipe:          ksc.SecureWipe,
+		lockKeySyncDirs:     ksc.LockKeySyncDirs,
+		lockTimeout:         ksc.LockTimeout,
+		stopTrigger:         make(chan bool, 1),
+		syncTrigger:         make(chan struct{}, 1),
+	}
+
+	if ks.clock == nil {
+		ks.clock = clock.RealClock{}
+	}
+
+	ks.sinks = append(ks.sinks, newSink(SinkConfig{
+		KeySyncDir:         ksc.KeySyncDir,
+		KeyFilePermissions: ksc.KeyFilePermissions,
+		KeyFileOwnerUID:    ksc.KeyFileOwnerUID,
+		KeyFileOwnerGID:    ksc.KeyFileOwnerGID,
+		Layout:             ksc.Layout,
+		MetadataFile:       ksc.MetadataFile,
+		SELinuxLabel:       ksc.SELinuxLabel,
+		LockFile:           ksc.LockFile,
+	}, ksc.DeliveryAllowlist))
+	for _, sc := range ksc.Sinks {
+		ks.sinks = append(ks.sinks, newSink(sc, ksc.DeliveryAllowlist))
+	}
+	for _, s := range ks.sinks {
+		s.secureWipe = ksc.SecureWipe
 	}
 
 	// add the regular key type to the list of special key handlers
@@ -128,42 +312,235 @@ func NewKeySyncServer(ksc KeySyncServerConfig) *KeySyncServer {
 }
 
 // Start begins running the KeySyncServer according to the parameters
-// specified.
+// specified, until Stop is called.
 // Only one instance of Start should be run per KeySyncServer
 func (ks *KeySyncServer) Start() error {
-	secClient := ks.k8sClient.CoreV1().Secrets(ks.namespace)
+	if ks.lockKeySyncDirs {
+		if err := ks.takeOver(); err != nil {
+			return err
+		}
+		defer ks.unlock()
+	}
+
+	if ks.watchKeySyncDir {
+		if err := ks.startWatcher(); err != nil {
+			logrus.Errorf("Unable to watch key sync directory, drifts are restored on the next interval: %v", err)
+		}
+	}
 
 	// Create channel for immediate call for the first time
 	for {
-		<-time.After(ks.interval)
+		select {
+		case <-time.After(ks.interval):
+		case <-ks.syncTrigger:
+		case purge := <-ks.stopTrigger:
+			if purge {
+				logrus.Printf("Stopping KeySync server, purging the synced keys")
+				return ks.purge()
+			}
+			logrus.Printf("Stopping KeySync server, keeping the synced keys")
+			return nil
+		}
+
+		ks.sync(context.Background())
+	}
+}
+
+// Stop stops Start after the current sync, releasing the locks of the
+// directories so that another instance can take over the synced keys
+func (ks *KeySyncServer) Stop() {
+	select {
+	case ks.stopTrigger <- false:
+	default:
+	}
+}
+
+// StopAndPurge stops Start after the current sync like Stop, and purges the
+// keys before the locks of the directories are released, i.e. when the daemon
+// is uninstalled. Start returns the error of the purge.
+func (ks *KeySyncServer) StopAndPurge() {
+	select {
+	case ks.stopTrigger <- true:
+	default:
+	}
+}
+
+// takeOver takes the locks of the directories of the sinks, and waits for the
+// handlers of the required secret types so that the first sync does not clean
+// up the keys of the previous instance whose handlers are not configured yet
+func (ks *KeySyncServer) takeOver() error {
+	ctx, cancel := context.WithTimeout(context.Background(), ks.lockTimeout)
+	defer cancel()
+	if err := ks.lock(ctx); err != nil {
+		return err
+	}
 
-		// Check if new handlers to add
+	ctx, cancel = context.WithTimeout(context.Background(), ks.lockTimeout)
+	defer cancel()
+	if err := ks.waitForRequiredHandlers(ctx); err != nil {
+		logrus.Warnf("Syncing before all handlers are configured: %v", err)
+	}
+	return nil
+}
+
+// SyncOnce performs a single complete sync of the keys, including the cleanup
+// of obsolete keys. It first takes the locks of the directories if enabled,
+// and waits for the handlers of the required secret types to be added, and
+// returns an error summarizing the secrets that failed to sync, if any.
+func (ks *KeySyncServer) SyncOnce(ctx context.Context) error {
+	if ks.lockKeySyncDirs {
+		if err := ks.lock(ctx); err != nil {
+			return err
+		}
+		defer ks.unlock()
+	}
+
+	if err := ks.waitForRequiredHandlers(ctx); err != nil {
+		return err
+	}
+
+	return ks.sync(ctx).Err()
+}
+
+// waitForRequiredHandlers waits until the handlers for all of the required
+// secret types have been added, or the context is done
+func (ks *KeySyncServer) waitForRequiredHandlers(ctx context.Context) error {
+	for {
+		missing := []string{}
 		ks.addKeyHandlersMutex.Lock()
-		for k, v := range ks.addKeyHandlers {
-			ks.keyHandlers[k] = v
+		for _, secType := range ks.requiredSecretTypes {
+			if ks.keyHandlers[secType] == nil && ks.addKeyHandlers[secType] == nil {
+				missing = append(missing, secType)
+			}
 		}
-		ks.addKeyHandlers = map[string]sechandlers.SecretKeyHandler{}
 		ks.addKeyHandlersMutex.Unlock()
 
-		// Get list of new keys so that we can clean up obselete keys for revocation reasons
-		allFilenameMap := map[string]bool{}
+		if len(missing) == 0 {
+			return nil
+		}
 
-		for secType, skh := range ks.keyHandlers {
-			secList, err := secClient.List(context.Background(), metav1.ListOptions{
-				FieldSelector: keyTypeFieldSelectorPrefix + secType,
-			})
-			if err != nil {
-				logrus.Errorf("Error listing secrets: %v", err)
-				continue
+		select {
+		case <-ctx.Done():
+			return errors.Errorf("handlers for secret types %v not configured: %v", missing, ctx.Err())
+		case <-time.After(requiredHandlersPollInterval):
+		}
+	}
+}
+
+// sync performs a single sync of the keys, errors are logged and recorded in
+// the result, and syncing is done on a best effort basis
+func (ks *KeySyncServer) sync(ctx context.Context) *SyncResult {
+	result := &SyncResult{Time: ks.clock.Now()}
+	keyExpirySeconds.Reset()
+
+	keyFiles, err := ks.keyFiles(ctx, result)
+	if err != nil {
+		// Keys are not cleaned up if they could not be retrieved at all
+		logrus.Errorf("Unable to retrieve keys: %v", err)
+		result.addError(v1alpha1.SecretReference{Namespace: ks.namespace}, "unable to retrieve keys: %v", err)
+	} else {
+		allowed := ks.applyPolicy(keyFiles, result)
+
+		for _, s := range ks.sinks {
+			// Get list of new keys so that we can clean up obselete keys for revocation reasons
+			filenameMap := s.syncKeyFiles(s.keyFiles(allowed, result), result)
+
+			// Purge keys which are not new
+			s.cleanupKeys(filenameMap)
+		}
+
+		if ks.secureWipe {
+			zeroizeKeyFiles(keyFiles)
+		}
+	}
+
+	result.sort()
+	for _, s := range ks.sinks {
+		if s.metadataFile != "" {
+			if err := s.writeMetadata(result); err != nil {
+				logrus.Errorf("Unable to write metadata file %s: %v", s.metadataFile, err)
 			}
-			filenameMap := ks.syncSecretsToLocalKeys(secList, skh)
+		}
+	}
+	if ks.syncReporter != nil {
+		ks.syncReporter(result)
+	}
+
+	return result
+}
 
-			allFilenameMap = combineFilenameMap(allFilenameMap, filenameMap)
+// keyFiles returns the key files to be synced, either from the key source or
+// by processing the secrets of the namespace with the key handlers. Errors
+// processing the secrets are logged and recorded in the result, an error is
+// returned if the keys could not be retrieved from the key source.
+func (ks *KeySyncServer) keyFiles(ctx context.Context, result *SyncResult) ([]keyFile, error) {
+	keyFiles := []keyFile{}
+	if ks.keySource != nil {
+		var err error
+		keyFiles, err = ks.sourceKeyFiles(ctx, result)
+		if err != nil {
+			return nil, err
 		}
+	} else {
+		ks.listSecrets(ctx, result, func(secList *corev1.SecretList, skh sechandlers.SecretKeyHandler) {
+			keyFiles = append(keyFiles, ks.secretsToKeyFiles(secList, skh, result)...)
+		})
 
-		// Purge keys which are not new
-		ks.cleanupKeys(allFilenameMap)
+		// Shamir shares are processed by group rather than one secret at a time
+		if secList, err := ks.listSecretsOfType(ctx, result, ShamirSecretType); err == nil {
+			keyFiles = append(keyFiles, ks.shamirKeyFiles(secList, result)...)
+		}
 	}
+
+	// Sort so that the same secret keeps a key file name requested by
+	// several secrets on every sync
+	sort.SliceStable(keyFiles, func(i, j int) bool {
+		a, b := keyFiles[i].secret, keyFiles[j].secret
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.Type < b.Type
+	})
+	return keyFiles, nil
+}
+
+// listSecrets adds the queued handlers, lists the secrets of each handled
+// secret type and calls fn with the list and the handler of the type. Errors
+// listing the secrets are logged and recorded in the result.
+func (ks *KeySyncServer) listSecrets(ctx context.Context, result *SyncResult, fn func(*corev1.SecretList, sechandlers.SecretKeyHandler)) {
+	// Check if new handlers to add
+	ks.addKeyHandlersMutex.Lock()
+	for k, v := range ks.addKeyHandlers {
+		ks.keyHandlers[k] = v
+	}
+	ks.addKeyHandlers = map[string]sechandlers.SecretKeyHandler{}
+	ks.addKeyHandlersMutex.Unlock()
+
+	for secType, skh := range ks.keyHandlers {
+		secList, err := ks.listSecretsOfType(ctx, result, secType)
+		if err != nil {
+			continue
+		}
+
+		fn(secList, skh)
+	}
+}
+
+// listSecretsOfType lists the secrets of the secret type, errors are logged and
+// recorded in the result
+func (ks *KeySyncServer) listSecretsOfType(ctx context.Context, result *SyncResult, secType string) (*corev1.SecretList, error) {
+	secList, err := ks.k8sClient.CoreV1().Secrets(ks.namespace).List(ctx, metav1.ListOptions{
+		FieldSelector: keyTypeFieldSelectorPrefix + secType,
+	})
+	if err != nil {
+		logrus.Errorf("Error listing secrets: %v", err)
+		result.addError(v1alpha1.SecretReference{Namespace: ks.namespace, Type: secType}, "unable to list secrets: %v", err)
+		return nil, err
+	}
+	return secList, nil
 }
 
 // AddKeyHandler will queue adding new handlers to the key sync server that will
@@ -180,113 +557,460 @@ func (ks *KeySyncServer) AddSecretKeyHandler(secretType string, skh sechandlers.
 	ks.addKeyHandlers[secretType] = skh
 }
 
-// combineFilenameMap returns a map that combines the contents of both f1 and f2
-// is potentially destructive to f1 for optimization reasons (like slice appends)
-func combineFilenameMap(f1 map[string]bool, f2 map[string]bool) map[string]bool {
-	if len(f1) == 0 {
-		return f2
-	}
+// keyFile is a key file processed from a secret, to be synced to the key sync
+// directory
+type keyFile struct {
+	// filename is the path of the file relative to the directory of the
+	// sink, set when the key file is placed in the layout of the sink
+	filename string
+
+	// legacyFilename is the path of the file named by the MD5 of the
+	// contents by previous versions, renamed instead of rewritten, empty if
+	// the path does not contain the hash
+	legacyFilename string
+
+	// atomicDir is the directory of the secret in the AtomicLayout, the
+	// filename is a symlink into its current version, empty otherwise
+	atomicDir string
+
+	// customFilename is the filename requested by the secret, empty for
+	// the path of the layout
+	customFilename string
+
+	// subdir is the subdirectory requested by the secret, empty for the
+	// directory of the sink
+	subdir string
+
+	// hash is the hash of the file contents
+	hash string
+
+	// legacyHash is the MD5 of the file contents, which previous versions
+	// named the files by
+	legacyHash string
+
+	// data is the file contents
+	data []byte
+
+	// secret is the secret the key file was processed from
+	secret v1alpha1.SecretReference
+
+	// secretFilename is the name of the file in the secret
+	secretFilename string
+
+	// labels are the labels of the secret
+	labels map[string]string
 
-	for k, b := range f2 {
-		f1[k] = b
+	// fileMode is the permissions of the file, if nil the sink default
+	fileMode *os.FileMode
+
+	// ownerUID is the owner UID of the file, if nil the sink default
+	ownerUID *int
+
+	// ownerGID is the owner GID of the file, if nil the sink default
+	ownerGID *int
+}
+
+// newKeyFile returns the key file of the file in the secret, to be placed in
+// the layout of each sink. The filename is chosen by the handler, possibly from
+// the contents of the secret, and must be a valid secret data key.
+func newKeyFile(filename string, data []byte, secret v1alpha1.SecretReference, labels map[string]string) (keyFile, error) {
+	if err := sechandlers.ValidateFilename(filename); err != nil {
+		return keyFile{}, err
 	}
 
-	return f1
+	// Construct canonical secret filename based on hash
+	// This way we can easily check if the file has changed,
+	// and remove the rest that are not in the list of hashes
+	return keyFile{
+		hash:           fmt.Sprintf("%x", sha256.Sum256(data)),
+		legacyHash:     fmt.Sprintf("%x", md5.Sum(data)), // #nosec G401 Not used to protect the contents
+		data:           data,
+		secret:         secret,
+		secretFilename: filename,
+		labels:         labels,
+	}, nil
 }
 
-// syncSecretsToLocalKeys syncs the secrets to the local keys, errors are logged
-// and syncing is done on a best effort basis and returns the list of filenames
-// that were written
-func (ks *KeySyncServer) syncSecretsToLocalKeys(secList *corev1.SecretList, skh sechandlers.SecretKeyHandler) map[string]bool {
+// syncKeyFiles syncs the key files to the local keys, errors are logged and
+// recorded in the result, and syncing is done on a best effort basis and
+// returns the list of filenames that were written
+func (s *sink) syncKeyFiles(keyFiles []keyFile, result *SyncResult) map[string]bool {
 	filenameMap := map[string]bool{}
-	for _, s := range secList.Items {
-		// Construct canonical secret filename based on hash
-		// This way we can easily check if the file has changed,
-		// and remove the rest that are not in the list of hashes
+	syncedFiles := map[string]string{}
+	atomicGroups := []atomicGroup{}
+	for _, kf := range keyFiles {
+		// Secrets may name their key files, the first secret in order keeps
+		// the name
+		if filenameMap[kf.filename] {
+			logrus.Errorf("Key file %s of secret %s is already synced from another secret", s.logName(kf.filename), kf.secret.Name)
+			result.addError(kf.secret, "key file %s is already synced from another secret", s.logName(kf.filename))
+			continue
+		}
+
+		// keep track of list of hashes for cleanup
+		filenameMap[kf.filename] = true
+
+		// The key files of a secret in the atomic layout are written
+		// together
+		if kf.atomicDir != "" {
+			if len(atomicGroups) == 0 || atomicGroups[len(atomicGroups)-1].dir != kf.atomicDir {
+				atomicGroups = append(atomicGroups, atomicGroup{dir: kf.atomicDir})
+			}
+			atomicGroups[len(atomicGroups)-1].keyFiles = append(atomicGroups[len(atomicGroups)-1].keyFiles, kf)
+			continue
+		}
+
+		// Write file to directory if file doesn't already exist or was
+		// updated, or restore it if it was modified on disk. Paths that do
+		// not contain the hash keep their name across updates, which are
+		// told apart from drifts by the hash written by the previous sync.
+		path := filepath.Join(s.keySyncDir, kf.filename)
+
+		drift := s.keyFileDrift(path, kf)
+		syncedHash, synced := s.syncedFiles[kf.filename]
+		switch {
+		case drift == "":
+		case drift == DriftMissing && !synced:
+			if !s.renameLegacyKeyFile(path, kf) {
+				logrus.Printf("Syncing new key: %v", s.logName(kf.filename))
+				if err := s.writeKeyFile(path, kf); err != nil {
+					logrus.Errorf("Unable to write file %s: %v", path, err)
+					result.addError(kf.secret, "unable to write key file %s: %v", s.logName(kf.filename), err)
+					continue
+				}
+			}
+		case syncedHash != kf.hash:
+			logrus.Printf("Updating key: %v", s.logName(kf.filename))
+			if err := s.writeKeyFile(path, kf); err != nil {
+				logrus.Errorf("Unable to write file %s: %v", path, err)
+				result.addError(kf.secret, "unable to update key file %s: %v", s.logName(kf.filename), err)
+				if synced {
+					syncedFiles[kf.filename] = syncedHash
+				}
+				continue
+			}
+		default:
+			logrus.Warnf("Restoring key %v, drifted on disk: %v", s.logName(kf.filename), drift)
+			keyFileDrifts.WithLabelValues(drift).Inc()
+			if err := s.writeKeyFile(path, kf); err != nil {
+				logrus.Errorf("Unable to write file %s: %v", path, err)
+				result.addError(kf.secret, "unable to restore key file %s: %v", s.logName(kf.filename), err)
+				syncedFiles[kf.filename] = syncedHash
+				continue
+			}
+		}
+
+		syncedFiles[kf.filename] = kf.hash
+		result.addKey(s.name, kf.filename, kf.hash, kf.secret)
+	}
+
+	for _, g := range atomicGroups {
+		files, err := s.syncAtomicKeyFiles(g)
+		if err != nil {
+			// The previous version is kept
+			logrus.Errorf("Unable to write keys of secret %s: %v", g.keyFiles[0].secret.Name, err)
+			result.addError(g.keyFiles[0].secret, "unable to write key files: %v", err)
+			files = s.currentAtomicFiles(g)
+			for _, kf := range g.keyFiles {
+				if syncedHash, synced := s.syncedFiles[kf.filename]; synced {
+					syncedFiles[kf.filename] = syncedHash
+				}
+			}
+		} else {
+			for _, kf := range g.keyFiles {
+				syncedFiles[kf.filename] = kf.hash
+				result.addKey(s.name, kf.filename, kf.hash, kf.secret)
+			}
+		}
+		for _, f := range files {
+			filenameMap[f] = true
+		}
+	}
 
+	s.syncedFiles = syncedFiles
+	s.watchKeyFileDirs(filenameMap)
+	return filenameMap
+}
+
+// secretsToKeyFiles processes the secrets with the handler into the key files
+// to be synced, errors are logged and recorded in the result, and processing
+// is done on a best effort basis
+func (ks *KeySyncServer) secretsToKeyFiles(secList *corev1.SecretList, skh sechandlers.SecretKeyHandler, result *SyncResult) []keyFile {
+	keyFiles := []keyFile{}
+	for _, s := range secList.Items {
 		namespace := s.GetNamespace()
 		if namespace == "" {
 			namespace = metav1.NamespaceDefault
 		}
 
-		name := s.GetName()
+		secRef := secretReference(&s, namespace)
+		if !ks.verifySecret(&s, secRef, result) || !ks.secretInValidityWindow(&s, secRef, result) {
+			continue
+		}
 
-		// Process the secrets to filename/priv key map
-		keyFiles, err := skh(s.Data)
+		// Process the secrets to filename/priv key map, the signature is
+		// not a key
+		files, err := skh(secsign.SignedData(&s))
 		if err != nil {
-			logrus.Errorf("Unable to process secret %s: %v", name, err)
+			logrus.Errorf("Unable to process secret %s: %v", s.GetName(), err)
+			result.addError(secRef, "unable to process secret: %v", err)
 			continue
 		}
 
 		// For each file in the secret
-		for filename, data := range keyFiles {
-			hashString := fmt.Sprintf("%x", md5.Sum(data)) // #nosec G401 Needed only to check if file exists
+		secretKeyFiles, err := ks.deliverKeyFiles(s.GetAnnotations(), files, secRef, s.GetLabels())
+		if err != nil {
+			logrus.Errorf("Unable to deliver secret %s: %v", s.GetName(), err)
+			result.addError(secRef, "%v", err)
+			continue
+		}
+		keyFiles = append(keyFiles, secretKeyFiles...)
+	}
+	return keyFiles
+}
 
-			// Hash contents of each file, and check if they exists
-			// already before writing
-			filename := getLocalKeyFilename(namespace, name, filename, hashString)
+// verifySecret verifies the secret with the secret verifier, if any, failures
+// are recorded in the result, and only reported by the verifier outside of a
+// dry run
+func (ks *KeySyncServer) verifySecret(s *corev1.Secret, secRef v1alpha1.SecretReference, result *SyncResult) bool {
+	if ks.secretVerifier == nil {
+		return true
+	}
+	verify := ks.secretVerifier.Verify
+	if result.dryRun {
+		verify = ks.secretVerifier.Check
+	}
+	if err := verify(s); err != nil {
+		result.addError(secRef, "secret verification failed: %v", err)
+		return false
+	}
+	return true
+}
 
-			// keep track of list of hashes for cleanup
-			filenameMap[filename] = true
+func (s *sink) cleanupKeys(filenameMap map[string]bool) {
+	// Remove all files that are not tracked based on filename map
+	// from above
+	for _, filename := range s.obsoleteKeys(filenameMap) {
+		path := filepath.Join(s.keySyncDir, filename)
+		logrus.Printf("Deleting old key: %v", s.logName(filename))
+		if s.secureWipe {
+			if err := wipeFile(path); err != nil && !os.IsNotExist(err) {
+				logrus.Errorf("Unable to wipe old key %v, %v", path, err)
+			}
+		}
+		if err := os.Remove(path); err != nil {
+			// Subdirectories are already removed with their last key file
+			if !os.IsNotExist(err) {
+				logrus.Errorf("Unable to delete old key %v, %v", path, err)
+			}
+			continue
+		}
 
-			// Write file to directory if file doesn't already exist
-			path := filepath.Join(ks.keySyncDir, filename)
+		// Remove the subdirectories of the key file once empty
+		for dir := filepath.Dir(filename); dir != "."; dir = filepath.Dir(dir) {
+			if err := os.Remove(filepath.Join(s.keySyncDir, dir)); err != nil {
+				break
+			}
+		}
+	}
+}
 
-			if !fileExists(path) {
-				logrus.Printf("Syncing new key: %v", filename)
-				err := ks.writeKeyFile(path, data)
-				if err != nil {
-					logrus.Errorf("Unable to write file %s: %v", path, err)
-					continue
-				}
+// Purge removes the key files managed by the server from the directories of
+// all the sinks, and their metadata files, i.e. after the daemon is
+// uninstalled. It first takes the locks of the directories if enabled, waiting
+// until the context is done while a running instance holds them, which purges
+// its keys itself with StopAndPurge. Returns an error if any key file could not
+// be removed.
+func (ks *KeySyncServer) Purge(ctx context.Context) error {
+	if ks.lockKeySyncDirs {
+		if err := ks.lock(ctx); err != nil {
+			return err
+		}
+		defer ks.unlock()
+	}
+	return ks.purge()
+}
+
+// purge removes the key files managed by the server from the directories of
+// all the sinks, and their metadata files
+func (ks *KeySyncServer) purge() error {
+	remaining := []string{}
+	for _, s := range ks.sinks {
+		s.cleanupKeys(map[string]bool{})
+		for _, filename := range s.obsoleteKeys(map[string]bool{}) {
+			remaining = append(remaining, s.logName(filename))
+		}
+
+		if s.metadataFile != "" {
+			if err := os.Remove(s.metadataFile); err != nil && !os.IsNotExist(err) {
+				logrus.Errorf("Unable to delete metadata file %s: %v", s.metadataFile, err)
 			}
 		}
+		s.syncedFiles = map[string]string{}
 	}
-	return filenameMap
+
+	if len(remaining) > 0 {
+		return errors.Errorf("unable to purge key files %v", remaining)
+	}
+	return nil
 }
 
-func (ks *KeySyncServer) cleanupKeys(filenameMap map[string]bool) {
+// obsoleteKeys returns the files in the key sync directory that are not part of
+// the current secrets based on the filename map
+func (s *sink) obsoleteKeys(filenameMap map[string]bool) []string {
 	// Do cleanup of files that are not part of current secrets
-	files, err := os.ReadDir(ks.keySyncDir)
+	files, err := os.ReadDir(s.keySyncDir)
 	if err != nil {
 		files = []fs.DirEntry{}
 		logrus.Errorf("Unable to list directory for cleanup")
 	}
 
-	// Remove all files that are not tracked based on filename map
-	// from above
+	// The metadata file is not a key file, in case it is kept in the key
+	// sync directory, and neither is the lock file
+	metadataFile := ""
+	if s.metadataFile != "" {
+		if rel, err := filepath.Rel(s.keySyncDir, s.metadataFile); err == nil && filepath.IsLocal(rel) {
+			metadataFile = filepath.ToSlash(rel)
+		}
+	}
+
+	obsolete := []string{}
+	seen := map[string]bool{}
+	addObsolete := func(filename string) {
+		if !filenameMap[filename] && !seen[filename] && filename != metadataFile && filename != metadataFile+".tmp" {
+			seen[filename] = true
+			obsolete = append(obsolete, filename)
+		}
+	}
+
+	// Key files of the previous metadata record are obsolete after a change
+	// of the layout, they are removed before their subdirectories, which
+	// are managed by the server like those of a nested layout
+	metadataDirs := map[string]bool{}
+	for _, filename := range s.metadataKeys() {
+		if fileExists(filepath.Join(s.keySyncDir, filename)) {
+			addObsolete(filename)
+		}
+		if dir := strings.SplitN(filename, "/", 2); len(dir) == 2 {
+			metadataDirs[dir[0]] = true
+		}
+	}
+
 	for _, file := range files {
-		filename := file.Name()
-		if !filenameMap[filename] {
-			path := filepath.Join(ks.keySyncDir, filename)
-			logrus.Printf("Deleting old key: %v", filename)
-			if err = os.Remove(path); err != nil {
-				logrus.Errorf("Unable to delete old key %v, %v", path, err)
+		// Subdirectories are managed by the server with a nested layout,
+		// otherwise only the allowed subdirectories
+		if file.IsDir() && (s.layout.nested || metadataDirs[file.Name()] || containsString(s.subdirectories, file.Name())) {
+			err := filepath.WalkDir(filepath.Join(s.keySyncDir, file.Name()), func(path string, d fs.DirEntry, err error) error {
+				if err != nil || d.IsDir() {
+					return err
+				}
+				rel, err := filepath.Rel(s.keySyncDir, path)
+				if err != nil {
+					return err
+				}
+				addObsolete(filepath.ToSlash(rel))
+				return nil
+			})
+			if err != nil {
+				logrus.Errorf("Unable to list subdirectory %s for cleanup: %v", file.Name(), err)
 			}
+			continue
 		}
+
+		addObsolete(file.Name())
 	}
+
+	return obsolete
 }
 
 // writeKeyFile writes key into the specified file
-// and makes sure that the file has the specified
-// permissions and ownership
-func (ks *KeySyncServer) writeKeyFile(filepath string, data []byte) error {
-	// Writing data into the specified file
-	err := os.WriteFile(filepath, data, ks.keyFilePermissions)
+// and makes sure that the file has the permissions
+// and ownership of the key file or the sink defaults.
+// The key is written to a temporary file in the same
+// directory which is renamed over the file, so that
+// readers never see a partially written key.
+func (s *sink) writeKeyFile(path string, kf keyFile) error {
+	// Key files may be delivered to a subdirectory, the key files
+	// themselves are protected by their permissions
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil { // #nosec G301
+		return err
+	}
+
+	tmpPath := filepath.Join(filepath.Dir(path), keyFileTmpPrefix+filepath.Base(path))
+	if err := s.writeTmpKeyFile(tmpPath, kf); err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+
+	// The previous contents are not left in the blocks freed by replacing
+	// the file, they are kept linked until the file is replaced, so that
+	// the key is never missing
+	wipePath := ""
+	if s.secureWipe {
+		wipePath = filepath.Join(filepath.Dir(path), keyFileWipePrefix+filepath.Base(path))
+		if err := os.Remove(wipePath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err := os.Link(path, wipePath); err != nil {
+			if !os.IsNotExist(err) {
+				_ = os.Remove(tmpPath)
+				return err
+			}
+			wipePath = ""
+		}
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		_ = os.Remove(tmpPath)
+		if wipePath != "" {
+			_ = os.Remove(wipePath)
+		}
+		return err
+	}
+
+	if wipePath != "" {
+		if err := wipeFile(wipePath); err != nil {
+			logrus.Errorf("Unable to wipe previous key %v, %v", path, err)
+		}
+		if err := os.Remove(wipePath); err != nil {
+			logrus.Errorf("Unable to delete previous key %v, %v", path, err)
+		}
+	}
+	return nil
+}
+
+// writeTmpKeyFile writes the key into the temporary file and syncs it to the
+// disk, with the permissions, ownership and label of the key file
+func (s *sink) writeTmpKeyFile(tmpPath string, kf keyFile) error {
+	permissions, ownerUID, ownerGID := s.keyFileAttributes(kf)
+
+	// Writing data into the temporary file
+	f, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, permissions) // #nosec G304
 	if err != nil {
 		return err
 	}
+	if _, err := f.Write(kf.data); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
 
 	// Getting information about the written file
-	fileInfo, err := os.Stat(filepath)
+	fileInfo, err := os.Stat(tmpPath)
 	if err != nil {
 		return err
 	}
 
-	// Permission configuration might be needed as WriteFile does not
+	// Permission configuration might be needed as OpenFile does not
 	// guarantee the specified permissions due to umask
-	if fileInfo.Mode() != ks.keyFilePermissions {
-		err = os.Chmod(filepath, ks.keyFilePermissions)
+	if fileInfo.Mode() != permissions {
+		err = os.Chmod(tmpPath, permissions)
 		if err != nil {
 			return err
 		}
@@ -295,19 +1019,45 @@ func (ks *KeySyncServer) writeKeyFile(filepath string, data []byte) error {
 	// Owner configuration when a specific uid:gid is configured
 	// in order for this to work CAP_CHOWN is needed
 	// #nosec G115 userid and groupid should not be bigger then uint32
-	if ((ks.keyFileOwnerUID != nil) && (fileInfo.Sys().(*syscall.Stat_t).Uid != uint32(*ks.keyFileOwnerUID))) ||
-		((ks.keyFileOwnerGID != nil) && (fileInfo.Sys().(*syscall.Stat_t).Gid != uint32(*ks.keyFileOwnerGID))) {
-		err = os.Chown(filepath, *ks.keyFileOwnerUID, *ks.keyFileOwnerGID)
+	if ((ownerUID != nil) && (fileInfo.Sys().(*syscall.Stat_t).Uid != uint32(*ownerUID))) ||
+		((ownerGID != nil) && (fileInfo.Sys().(*syscall.Stat_t).Gid != uint32(*ownerGID))) {
+		uid, gid := -1, -1
+		if ownerUID != nil {
+			uid = *ownerUID
+		}
+		if ownerGID != nil {
+			gid = *ownerGID
+		}
+		err = os.Chown(tmpPath, uid, gid)
 		if err != nil {
 			return err
 		}
 	}
 
-	return nil
+	// SELinux label configuration, so that the container runtime can read
+	// the file under its policy, in order for this to work the process
+	// needs to be allowed to relabel the files
+	return s.labelPath(tmpPath)
+}
+
+// keyFileAttributes returns the permissions and owner of the key file, or the
+// sink defaults
+func (s *sink) keyFileAttributes(kf keyFile) (os.FileMode, *int, *int) {
+	permissions, ownerUID, ownerGID := s.keyFilePermissions, s.keyFileOwnerUID, s.keyFileOwnerGID
+	if kf.fileMode != nil {
+		permissions = *kf.fileMode
+	}
+	if kf.ownerUID != nil {
+		ownerUID = kf.ownerUID
+	}
+	if kf.ownerGID != nil {
+		ownerGID = kf.ownerGID
+	}
+	return permissions, ownerUID, ownerGID
 }
 
 // getLocalKeyFilename returns the local filename to use, format is
-// <md5>-namespace-secretName-filename
+// <sha256>-namespace-secretName-filename
 // i.e. a948904f2f0f479b8f8197694b30184b0d2ed1c1cd2a1ec0fb85d299a192a447-default-mysecret-a.pem
 func getLocalKeyFilename(namespace, name, filename, hashString string) string {
 	return fmt.Sprintf("%s-%s-%s-%s", hashString, namespace, name, filename)
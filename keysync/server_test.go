@@ -16,15 +16,30 @@ package keysync
 
 import (
 	"context"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/lumjjb/k8s-enc-image-operator/apis/v1alpha1"
+	"github.com/lumjjb/k8s-enc-image-operator/keysync/sechandlers"
+	"github.com/lumjjb/k8s-enc-image-operator/secsign"
+	"github.com/lumjjb/k8s-enc-image-operator/shamir"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/runtime"
 	coretesting "k8s.io/client-go/testing"
+	testingclock "k8s.io/utils/clock/testing"
 
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -432,3 +447,2270 @@ func TestKeySyncAddHandlersAfterStart(t *testing.T) {
 		t.Fatalf("KeySyncServer errored: %v", kssErr)
 	}
 }
+
+// TestKeySyncOnce runs through a single sync waiting for a required handler,
+// and reporting secrets that failed to sync
+func TestKeySyncOnce(t *testing.T) {
+	// Setup
+	tmpDir, err := os.MkdirTemp("", "keysync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err = os.RemoveAll(tmpDir) // clean up
+		if err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	var (
+		fakeClient = fake.NewClientset()
+		namespace  = "default"
+	)
+
+	// Only return secrets of the type listed, see TestKeySyncAddHandlersBeforeStart
+	fakeClient.PrependReactor("list", "secrets", func(action coretesting.Action) (handled bool, ret runtime.Object, err error) {
+		switch la := action.(type) {
+		case coretesting.ListAction:
+			if la.GetListRestrictions().Fields.String() == "type=key" {
+				return true, &corev1.SecretList{
+					Items: []corev1.Secret{},
+				}, nil
+			}
+		}
+		return false, nil, nil
+	})
+
+	ksc := KeySyncServerConfig{
+		K8sClient:           fakeClient,
+		Interval:            time.Second,
+		KeySyncDir:          tmpDir,
+		Namespace:           namespace,
+		KeyFilePermissions:  os.FileMode(0600),
+		RequiredSecretTypes: []string{"base64-key"},
+	}
+	kss := NewKeySyncServer(ksc)
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "my-secret",
+		},
+		Data: map[string][]byte{
+			"mykey": []byte("dGhpcyBpcyBhIGtleQ=="), //  base64 of "this is a key"
+		},
+		Type: "base64-key",
+	}
+	_, err = fakeClient.CoreV1().Secrets(namespace).Create(context.Background(), secret, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatalf("Unable to create secret: %v", err)
+	}
+
+	// Should time out waiting for the required handler
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	err = kss.SyncOnce(ctx)
+	cancel()
+	if err == nil {
+		t.Fatal("SyncOnce should fail without required handler")
+	}
+
+	// Handler added asynchronously is waited for
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		kss.AddSecretKeyHandler("base64-key", func(data map[string][]byte) (map[string][]byte, error) {
+			retmap := map[string][]byte{}
+			for k, v := range data {
+				s, err := base64.StdEncoding.DecodeString(string(v))
+				if err != nil {
+					return nil, err
+				}
+				retmap[k] = s
+			}
+			return retmap, nil
+		})
+	}()
+
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	err = kss.SyncOnce(ctx)
+	cancel()
+	if err != nil {
+		t.Fatalf("SyncOnce failed: %v", err)
+	}
+
+	files, err := os.ReadDir(tmpDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("Should have 1 file at this point, have %v", len(files))
+	}
+
+	// Secrets failing to sync are reported
+	secret.Name = "my-invalid-secret"
+	secret.Data["mykey"] = []byte("not base64")
+	_, err = fakeClient.CoreV1().Secrets(namespace).Create(context.Background(), secret, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatalf("Unable to create secret: %v", err)
+	}
+
+	err = kss.SyncOnce(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "my-invalid-secret") {
+		t.Fatalf("SyncOnce should report the invalid secret, got %v", err)
+	}
+}
+
+// TestKeySyncPlan tests that the plan lists the keys a sync would write and
+// remove without touching the key sync directory
+func TestKeySyncPlan(t *testing.T) {
+	// Setup
+	tmpDir, err := os.MkdirTemp("", "keysync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err = os.RemoveAll(tmpDir) // clean up
+		if err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	var (
+		fakeClient = fake.NewClientset()
+		namespace  = "default"
+	)
+
+	// Only return secrets of the type listed, see TestKeySyncAddHandlersBeforeStart
+	fakeClient.PrependReactor("list", "secrets", func(action coretesting.Action) (handled bool, ret runtime.Object, err error) {
+		switch la := action.(type) {
+		case coretesting.ListAction:
+			if la.GetListRestrictions().Fields.String() == "type=key" {
+				return true, &corev1.SecretList{
+					Items: []corev1.Secret{},
+				}, nil
+			}
+		}
+		return false, nil, nil
+	})
+
+	base64SecretHandler := func(data map[string][]byte) (map[string][]byte, error) {
+		retmap := map[string][]byte{}
+		for k, v := range data {
+			s, err := base64.StdEncoding.DecodeString(string(v))
+			if err != nil {
+				return nil, err
+			}
+			retmap[k] = s
+		}
+		return retmap, nil
+	}
+
+	ksc := KeySyncServerConfig{
+		K8sClient:          fakeClient,
+		Interval:           time.Second,
+		KeySyncDir:         tmpDir,
+		Namespace:          namespace,
+		KeyFilePermissions: os.FileMode(0600),
+	}
+	kss := NewKeySyncServer(ksc)
+	kss.AddSecretKeyHandler("base64-key", base64SecretHandler)
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "my-secret",
+		},
+		Data: map[string][]byte{
+			"mykey": []byte("dGhpcyBpcyBhIGtleQ=="), //  base64 of "this is a key"
+		},
+		Type: "base64-key",
+	}
+	_, err = fakeClient.CoreV1().Secrets(namespace).Create(context.Background(), secret, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatalf("Unable to create secret: %v", err)
+	}
+
+	if err := kss.SyncOnce(context.Background()); err != nil {
+		t.Fatalf("SyncOnce failed: %v", err)
+	}
+
+	// Change the secret, add an invalid secret and an obsolete file
+	secret.Data["mykey"] = []byte("YW5vdGhlciBrZXk=") // base64 of "another key"
+	_, err = fakeClient.CoreV1().Secrets(namespace).Update(context.Background(), secret, metav1.UpdateOptions{})
+	if err != nil {
+		t.Fatalf("Unable to update secret: %v", err)
+	}
+	secret.Name = "my-invalid-secret"
+	secret.Data["mykey"] = []byte("not base64")
+	_, err = fakeClient.CoreV1().Secrets(namespace).Create(context.Background(), secret, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatalf("Unable to create secret: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(tmpDir, "obsolete"), []byte("old"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	before, err := os.ReadDir(tmpDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plan, err := kss.Plan(context.Background())
+	if err != nil {
+		t.Fatalf("Plan failed: %v", err)
+	}
+
+	if len(plan.Add) != 1 || plan.Add[0].Secret.Name != "my-secret" {
+		t.Fatalf("Plan should add the changed key, got %v", plan.Add)
+	}
+	if len(plan.Remove) != 2 {
+		t.Fatalf("Plan should remove the old key and the obsolete file, got %v", plan.Remove)
+	}
+	if len(plan.Errors) != 1 || plan.Errors[0].Secret.Name != "my-invalid-secret" {
+		t.Fatalf("Plan should report the invalid secret, got %v", plan.Errors)
+	}
+
+	after, err := os.ReadDir(tmpDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(after) != len(before) {
+		t.Fatalf("Plan should not touch the key sync directory, had %v files, have %v", len(before), len(after))
+	}
+}
+
+// fakeKeySource is a KeySource returning fixed keys or an error
+type fakeKeySource struct {
+	keys []SourceKey
+	err  error
+}
+
+func (f *fakeKeySource) Keys(ctx context.Context) ([]SourceKey, []v1alpha1.SyncError, error) {
+	return f.keys, nil, f.err
+}
+
+// TestKeySyncKeySource tests syncing the keys of a key source, and that keys
+// are not removed if the key source is unavailable
+func TestKeySyncKeySource(t *testing.T) {
+	// Setup
+	tmpDir, err := os.MkdirTemp("", "keysync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err = os.RemoveAll(tmpDir) // clean up
+		if err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	source := &fakeKeySource{
+		keys: []SourceKey{{
+			Filename: "mykey",
+			Data:     []byte("this is a key"),
+			Secret:   v1alpha1.SecretReference{Namespace: "enc-key-sync", Name: "my-secret", Type: "kp-key"},
+		}},
+	}
+
+	ksc := KeySyncServerConfig{
+		K8sClient:          fake.NewClientset(),
+		Interval:           time.Second,
+		KeySyncDir:         tmpDir,
+		Namespace:          "enc-key-sync",
+		KeyFilePermissions: os.FileMode(0600),
+		KeySource:          source,
+	}
+	kss := NewKeySyncServer(ksc)
+
+	if err := kss.SyncOnce(context.Background()); err != nil {
+		t.Fatalf("SyncOnce failed: %v", err)
+	}
+
+	files, err := os.ReadDir(tmpDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || !strings.HasSuffix(files[0].Name(), "-enc-key-sync-my-secret-mykey") {
+		t.Fatalf("Should have synced the key of the key source, have %v", files)
+	}
+
+	source.err = errors.New("hub unavailable")
+	if err := kss.SyncOnce(context.Background()); err == nil {
+		t.Fatal("SyncOnce should fail if the key source is unavailable")
+	}
+
+	files, err = os.ReadDir(tmpDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("Keys should not be removed if the key source is unavailable, have %v", files)
+	}
+}
+
+// TestKeySyncShamir tests that the key of a Shamir group is only synced while
+// the threshold of its shares, plain or wrapped, is available
+func TestKeySyncShamir(t *testing.T) {
+	// Setup
+	tmpDir, err := os.MkdirTemp("", "keysync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err = os.RemoveAll(tmpDir) // clean up
+		if err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	var (
+		fakeClient = fake.NewClientset()
+		namespace  = "default"
+	)
+
+	// Only return secrets of the type listed, the fake client does not honor
+	// field selectors
+	fakeClient.PrependReactor("list", "secrets", func(action coretesting.Action) (handled bool, ret runtime.Object, err error) {
+		la := action.(coretesting.ListAction)
+		secList, err := fakeClient.Tracker().List(
+			corev1.SchemeGroupVersion.WithResource("secrets"),
+			corev1.SchemeGroupVersion.WithKind("Secret"),
+			namespace)
+		if err != nil {
+			return true, nil, err
+		}
+		filtered := &corev1.SecretList{}
+		for _, s := range secList.(*corev1.SecretList).Items {
+			if la.GetListRestrictions().Fields.String() == keyTypeFieldSelectorPrefix+string(s.Type) {
+				filtered.Items = append(filtered.Items, s)
+			}
+		}
+		return true, filtered, nil
+	})
+
+	base64SecretHandler := func(data map[string][]byte) (map[string][]byte, error) {
+		retmap := map[string][]byte{}
+		for k, v := range data {
+			s, err := base64.StdEncoding.DecodeString(string(v))
+			if err != nil {
+				return nil, err
+			}
+			retmap[k] = s
+		}
+		return retmap, nil
+	}
+
+	ksc := KeySyncServerConfig{
+		K8sClient:          fakeClient,
+		Interval:           time.Second,
+		KeySyncDir:         tmpDir,
+		Namespace:          namespace,
+		KeyFilePermissions: os.FileMode(0600),
+	}
+	kss := NewKeySyncServer(ksc)
+	kss.AddSecretKeyHandler("base64-key", base64SecretHandler)
+
+	key := []byte("this is a key")
+	shares, err := shamir.Split(key, 3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(key)
+	digest := []byte(hex.EncodeToString(sum[:]))
+
+	// shareSecret returns a share secret of the group
+	shareSecret := func(name string, share []byte, filename, digest []byte) *corev1.Secret {
+		return &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Data: map[string][]byte{
+				ShamirGroupField:     []byte("my-group"),
+				ShamirThresholdField: []byte("2"),
+				ShamirFilenameField:  filename,
+				ShamirDigestField:    digest,
+				ShamirShareField:     share,
+			},
+			Type: ShamirSecretType,
+		}
+	}
+
+	for i, share := range shares {
+		data := shareSecret("", share, []byte("mykey"), digest).Data
+		// Wrap the last share with the base64 handler
+		if i == 2 {
+			delete(data, ShamirShareField)
+			data[ShamirShareTypeField] = []byte("base64-key")
+			data["wrapped"] = []byte(base64.StdEncoding.EncodeToString(share))
+		}
+		_, err = fakeClient.CoreV1().Secrets(namespace).Create(context.Background(), &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("my-share-%d", i)},
+			Data:       data,
+			Type:       ShamirSecretType,
+		}, metav1.CreateOptions{})
+		if err != nil {
+			t.Fatalf("Unable to create secret: %v", err)
+		}
+	}
+
+	expectKey := func(present bool) {
+		files, err := os.ReadDir(tmpDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !present {
+			if len(files) != 0 {
+				t.Fatalf("Key should not be synced, have %v", files)
+			}
+			return
+		}
+		if len(files) != 1 || !strings.HasSuffix(files[0].Name(), "-default-my-group-mykey") {
+			t.Fatalf("Should have synced the key of the group, have %v", files)
+		}
+		data, err := os.ReadFile(filepath.Join(tmpDir, files[0].Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != string(key) {
+			t.Fatalf("Expected %q, got %q", key, data)
+		}
+	}
+
+	if err := kss.SyncOnce(context.Background()); err != nil {
+		t.Fatalf("SyncOnce failed: %v", err)
+	}
+	expectKey(true)
+
+	// The threshold is still met with a plain and the wrapped share
+	if err := fakeClient.CoreV1().Secrets(namespace).Delete(context.Background(), "my-share-0", metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := kss.SyncOnce(context.Background()); err != nil {
+		t.Fatalf("SyncOnce failed: %v", err)
+	}
+	expectKey(true)
+
+	// Below the threshold the key is removed
+	if err := fakeClient.CoreV1().Secrets(namespace).Delete(context.Background(), "my-share-2", metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	err = kss.SyncOnce(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "only 1 of 2 required shares available") {
+		t.Fatalf("SyncOnce should report the group below the threshold, got %v", err)
+	}
+	expectKey(false)
+
+	// A share cannot choose the filename of the key on its own
+	for filename, expectedErr := range map[string]string{
+		"other":      "disagree on key filename",
+		"../escaped": "invalid key filename",
+	} {
+		if err := fakeClient.CoreV1().Secrets(namespace).Delete(context.Background(), "my-share-0", metav1.DeleteOptions{}); err != nil && !k8serrors.IsNotFound(err) {
+			t.Fatal(err)
+		}
+		_, err = fakeClient.CoreV1().Secrets(namespace).Create(context.Background(), shareSecret("my-share-0", shares[0], []byte(filename), digest), metav1.CreateOptions{})
+		if err != nil {
+			t.Fatalf("Unable to create secret: %v", err)
+		}
+		err = kss.SyncOnce(context.Background())
+		if err == nil || !strings.Contains(err.Error(), expectedErr) {
+			t.Fatalf("SyncOnce should report %q for key filename %q, got %v", expectedErr, filename, err)
+		}
+		expectKey(false)
+	}
+
+	// replaceShare replaces the share secret and syncs, expecting the error
+	replaceShare := func(secret *corev1.Secret, expectedErr string) {
+		t.Helper()
+		if err := fakeClient.CoreV1().Secrets(namespace).Delete(context.Background(), secret.Name, metav1.DeleteOptions{}); err != nil && !k8serrors.IsNotFound(err) {
+			t.Fatal(err)
+		}
+		if _, err := fakeClient.CoreV1().Secrets(namespace).Create(context.Background(), secret, metav1.CreateOptions{}); err != nil {
+			t.Fatalf("Unable to create secret: %v", err)
+		}
+		err := kss.SyncOnce(context.Background())
+		if expectedErr == "" && err != nil {
+			t.Fatalf("SyncOnce failed: %v", err)
+		}
+		if expectedErr != "" && (err == nil || !strings.Contains(err.Error(), expectedErr)) {
+			t.Fatalf("SyncOnce should report %q, got %v", expectedErr, err)
+		}
+	}
+
+	// Nor the digest of the key, which is required
+	replaceShare(shareSecret("my-share-0", shares[0], []byte("mykey"), []byte("0123")), "invalid or missing key digest")
+	expectKey(false)
+	otherSum := sha256.Sum256([]byte("another key"))
+	replaceShare(shareSecret("my-share-0", shares[0], []byte("mykey"), []byte(hex.EncodeToString(otherSum[:]))), "disagree on key digest")
+	expectKey(false)
+	replaceShare(shareSecret("my-share-0", shares[0], []byte("mykey"), digest), "")
+	expectKey(true)
+
+	// A copy of a share counts once
+	if err := fakeClient.CoreV1().Secrets(namespace).Delete(context.Background(), "my-share-0", metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	replaceShare(shareSecret("my-share-copy", shares[1], []byte("mykey"), digest), "only 1 of 2 required shares available")
+	expectKey(false)
+	replaceShare(shareSecret("my-share-0", shares[0], []byte("mykey"), digest), "")
+	expectKey(true)
+
+	// Shares of another split of the key are not combined into a wrong key
+	resplit, err := shamir.Split(key, 3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replaceShare(shareSecret("my-share-resplit", resplit[2], []byte("mykey"), digest), "does not match the key digest")
+	expectKey(false)
+}
+
+// fakeVerifier refuses secrets without the signed annotation
+type fakeVerifier struct {
+	// reported counts the failures reported by Verify
+	reported *int
+}
+
+func (v fakeVerifier) Verify(s *corev1.Secret) error {
+	err := v.Check(s)
+	if err != nil && v.reported != nil {
+		*v.reported++
+	}
+	return err
+}
+
+func (fakeVerifier) Check(s *corev1.Secret) error {
+	if s.GetAnnotations()["signed"] != "true" {
+		return errors.New("secret is not signed")
+	}
+	return nil
+}
+
+// TestKeySyncSecretVerifier tests that secrets failing verification are not
+// synced, and that their keys are removed
+func TestKeySyncSecretVerifier(t *testing.T) {
+	// Setup
+	tmpDir, err := os.MkdirTemp("", "keysync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err = os.RemoveAll(tmpDir) // clean up
+		if err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	var (
+		fakeClient = fake.NewClientset()
+		namespace  = "default"
+		reported   = 0
+	)
+
+	ksc := KeySyncServerConfig{
+		K8sClient:          fakeClient,
+		Interval:           time.Second,
+		KeySyncDir:         tmpDir,
+		Namespace:          namespace,
+		KeyFilePermissions: os.FileMode(0600),
+		SecretVerifier:     fakeVerifier{reported: &reported},
+	}
+	kss := NewKeySyncServer(ksc)
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "my-secret",
+			Annotations: map[string]string{"signed": "true"},
+		},
+		Data: map[string][]byte{
+			"mykey":                []byte("this is a key"),
+			secsign.SignatureField: []byte("c2lnbmF0dXJl"),
+		},
+		Type: "key",
+	}
+	_, err = fakeClient.CoreV1().Secrets(namespace).Create(context.Background(), secret, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatalf("Unable to create secret: %v", err)
+	}
+
+	if err := kss.SyncOnce(context.Background()); err != nil {
+		t.Fatalf("SyncOnce failed: %v", err)
+	}
+	files, err := os.ReadDir(tmpDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The signature data field is not synced as a key file
+	if len(files) != 1 || !strings.HasSuffix(files[0].Name(), "-default-my-secret-mykey") {
+		t.Fatalf("Should have synced the signed key only, have %v", files)
+	}
+
+	// Replace the key without signing it
+	secret.Annotations = nil
+	secret.Data["mykey"] = []byte("injected key")
+	_, err = fakeClient.CoreV1().Secrets(namespace).Update(context.Background(), secret, metav1.UpdateOptions{})
+	if err != nil {
+		t.Fatalf("Unable to update secret: %v", err)
+	}
+
+	// A dry run lists the failure without reporting it
+	plan, err := kss.Plan(context.Background())
+	if err != nil {
+		t.Fatalf("Plan failed: %v", err)
+	}
+	if len(plan.Errors) != 1 || !strings.Contains(plan.Errors[0].Message, "secret verification failed") || reported != 0 {
+		t.Fatalf("Plan should list the unsigned secret without reporting it, got %v, reported %v", plan.Errors, reported)
+	}
+
+	err = kss.SyncOnce(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "secret verification failed") {
+		t.Fatalf("SyncOnce should report the unsigned secret, got %v", err)
+	}
+	if reported != 1 {
+		t.Fatalf("SyncOnce should report the unsigned secret once, reported %v", reported)
+	}
+	files, err = os.ReadDir(tmpDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Fatalf("Should not have synced the unsigned key, have %v", files)
+	}
+}
+
+// fakePolicy denies secrets without the allowed label and caps the number of
+// key files
+type fakePolicy struct {
+	maxKeys int
+}
+
+func (p fakePolicy) Evaluate(secrets []PolicySecret) []error {
+	errs := make([]error, len(secrets))
+	keys := 0
+	for i, s := range secrets {
+		if s.Labels["allowed"] != "true" {
+			errs[i] = errors.New("secret is not allowed")
+			continue
+		}
+		if keys+len(s.Files) > p.maxKeys {
+			errs[i] = errors.New("too many keys")
+			continue
+		}
+		keys += len(s.Files)
+	}
+	return errs
+}
+
+// TestKeySyncSecretPolicy tests that secrets denied by the policy are reported
+// and their keys are not written
+func TestKeySyncSecretPolicy(t *testing.T) {
+	// Setup
+	tmpDir, err := os.MkdirTemp("", "keysync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err = os.RemoveAll(tmpDir) // clean up
+		if err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	var (
+		fakeClient = fake.NewClientset()
+		namespace  = "default"
+	)
+
+	ksc := KeySyncServerConfig{
+		K8sClient:          fakeClient,
+		Interval:           time.Second,
+		KeySyncDir:         tmpDir,
+		Namespace:          namespace,
+		KeyFilePermissions: os.FileMode(0600),
+		SecretPolicy:       fakePolicy{maxKeys: 2},
+	}
+	kss := NewKeySyncServer(ksc)
+
+	for _, s := range []struct {
+		name    string
+		allowed string
+		keys    int
+	}{
+		{"a-secret", "true", 1},
+		{"b-secret", "false", 1},
+		{"c-secret", "true", 2},
+		{"d-secret", "true", 1},
+	} {
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   s.name,
+				Labels: map[string]string{"allowed": s.allowed},
+			},
+			Data: map[string][]byte{},
+			Type: "key",
+		}
+		for i := 0; i < s.keys; i++ {
+			secret.Data[fmt.Sprintf("key%d", i)] = []byte(s.name + " key " + fmt.Sprint(i))
+		}
+		_, err = fakeClient.CoreV1().Secrets(namespace).Create(context.Background(), secret, metav1.CreateOptions{})
+		if err != nil {
+			t.Fatalf("Unable to create secret: %v", err)
+		}
+	}
+
+	plan, err := kss.Plan(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Add) != 2 || len(plan.Errors) != 2 {
+		t.Fatalf("Plan should add the keys of a-secret and d-secret only, got %+v", plan)
+	}
+
+	err = kss.SyncOnce(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "b-secret (type=key): denied by policy: secret is not allowed") ||
+		!strings.Contains(err.Error(), "c-secret (type=key): denied by policy: too many keys") {
+		t.Fatalf("SyncOnce should report the denied secrets, got %v", err)
+	}
+
+	files, err := os.ReadDir(tmpDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, f := range files {
+		names = append(names, f.Name())
+	}
+	if len(names) != 2 || !strings.Contains(names[0]+names[1], "a-secret") || !strings.Contains(names[0]+names[1], "d-secret") {
+		t.Fatalf("Should have synced the keys of a-secret and d-secret only, have %v", names)
+	}
+}
+
+// TestKeySyncValidityWindow tests that keys are only synced inside the
+// validity window of their secret, and removed once expired
+func TestKeySyncValidityWindow(t *testing.T) {
+	// Setup
+	tmpDir, err := os.MkdirTemp("", "keysync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err = os.RemoveAll(tmpDir) // clean up
+		if err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	var (
+		fakeClient = fake.NewClientset()
+		namespace  = "default"
+		start      = time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+		fakeClock  = testingclock.NewFakePassiveClock(start)
+	)
+
+	ksc := KeySyncServerConfig{
+		K8sClient:           fakeClient,
+		Interval:            time.Second,
+		KeySyncDir:          tmpDir,
+		Namespace:           namespace,
+		KeyFilePermissions:  os.FileMode(0600),
+		Clock:               fakeClock,
+		ExpiryWarningPeriod: 24 * time.Hour,
+	}
+	kss := NewKeySyncServer(ksc)
+
+	for name, annotations := range map[string]map[string]string{
+		"current-key": {
+			NotAfterAnnotation: start.Add(48 * time.Hour).Format(time.RFC3339),
+		},
+		"next-key": {
+			NotBeforeAnnotation: start.Add(24 * time.Hour).Format(time.RFC3339),
+		},
+	} {
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				Annotations: annotations,
+			},
+			Data: map[string][]byte{
+				"mykey": []byte("this is " + name),
+			},
+			Type: "key",
+		}
+		_, err = fakeClient.CoreV1().Secrets(namespace).Create(context.Background(), secret, metav1.CreateOptions{})
+		if err != nil {
+			t.Fatalf("Unable to create secret: %v", err)
+		}
+	}
+
+	checkSynced := func(expected ...string) {
+		t.Helper()
+		if err := kss.SyncOnce(context.Background()); err != nil {
+			t.Fatalf("SyncOnce failed: %v", err)
+		}
+		files, err := os.ReadDir(tmpDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		synced := []string{}
+		for _, f := range files {
+			for _, name := range []string{"current-key", "next-key"} {
+				if strings.Contains(f.Name(), name) {
+					synced = append(synced, name)
+				}
+			}
+		}
+		sort.Strings(synced)
+		if !reflect.DeepEqual(synced, expected) {
+			t.Fatalf("Expected keys %v to be synced at %v, have %v", expected, fakeClock.Now(), synced)
+		}
+	}
+
+	checkSynced("current-key")
+	if remaining := testutil.ToFloat64(keyExpirySeconds.WithLabelValues(namespace, "current-key")); remaining != (48 * time.Hour).Seconds() {
+		t.Fatalf("Expected current-key to expire in 48h, got %vs", remaining)
+	}
+
+	fakeClock.SetTime(start.Add(24 * time.Hour))
+	checkSynced("current-key", "next-key")
+
+	fakeClock.SetTime(start.Add(48 * time.Hour))
+	checkSynced("next-key")
+
+	// Invalid windows are reported
+	secret, err := fakeClient.CoreV1().Secrets(namespace).Get(context.Background(), "next-key", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret.Annotations[NotAfterAnnotation] = "tomorrow"
+	if _, err := fakeClient.CoreV1().Secrets(namespace).Update(context.Background(), secret, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := kss.SyncOnce(context.Background()); err == nil || !strings.Contains(err.Error(), "invalid validity window") {
+		t.Fatalf("SyncOnce should report the invalid window, got %v", err)
+	}
+}
+
+// TestKeySyncDelivery tests the delivery annotations of secrets and their
+// validation against the allowlist
+func TestKeySyncDelivery(t *testing.T) {
+	// Setup
+	tmpDir, err := os.MkdirTemp("", "keysync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err = os.RemoveAll(tmpDir) // clean up
+		if err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	var (
+		fakeClient = fake.NewClientset()
+		namespace  = "default"
+	)
+
+	ksc := KeySyncServerConfig{
+		K8sClient:          fakeClient,
+		Interval:           time.Second,
+		KeySyncDir:         tmpDir,
+		Namespace:          namespace,
+		KeyFilePermissions: os.FileMode(0600),
+		DeliveryAllowlist: &DeliveryAllowlist{
+			FileModes:      []string{"0640"},
+			OwnerUIDs:      []int{os.Getuid()},
+			Filenames:      []string{"*.pem"},
+			Subdirectories: []string{"crio"},
+		},
+	}
+	kss := NewKeySyncServer(ksc)
+
+	secrets := map[string]*corev1.Secret{
+		"subset": {
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "subset",
+				Annotations: map[string]string{KeysAnnotation: "a"},
+			},
+			Data: map[string][]byte{"a": []byte("key a"), "b": []byte("key b")},
+			Type: "key",
+		},
+		"named": {
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "named",
+				Annotations: map[string]string{
+					FilenameAnnotation:     "my.pem",
+					SubdirectoryAnnotation: "crio",
+					FileModeAnnotation:     "0640",
+					OwnerUIDAnnotation:     fmt.Sprint(os.Getuid()),
+				},
+			},
+			Data: map[string][]byte{"mykey": []byte("named key")},
+			Type: "key",
+		},
+	}
+	for _, s := range secrets {
+		if _, err := fakeClient.CoreV1().Secrets(namespace).Create(context.Background(), s, metav1.CreateOptions{}); err != nil {
+			t.Fatalf("Unable to create secret: %v", err)
+		}
+	}
+
+	// Obsolete files in the allowed subdirectories are removed
+	if err := os.Mkdir(filepath.Join(tmpDir, "crio"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(tmpDir, "crio", "old.pem"), []byte("old key"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := kss.SyncOnce(context.Background()); err != nil {
+		t.Fatalf("SyncOnce failed: %v", err)
+	}
+
+	files, err := os.ReadDir(tmpDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 || !strings.HasSuffix(files[0].Name(), "-default-subset-a") || files[1].Name() != "crio" {
+		t.Fatalf("Expected key a of subset and the crio subdirectory, have %v", files)
+	}
+
+	checkNamed := func(expected string) {
+		t.Helper()
+		path := filepath.Join(tmpDir, "crio", "my.pem")
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != expected {
+			t.Fatalf("Expected %q in my.pem, got %q", expected, data)
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode() != 0640 {
+			t.Fatalf("Expected mode 0640, got %v", info.Mode())
+		}
+		if _, err := os.Stat(filepath.Join(tmpDir, "crio", "old.pem")); !os.IsNotExist(err) {
+			t.Fatalf("Obsolete key in subdirectory should be removed, got %v", err)
+		}
+	}
+	checkNamed("named key")
+
+	// Named key files are updated in place
+	secrets["named"].Data["mykey"] = []byte("rotated key")
+	if _, err := fakeClient.CoreV1().Secrets(namespace).Update(context.Background(), secrets["named"], metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := kss.SyncOnce(context.Background()); err != nil {
+		t.Fatalf("SyncOnce failed: %v", err)
+	}
+	checkNamed("rotated key")
+
+	// Options outside of the allowlist are refused
+	for annotation, value := range map[string]string{
+		FileModeAnnotation:     "0644",
+		OwnerGIDAnnotation:     "0",
+		FilenameAnnotation:     "../escape.pem",
+		SubdirectoryAnnotation: "other",
+		KeysAnnotation:         "missing",
+	} {
+		secret := secrets["subset"].DeepCopy()
+		secret.Annotations = map[string]string{annotation: value}
+		if _, err := fakeClient.CoreV1().Secrets(namespace).Update(context.Background(), secret, metav1.UpdateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		if err := kss.SyncOnce(context.Background()); err == nil || !strings.Contains(err.Error(), "subset") {
+			t.Fatalf("SyncOnce should refuse %s=%s, got %v", annotation, value, err)
+		}
+	}
+}
+
+// TestLayout tests the paths of the layouts and their escaping
+func TestLayout(t *testing.T) {
+	secret := v1alpha1.SecretReference{Namespace: "a-b", Name: "c", Type: "key"}
+	hash := "0123456789abcdef0123456789abcdef"
+
+	for spec, expected := range map[string]string{
+		FlatLayout:         hash + "-a-b-c-%2Ekey.pem",
+		HierarchicalLayout: "a-b/c/%2Ekey.pem",
+		AtomicLayout:       "a-b/c/%2Ekey.pem",
+		"{{.Hash}}-{{.Namespace}}-{{.Name}}-{{.Filename}}":           hash + "-a%2Db-c-%2Ekey.pem",
+		"{{.Namespace}}_{{.Name}}/{{slice .Hash 0 8}}_{{.Filename}}": "a-b_c/01234567_%2Ekey.pem",
+	} {
+		l, err := NewLayout(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		path, err := l.path(hash, secret, ".key.pem")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if path != expected {
+			t.Fatalf("Expected %v for layout %v, got %v", expected, spec, path)
+		}
+	}
+
+	// Names of Shamir groups are not validated by the API server
+	l, err := NewLayout(FlatLayout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path, err := l.path(hash, v1alpha1.SecretReference{Namespace: "a", Name: "../../b"}, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path != hash+"-a-%2E.%2F..%2Fb-key" {
+		t.Fatalf("Expected the name of the flat layout to be escaped, got %v", path)
+	}
+
+	for _, spec := range []string{
+		"{{.Namespace}}/{{.Filename}}",
+		"../{{.Namespace}}/{{.Name}}/{{.Filename}}",
+		"/{{.Namespace}}/{{.Name}}/{{.Filename}}",
+		"{{.Namespace}}/{{.Name}}/{{.Unknown}}",
+		"{{.Namespace",
+	} {
+		if _, err := NewLayout(spec); err == nil {
+			t.Fatalf("Layout %v should be invalid", spec)
+		}
+	}
+}
+
+// TestKeySyncLayoutMigration tests that a change of the layout writes the key
+// files in the new layout before removing the previous ones
+func TestKeySyncLayoutMigration(t *testing.T) {
+	// Setup
+	tmpDir, err := os.MkdirTemp("", "keysync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err = os.RemoveAll(tmpDir) // clean up
+		if err != nil {
+			t.Fatal(err)
+		}
+	}()
+	keyDir := filepath.Join(tmpDir, "keys")
+	if err := os.Mkdir(keyDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	metadataFile := filepath.Join(tmpDir, "metadata.json")
+
+	var (
+		fakeClient = fake.NewClientset()
+		namespace  = "default"
+	)
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "my-secret",
+		},
+		Data: map[string][]byte{
+			"mykey": []byte("this is a key"),
+		},
+		Type: "key",
+	}
+	if _, err := fakeClient.CoreV1().Secrets(namespace).Create(context.Background(), secret, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("Unable to create secret: %v", err)
+	}
+
+	syncWithLayout := func(spec string) []string {
+		t.Helper()
+		layout, err := NewLayout(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		kss := NewKeySyncServer(KeySyncServerConfig{
+			K8sClient:          fakeClient,
+			Interval:           time.Second,
+			KeySyncDir:         keyDir,
+			Namespace:          namespace,
+			KeyFilePermissions: os.FileMode(0600),
+			Layout:             layout,
+			MetadataFile:       metadataFile,
+		})
+		if err := kss.SyncOnce(context.Background()); err != nil {
+			t.Fatalf("SyncOnce failed: %v", err)
+		}
+
+		files := []string{}
+		err = filepath.WalkDir(keyDir, func(path string, d fs.DirEntry, err error) error {
+			if err == nil && path != keyDir {
+				rel, _ := filepath.Rel(keyDir, path)
+				files = append(files, rel)
+			}
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return files
+	}
+
+	hash := "c9fc5d06292274fd98bcb57882657bf71de1eda4df902c519d915fc585b10190"
+	if files := syncWithLayout(FlatLayout); !reflect.DeepEqual(files, []string{hash + "-default-my-secret-mykey"}) {
+		t.Fatalf("Unexpected flat layout %v", files)
+	}
+
+	if files := syncWithLayout(HierarchicalLayout); !reflect.DeepEqual(files, []string{"default", "default/my-secret", "default/my-secret/mykey"}) {
+		t.Fatalf("Unexpected hierarchical layout %v", files)
+	}
+
+	md, err := ReadMetadataFile(metadataFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if md.Layout != hierarchicalTemplate || len(md.Keys) != 1 || md.Keys[0].Filename != "default/my-secret/mykey" ||
+		md.Keys[0].Hash != hash || md.Keys[0].Secret.Name != "my-secret" {
+		t.Fatalf("Unexpected metadata %+v", md)
+	}
+
+	// The previous nested layout is cleaned up with the metadata record
+	if files := syncWithLayout(FlatLayout); !reflect.DeepEqual(files, []string{hash + "-default-my-secret-mykey"}) {
+		t.Fatalf("Unexpected flat layout after migration %v", files)
+	}
+
+	// So are the version directories of the atomic layout
+	if files := syncWithLayout(AtomicLayout); len(files) != 6 {
+		t.Fatalf("Unexpected atomic layout %v", files)
+	}
+	if files := syncWithLayout(FlatLayout); !reflect.DeepEqual(files, []string{hash + "-default-my-secret-mykey"}) {
+		t.Fatalf("Unexpected flat layout after atomic migration %v", files)
+	}
+
+	// Updates of key files keeping their path replace the files instead of
+	// rewriting them in place, so readers see either key in full
+	syncWithLayout(HierarchicalLayout)
+	f, err := os.Open(filepath.Join(keyDir, "default", "my-secret", "mykey"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	secret.Data["mykey"] = []byte("this is a rotated key")
+	if _, err := fakeClient.CoreV1().Secrets(namespace).Update(context.Background(), secret, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("Unable to update secret: %v", err)
+	}
+	if files := syncWithLayout(HierarchicalLayout); !reflect.DeepEqual(files, []string{"default", "default/my-secret", "default/my-secret/mykey"}) {
+		t.Fatalf("Unexpected hierarchical layout after update %v", files)
+	}
+	if data, err := io.ReadAll(f); err != nil || string(data) != "this is a key" {
+		t.Fatalf("Expected the previous key file to be kept intact, got %q, %v", data, err)
+	}
+	if data, err := os.ReadFile(filepath.Join(keyDir, "default", "my-secret", "mykey")); err != nil || string(data) != "this is a rotated key" {
+		t.Fatalf("Expected the key file to be updated, got %q, %v", data, err)
+	}
+}
+
+// TestKeySyncLegacyMD5Rename tests that key files named by the MD5 of their
+// contents by previous versions are renamed instead of rewritten
+func TestKeySyncLegacyMD5Rename(t *testing.T) {
+	// Setup
+	tmpDir, err := os.MkdirTemp("", "keysync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err = os.RemoveAll(tmpDir) // clean up
+		if err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	var (
+		fakeClient = fake.NewClientset()
+		namespace  = "default"
+	)
+
+	ksc := KeySyncServerConfig{
+		K8sClient:          fakeClient,
+		Interval:           time.Second,
+		KeySyncDir:         tmpDir,
+		Namespace:          namespace,
+		KeyFilePermissions: os.FileMode(0600),
+	}
+	kss := NewKeySyncServer(ksc)
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "my-secret",
+		},
+		Data: map[string][]byte{
+			"mykey": []byte("this is a key"),
+		},
+		Type: "key",
+	}
+	if _, err := fakeClient.CoreV1().Secrets(namespace).Create(context.Background(), secret, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("Unable to create secret: %v", err)
+	}
+
+	legacyPath := filepath.Join(tmpDir, "7d0897da070ef04eecdb8e7e2aed7cbe-default-my-secret-mykey")
+	if err := os.WriteFile(legacyPath, []byte("this is a key"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	legacyInfo, err := os.Stat(legacyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The plan lists the rename, rather than a new and an obsolete key
+	plan, err := kss.Plan(context.Background())
+	if err != nil {
+		t.Fatalf("Plan failed: %v", err)
+	}
+	expectedRename := []SyncRename{{
+		From: "7d0897da070ef04eecdb8e7e2aed7cbe-default-my-secret-mykey",
+		To:   "c9fc5d06292274fd98bcb57882657bf71de1eda4df902c519d915fc585b10190-default-my-secret-mykey",
+	}}
+	if len(plan.Add) != 0 || len(plan.Remove) != 0 || !reflect.DeepEqual(plan.Rename, expectedRename) {
+		t.Fatalf("Plan should only rename the legacy key file, got %+v", plan)
+	}
+
+	if err := kss.SyncOnce(context.Background()); err != nil {
+		t.Fatalf("SyncOnce failed: %v", err)
+	}
+
+	files, err := os.ReadDir(tmpDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(tmpDir, "c9fc5d06292274fd98bcb57882657bf71de1eda4df902c519d915fc585b10190-default-my-secret-mykey")
+	if len(files) != 1 || filepath.Join(tmpDir, files[0].Name()) != path {
+		t.Fatalf("Expected the key named by its SHA-256 only, have %v", files)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !os.SameFile(legacyInfo, info) {
+		t.Fatal("Legacy key file should be renamed instead of rewritten")
+	}
+}
+
+// TestKeySyncDrift tests that key files deleted or modified on disk are
+// restored, on the next sync and immediately when the key sync directory is
+// watched
+func TestKeySyncDrift(t *testing.T) {
+	// Setup
+	tmpDir, err := os.MkdirTemp("", "keysync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err = os.RemoveAll(tmpDir) // clean up
+		if err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	var (
+		fakeClient = fake.NewClientset()
+		namespace  = "default"
+	)
+
+	ksc := KeySyncServerConfig{
+		K8sClient:          fakeClient,
+		Interval:           time.Hour,
+		KeySyncDir:         tmpDir,
+		Namespace:          namespace,
+		KeyFilePermissions: os.FileMode(0600),
+	}
+	kss := NewKeySyncServer(ksc)
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "my-secret",
+		},
+		Data: map[string][]byte{
+			"mykey": []byte("this is a key"),
+		},
+		Type: "key",
+	}
+	if _, err := fakeClient.CoreV1().Secrets(namespace).Create(context.Background(), secret, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("Unable to create secret: %v", err)
+	}
+
+	if err := kss.SyncOnce(context.Background()); err != nil {
+		t.Fatalf("SyncOnce failed: %v", err)
+	}
+	path := filepath.Join(tmpDir, "c9fc5d06292274fd98bcb57882657bf71de1eda4df902c519d915fc585b10190-default-my-secret-mykey")
+
+	checkRestored := func() {
+		t.Helper()
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != "this is a key" || info.Mode() != 0600 {
+			t.Fatalf("Key file should be restored, got %q with mode %v", data, info.Mode())
+		}
+	}
+
+	for drift, tamper := range map[string]func() error{
+		DriftContent: func() error { return os.WriteFile(path, []byte("tampered"), 0600) },
+		DriftMode:    func() error { return os.Chmod(path, 0644) },
+		DriftMissing: func() error { return os.Remove(path) },
+	} {
+		before := testutil.ToFloat64(keyFileDrifts.WithLabelValues(drift))
+		if err := tamper(); err != nil {
+			t.Fatal(err)
+		}
+		if err := kss.SyncOnce(context.Background()); err != nil {
+			t.Fatalf("SyncOnce failed: %v", err)
+		}
+		checkRestored()
+		if after := testutil.ToFloat64(keyFileDrifts.WithLabelValues(drift)); after != before+1 {
+			t.Fatalf("Expected %v drift to be counted, got %v", drift, after-before)
+		}
+	}
+
+	// With the watcher, drifts trigger a sync before the next interval
+	if err := kss.startWatcher(); err != nil {
+		t.Fatal(err)
+	}
+	defer kss.watcher.Close()
+
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-kss.syncTrigger:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Deleting the key file should trigger a sync")
+	}
+	if err := kss.SyncOnce(context.Background()); err != nil {
+		t.Fatalf("SyncOnce failed: %v", err)
+	}
+	checkRestored()
+
+	// Updates of key files keeping their path are not drifts
+	for _, spec := range []string{HierarchicalLayout, AtomicLayout} {
+		layout, err := NewLayout(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		layoutDir := filepath.Join(tmpDir, spec)
+		layoutKss := NewKeySyncServer(KeySyncServerConfig{
+			K8sClient:          fakeClient,
+			Interval:           time.Hour,
+			KeySyncDir:         layoutDir,
+			Namespace:          namespace,
+			KeyFilePermissions: os.FileMode(0600),
+			Layout:             layout,
+		})
+		if err := layoutKss.SyncOnce(context.Background()); err != nil {
+			t.Fatalf("SyncOnce failed: %v", err)
+		}
+
+		before := testutil.ToFloat64(keyFileDrifts.WithLabelValues(DriftContent))
+		secret.Data["mykey"] = []byte("this is a rotated key")
+		if _, err := fakeClient.CoreV1().Secrets(namespace).Update(context.Background(), secret, metav1.UpdateOptions{}); err != nil {
+			t.Fatalf("Unable to update secret: %v", err)
+		}
+		if err := layoutKss.SyncOnce(context.Background()); err != nil {
+			t.Fatalf("SyncOnce failed: %v", err)
+		}
+		if data, err := os.ReadFile(filepath.Join(layoutDir, namespace, "my-secret", "mykey")); err != nil || string(data) != "this is a rotated key" {
+			t.Fatalf("Expected the %v key file to be updated, got %q, %v", spec, data, err)
+		}
+		if after := testutil.ToFloat64(keyFileDrifts.WithLabelValues(DriftContent)); after != before {
+			t.Fatalf("Expected the %v update not to be counted as drift, got %v", spec, after-before)
+		}
+
+		// Tampering with the updated key file still is
+		if err := os.WriteFile(filepath.Join(layoutDir, namespace, "my-secret", "mykey"), []byte("tampered"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := layoutKss.SyncOnce(context.Background()); err != nil {
+			t.Fatalf("SyncOnce failed: %v", err)
+		}
+		if after := testutil.ToFloat64(keyFileDrifts.WithLabelValues(DriftContent)); after != before+1 {
+			t.Fatalf("Expected the %v drift to be counted, got %v", spec, after-before)
+		}
+
+		secret.Data["mykey"] = []byte("this is a key")
+		if _, err := fakeClient.CoreV1().Secrets(namespace).Update(context.Background(), secret, metav1.UpdateOptions{}); err != nil {
+			t.Fatalf("Unable to update secret: %v", err)
+		}
+	}
+}
+
+func TestKeySyncAtomicLayout(t *testing.T) {
+	// Setup
+	tmpDir, err := os.MkdirTemp("", "keysync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err = os.RemoveAll(tmpDir) // clean up
+		if err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	var (
+		fakeClient = fake.NewClientset()
+		namespace  = "default"
+	)
+
+	layout, err := NewLayout(AtomicLayout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kss := NewKeySyncServer(KeySyncServerConfig{
+		K8sClient:          fakeClient,
+		Interval:           time.Hour,
+		KeySyncDir:         tmpDir,
+		Namespace:          namespace,
+		KeyFilePermissions: os.FileMode(0600),
+		Layout:             layout,
+	})
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "my-secret",
+		},
+		Data: map[string][]byte{
+			"mykey":    []byte("this is a key"),
+			"otherkey": []byte("this is another key"),
+		},
+		Type: "key",
+	}
+	if _, err := fakeClient.CoreV1().Secrets(namespace).Create(context.Background(), secret, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("Unable to create secret: %v", err)
+	}
+
+	dir := filepath.Join(tmpDir, "default", "my-secret")
+
+	// checkVersion checks the key files are published by the ..data symlink
+	// to a single version directory, and returns the version
+	checkVersion := func(data map[string][]byte) string {
+		t.Helper()
+		version, err := os.Readlink(filepath.Join(dir, atomicDataLink))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(version, atomicVersionPrefix) {
+			t.Fatalf("Unexpected version %v", version)
+		}
+
+		files, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		names := []string{}
+		for _, f := range files {
+			names = append(names, f.Name())
+		}
+		if expected := []string{atomicDataLink, version, "mykey", "otherkey"}; !reflect.DeepEqual(names, expected) {
+			t.Fatalf("Expected files %v, got %v", expected, names)
+		}
+
+		for name, expected := range data {
+			link, err := os.Readlink(filepath.Join(dir, name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if link != atomicDataLink+"/"+name {
+				t.Fatalf("Unexpected link of %v: %v", name, link)
+			}
+			got, err := os.ReadFile(filepath.Join(dir, name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != string(expected) {
+				t.Fatalf("Expected %q in %v, got %q", expected, name, got)
+			}
+		}
+		return version
+	}
+
+	if err := kss.SyncOnce(context.Background()); err != nil {
+		t.Fatalf("SyncOnce failed: %v", err)
+	}
+	version := checkVersion(secret.Data)
+
+	// A sync without changes keeps the version
+	if err := kss.SyncOnce(context.Background()); err != nil {
+		t.Fatalf("SyncOnce failed: %v", err)
+	}
+	if v := checkVersion(secret.Data); v != version {
+		t.Fatalf("Expected version %v to be kept, got %v", version, v)
+	}
+
+	// An update publishes both keys in a new version
+	secret.Data = map[string][]byte{
+		"mykey":    []byte("this is a new key"),
+		"otherkey": []byte("this is another new key"),
+	}
+	if _, err := fakeClient.CoreV1().Secrets(namespace).Update(context.Background(), secret, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("Unable to update secret: %v", err)
+	}
+	if err := kss.SyncOnce(context.Background()); err != nil {
+		t.Fatalf("SyncOnce failed: %v", err)
+	}
+	newVersion := checkVersion(secret.Data)
+	if newVersion == version {
+		t.Fatalf("Expected a new version, got %v", newVersion)
+	}
+
+	// A tampered key file is restored in a new version
+	if err := os.WriteFile(filepath.Join(dir, newVersion, "mykey"), []byte("tampered"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := kss.SyncOnce(context.Background()); err != nil {
+		t.Fatalf("SyncOnce failed: %v", err)
+	}
+	if v := checkVersion(secret.Data); v == newVersion {
+		t.Fatalf("Expected a new version, got %v", v)
+	}
+
+	// The secret directory is removed with the secret
+	if err := fakeClient.CoreV1().Secrets(namespace).Delete(context.Background(), secret.Name, metav1.DeleteOptions{}); err != nil {
+		t.Fatalf("Unable to delete secret: %v", err)
+	}
+	if err := kss.SyncOnce(context.Background()); err != nil {
+		t.Fatalf("SyncOnce failed: %v", err)
+	}
+	if files, err := os.ReadDir(tmpDir); err != nil || len(files) != 0 {
+		t.Fatalf("Expected empty key sync directory, got %v, %v", files, err)
+	}
+}
+
+// TestKeySyncSinks tests that keys are synced to additional sinks with their
+// own layout, permissions and secret types, and cleaned up independently
+func TestKeySyncSinks(t *testing.T) {
+	// Setup
+	tmpDir, err := os.MkdirTemp("", "keysync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err = os.RemoveAll(tmpDir) // clean up
+		if err != nil {
+			t.Fatal(err)
+		}
+	}()
+	keyDir := filepath.Join(tmpDir, "crio")
+	sinkDir := filepath.Join(tmpDir, "containerd")
+	for _, dir := range []string{keyDir, sinkDir} {
+		if err := os.Mkdir(dir, 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sinksFile := filepath.Join(tmpDir, "sinks.yaml")
+	if err := os.WriteFile(sinksFile, []byte(fmt.Sprintf(`sinks:
+- name: containerd
+  dir: %s
+  keyFilePermissions: "0640"
+  layout: hierarchical
+  secretTypes: ["key"]
+`, sinkDir)), 0600); err != nil {
+		t.Fatal(err)
+	}
+	sinks, err := LoadSinksFile(sinksFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		fakeClient = fake.NewClientset()
+		namespace  = "default"
+	)
+
+	// Only return secrets of the type listed, the fake client does not honor
+	// field selectors
+	fakeClient.PrependReactor("list", "secrets", func(action coretesting.Action) (handled bool, ret runtime.Object, err error) {
+		la := action.(coretesting.ListAction)
+		secList, err := fakeClient.Tracker().List(
+			corev1.SchemeGroupVersion.WithResource("secrets"),
+			corev1.SchemeGroupVersion.WithKind("Secret"),
+			namespace)
+		if err != nil {
+			return true, nil, err
+		}
+		filtered := &corev1.SecretList{}
+		for _, s := range secList.(*corev1.SecretList).Items {
+			if la.GetListRestrictions().Fields.String() == keyTypeFieldSelectorPrefix+string(s.Type) {
+				filtered.Items = append(filtered.Items, s)
+			}
+		}
+		return true, filtered, nil
+	})
+
+	kss := NewKeySyncServer(KeySyncServerConfig{
+		K8sClient:          fakeClient,
+		Interval:           time.Hour,
+		KeySyncDir:         keyDir,
+		Namespace:          namespace,
+		KeyFilePermissions: os.FileMode(0600),
+		Sinks:              sinks,
+	})
+	kss.AddSecretKeyHandler("other-key", func(data map[string][]byte) (map[string][]byte, error) {
+		return data, nil
+	})
+
+	for _, secret := range []*corev1.Secret{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "my-secret"},
+			Data:       map[string][]byte{"mykey": []byte("this is a key")},
+			Type:       "key",
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "other-secret"},
+			Data:       map[string][]byte{"otherkey": []byte("this is another key")},
+			Type:       "other-key",
+		},
+	} {
+		if _, err := fakeClient.CoreV1().Secrets(namespace).Create(context.Background(), secret, metav1.CreateOptions{}); err != nil {
+			t.Fatalf("Unable to create secret: %v", err)
+		}
+	}
+
+	var result *SyncResult
+	kss.syncReporter = func(r *SyncResult) { result = r }
+	if err := kss.SyncOnce(context.Background()); err != nil {
+		t.Fatalf("SyncOnce failed: %v", err)
+	}
+
+	synced := []string{}
+	for _, k := range result.Keys {
+		synced = append(synced, k.Sink+":"+k.Filename)
+	}
+	expected := []string{
+		":c9fc5d06292274fd98bcb57882657bf71de1eda4df902c519d915fc585b10190-default-my-secret-mykey",
+		":fcd64721df92fac855713a003bb8e965cb0269c16c8de6baee2f3b5b3c1d56ac-default-other-secret-otherkey",
+		"containerd:default/my-secret/mykey",
+	}
+	if !reflect.DeepEqual(synced, expected) {
+		t.Fatalf("Expected synced keys %v, got %v", expected, synced)
+	}
+
+	info, err := os.Stat(filepath.Join(sinkDir, "default", "my-secret", "mykey"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode() != 0640 {
+		t.Fatalf("Expected mode 0640 in the sink, got %v", info.Mode())
+	}
+
+	// The key files are cleaned up from every sink with the secret
+	if err := fakeClient.CoreV1().Secrets(namespace).Delete(context.Background(), "my-secret", metav1.DeleteOptions{}); err != nil {
+		t.Fatalf("Unable to delete secret: %v", err)
+	}
+	if err := kss.SyncOnce(context.Background()); err != nil {
+		t.Fatalf("SyncOnce failed: %v", err)
+	}
+	if files, err := os.ReadDir(sinkDir); err != nil || len(files) != 0 {
+		t.Fatalf("Expected empty sink, got %v, %v", files, err)
+	}
+	if files, err := os.ReadDir(keyDir); err != nil || len(files) != 1 {
+		t.Fatalf("Expected the other key in the key sync directory, got %v, %v", files, err)
+	}
+}
+
+// TestKeySyncPurge tests that purging removes the key files of all the sinks
+// and their metadata files
+func TestKeySyncPurge(t *testing.T) {
+	// Setup
+	tmpDir, err := os.MkdirTemp("", "keysync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err = os.RemoveAll(tmpDir) // clean up
+		if err != nil {
+			t.Fatal(err)
+		}
+	}()
+	keyDir := filepath.Join(tmpDir, "keys")
+	sinkDir := filepath.Join(tmpDir, "sink")
+	metadataFile := filepath.Join(tmpDir, "metadata.json")
+
+	var (
+		fakeClient = fake.NewClientset()
+		namespace  = "default"
+	)
+
+	layout, err := NewLayout(AtomicLayout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ksc := KeySyncServerConfig{
+		K8sClient:          fakeClient,
+		Interval:           50 * time.Millisecond,
+		KeySyncDir:         keyDir,
+		Namespace:          namespace,
+		KeyFilePermissions: os.FileMode(0600),
+		MetadataFile:       metadataFile,
+		Sinks: []SinkConfig{{
+			Name:               "sink",
+			KeySyncDir:         sinkDir,
+			KeyFilePermissions: os.FileMode(0600),
+			Layout:             layout,
+		}},
+		LockKeySyncDirs: true,
+		LockTimeout:     5 * time.Second,
+	}
+	kss := NewKeySyncServer(ksc)
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "my-secret",
+		},
+		Data: map[string][]byte{
+			"mykey": []byte("this is a key"),
+		},
+		Type: "key",
+	}
+	if _, err := fakeClient.CoreV1().Secrets(namespace).Create(context.Background(), secret, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("Unable to create secret: %v", err)
+	}
+
+	if err := kss.SyncOnce(context.Background()); err != nil {
+		t.Fatalf("SyncOnce failed: %v", err)
+	}
+	if !fileExists(metadataFile) || !fileExists(filepath.Join(sinkDir, "default", "my-secret", "mykey")) {
+		t.Fatal("Expected the keys to be synced")
+	}
+
+	expectPurged := func() {
+		t.Helper()
+		for _, dir := range []string{keyDir, sinkDir} {
+			if files, err := os.ReadDir(dir); err != nil || len(files) != 0 {
+				t.Fatalf("Expected %v to be empty, got %v, %v", dir, files, err)
+			}
+		}
+		if fileExists(metadataFile) {
+			t.Fatal("Expected the metadata file to be removed")
+		}
+	}
+
+	if err := kss.Purge(context.Background()); err != nil {
+		t.Fatalf("Purge failed: %v", err)
+	}
+	expectPurged()
+
+	// A running instance holds the locks, so that it does not rewrite the
+	// keys once purged by another process, and purges them itself
+	done := make(chan error)
+	go func() { done <- kss.Start() }()
+	deadline := time.Now().Add(5 * time.Second)
+	for !fileExists(metadataFile) {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the keys to be synced")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	err = NewKeySyncServer(ksc).Purge(ctx)
+	cancel()
+	if err == nil || !strings.Contains(err.Error(), "is locked by") {
+		t.Fatalf("Expected the purge to wait for the locks, got %v", err)
+	}
+
+	kss.StopAndPurge()
+	if err := <-done; err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	expectPurged()
+}
+
+// TestFindMount tests finding the mount of a path, its filesystem type and
+// propagation, in the mountinfo
+func TestFindMount(t *testing.T) {
+	mountInfo := []byte(`22 1 8:1 / / rw,relatime shared:1 - ext4 /dev/sda1 rw
+30 22 0:25 / /var/lib/kubelet rw,relatime shared:5 - ext4 /dev/sda2 rw
+40 22 8:1 /etc/crio/keys /keys rw,relatime master:1 - ext4 /dev/sda1 rw
+41 22 8:1 /etc/crio/keys /keys\040shared rw,relatime shared:7 master:1 - ext4 /dev/sda1 rw
+42 22 8:1 /etc/crio/other /other rw,relatime - tmpfs tmpfs rw
+`)
+
+	for path, expected := range map[string]mount{
+		"/":                        {mountPoint: "/", fsType: "ext4", shared: true},
+		"/var/lib/kubelet/pods":    {mountPoint: "/var/lib/kubelet", fsType: "ext4", shared: true},
+		"/keys":                    {mountPoint: "/keys", fsType: "ext4"},
+		"/keys/sub":                {mountPoint: "/keys", fsType: "ext4"},
+		"/keys shared/sub":         {mountPoint: "/keys shared", fsType: "ext4", shared: true},
+		"/other":                   {mountPoint: "/other", fsType: "tmpfs"},
+		"/otherdir/on/the/rootfs":  {mountPoint: "/", fsType: "ext4", shared: true},
+		"/var/lib/kubeletsibling/": {mountPoint: "/", fsType: "ext4", shared: true},
+	} {
+		m, err := findMount(mountInfo, filepath.Clean(path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m != expected {
+			t.Fatalf("Expected %v to be on %+v, got %+v", path, expected, m)
+		}
+	}
+}
+
+// TestKeySyncSecureWipe tests that revoked key files are overwritten before
+// they are removed, and that the keys are zeroized in memory once written
+func TestKeySyncSecureWipe(t *testing.T) {
+	// Setup
+	tmpDir, err := os.MkdirTemp("", "keysync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err = os.RemoveAll(tmpDir) // clean up
+		if err != nil {
+			t.Fatal(err)
+		}
+	}()
+	keyDir := filepath.Join(tmpDir, "keys")
+	if err := os.Mkdir(keyDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		fakeClient = fake.NewClientset()
+		namespace  = "default"
+	)
+
+	kss := NewKeySyncServer(KeySyncServerConfig{
+		K8sClient:          fakeClient,
+		Interval:           time.Hour,
+		KeySyncDir:         keyDir,
+		Namespace:          namespace,
+		KeyFilePermissions: os.FileMode(0400),
+		SecureWipe:         true,
+	})
+
+	// Keep the unwrapped keys returned by the handler
+	unwrapped := [][]byte{}
+	kss.keyHandlers["key"] = func(data map[string][]byte) (map[string][]byte, error) {
+		files := map[string][]byte{}
+		for k, v := range data {
+			files[k] = append([]byte{}, v...)
+			unwrapped = append(unwrapped, files[k])
+		}
+		return files, nil
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "my-secret",
+		},
+		Data: map[string][]byte{
+			"mykey": []byte("this is a key"),
+		},
+		Type: "key",
+	}
+	if _, err := fakeClient.CoreV1().Secrets(namespace).Create(context.Background(), secret, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("Unable to create secret: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := kss.SyncOnce(context.Background()); err != nil {
+			t.Fatalf("SyncOnce failed: %v", err)
+		}
+	}
+	path := filepath.Join(keyDir, "c9fc5d06292274fd98bcb57882657bf71de1eda4df902c519d915fc585b10190-default-my-secret-mykey")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "this is a key" {
+		t.Fatalf("Unexpected key file contents %q", data)
+	}
+	for _, u := range unwrapped {
+		if !reflect.DeepEqual(u, make([]byte, len(u))) {
+			t.Fatalf("Expected the unwrapped key to be zeroized, got %q", u)
+		}
+	}
+
+	// A hard link outside of the key sync directory shows the contents of
+	// the removed key file
+	link := filepath.Join(tmpDir, "link")
+	if err := os.Link(path, link); err != nil {
+		t.Fatal(err)
+	}
+	if err := fakeClient.CoreV1().Secrets(namespace).Delete(context.Background(), secret.Name, metav1.DeleteOptions{}); err != nil {
+		t.Fatalf("Unable to delete secret: %v", err)
+	}
+	if err := kss.SyncOnce(context.Background()); err != nil {
+		t.Fatalf("SyncOnce failed: %v", err)
+	}
+	if fileExists(path) {
+		t.Fatal("Expected the key file to be removed")
+	}
+	data, err = os.ReadFile(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(data, make([]byte, len("this is a key"))) {
+		t.Fatalf("Expected the key file to be wiped, got %q", data)
+	}
+}
+
+// TestKeySyncLock tests that a single instance syncs to a locked directory,
+// and that the next instance takes over the keys once the lock is released
+func TestKeySyncLock(t *testing.T) {
+	// Setup
+	tmpDir, err := os.MkdirTemp("", "keysync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err = os.RemoveAll(tmpDir) // clean up
+		if err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	keySyncDir := filepath.Join(tmpDir, "keys")
+
+	var (
+		fakeClient = fake.NewClientset()
+		namespace  = "default"
+	)
+
+	// Only return secrets of the type listed, the fake client does not honor
+	// field selectors
+	fakeClient.PrependReactor("list", "secrets", func(action coretesting.Action) (handled bool, ret runtime.Object, err error) {
+		la := action.(coretesting.ListAction)
+		secList, err := fakeClient.Tracker().List(
+			corev1.SchemeGroupVersion.WithResource("secrets"),
+			corev1.SchemeGroupVersion.WithKind("Secret"),
+			namespace)
+		if err != nil {
+			return true, nil, err
+		}
+		filtered := &corev1.SecretList{}
+		for _, s := range secList.(*corev1.SecretList).Items {
+			if la.GetListRestrictions().Fields.String() == keyTypeFieldSelectorPrefix+string(s.Type) {
+				filtered.Items = append(filtered.Items, s)
+			}
+		}
+		return true, filtered, nil
+	})
+
+	for _, secret := range []*corev1.Secret{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "my-secret"},
+			Data:       map[string][]byte{"mykey": []byte("this is a key")},
+			Type:       "key",
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "other-secret"},
+			Data:       map[string][]byte{"otherkey": []byte("this is another key")},
+			Type:       "other-key",
+		},
+	} {
+		if _, err := fakeClient.CoreV1().Secrets(namespace).Create(context.Background(), secret, metav1.CreateOptions{}); err != nil {
+			t.Fatalf("Unable to create secret: %v", err)
+		}
+	}
+
+	ksc := KeySyncServerConfig{
+		K8sClient:           fakeClient,
+		Interval:            50 * time.Millisecond,
+		KeySyncDir:          keySyncDir,
+		Namespace:           namespace,
+		KeyFilePermissions:  os.FileMode(0600),
+		RequiredSecretTypes: []string{"other-key"},
+		LockKeySyncDirs:     true,
+		LockTimeout:         5 * time.Second,
+	}
+	myKey := filepath.Join(keySyncDir, "c9fc5d06292274fd98bcb57882657bf71de1eda4df902c519d915fc585b10190-default-my-secret-mykey")
+	otherKey := filepath.Join(keySyncDir, "fcd64721df92fac855713a003bb8e965cb0269c16c8de6baee2f3b5b3c1d56ac-default-other-secret-otherkey")
+
+	waitFor := func(cond func() bool, msg string) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for !cond() {
+			if time.Now().After(deadline) {
+				t.Fatalf("Timed out waiting for %s", msg)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	// The first instance syncs the keys of both types
+	kss1 := NewKeySyncServer(ksc)
+	kss1.AddSecretKeyHandler("other-key", sechandlers.RegularKeyHandler)
+	done1 := make(chan error)
+	go func() { done1 <- kss1.Start() }()
+	waitFor(func() bool { return fileExists(myKey) && fileExists(otherKey) }, "the first instance to sync")
+
+	// The lock file is next to the key directory, which only holds the
+	// key files, as the container runtime takes every file for a key
+	files, err := os.ReadDir(keySyncDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("Expected only the key files in the key directory, have %v", files)
+	}
+	holder, err := os.ReadFile(keySyncDir + LockFileSuffix)
+	if err != nil || !strings.Contains(string(holder), fmt.Sprintf("(pid %d)", os.Getpid())) {
+		t.Fatalf("Expected the lock file next to the key directory to name the holder, got %q, %v", holder, err)
+	}
+
+	// A lock file in the key directory is refused
+	inside := ksc
+	inside.LockFile = filepath.Join(keySyncDir, "keys.lock")
+	if err := NewKeySyncServer(inside).SyncOnce(context.Background()); err == nil || !strings.Contains(err.Error(), "must be outside") {
+		t.Fatalf("Expected the lock file in the key directory to be refused, got %v", err)
+	}
+
+	// Another instance fails clearly while the directory is locked
+	kss2 := NewKeySyncServer(ksc)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	err = kss2.SyncOnce(ctx)
+	cancel()
+	if err == nil || !strings.Contains(err.Error(), "is locked by") {
+		t.Fatalf("Expected the directory to be locked, got %v", err)
+	}
+
+	// The next instance takes over once the first one is stopped, without
+	// removing the keys of the handlers it is not configured with yet
+	synced2 := make(chan int, 100)
+	kss2.syncReporter = func(r *SyncResult) { synced2 <- len(r.Keys) }
+	done2 := make(chan error)
+	go func() { done2 <- kss2.Start() }()
+	time.Sleep(100 * time.Millisecond)
+	kss1.Stop()
+	if err := <-done1; err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if !fileExists(otherKey) {
+		t.Fatal("Expected the key of the first instance to be kept during the handover")
+	}
+
+	kss2.AddSecretKeyHandler("other-key", sechandlers.RegularKeyHandler)
+	waitFor(func() bool { return len(synced2) > 0 && <-synced2 == 2 }, "the next instance to sync both keys")
+	kss2.Stop()
+	if err := <-done2; err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	if !fileExists(myKey) || !fileExists(otherKey) {
+		t.Fatal("Expected the keys to be kept after the handover")
+	}
+}
+
+// TestKeySyncSELinuxLabel tests that the key files and their directories are
+// labeled, and that relabeled key files are restored
+func TestKeySyncSELinuxLabel(t *testing.T) {
+	// Setup
+	tmpDir, err := os.MkdirTemp("", "keysync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err = os.RemoveAll(tmpDir) // clean up
+		if err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	for _, label := range []string{"container_file_t", "system_u:object_r:container_file_t", "system_u::container_file_t:s0"} {
+		if err := ValidateSELinuxLabel(label); err == nil {
+			t.Fatalf("Label %v should be invalid", label)
+		}
+	}
+	label := "system_u:object_r:container_file_t:s0:c1,c2"
+	if err := ValidateSELinuxLabel(label); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := setSELinuxLabel(tmpDir, label); err != nil {
+		t.Skipf("SELinux labels not supported: %v", err)
+	}
+
+	var (
+		fakeClient = fake.NewClientset()
+		namespace  = "default"
+	)
+
+	layout, err := NewLayout(HierarchicalLayout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDir := filepath.Join(tmpDir, "keys")
+	kss := NewKeySyncServer(KeySyncServerConfig{
+		K8sClient:          fakeClient,
+		Interval:           time.Hour,
+		KeySyncDir:         keyDir,
+		Namespace:          namespace,
+		KeyFilePermissions: os.FileMode(0600),
+		Layout:             layout,
+		SELinuxLabel:       label,
+	})
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "my-secret",
+		},
+		Data: map[string][]byte{
+			"mykey": []byte("this is a key"),
+		},
+		Type: "key",
+	}
+	if _, err := fakeClient.CoreV1().Secrets(namespace).Create(context.Background(), secret, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("Unable to create secret: %v", err)
+	}
+
+	if err := kss.SyncOnce(context.Background()); err != nil {
+		t.Fatalf("SyncOnce failed: %v", err)
+	}
+	path := filepath.Join(keyDir, "default", "my-secret", "mykey")
+	for _, p := range []string{keyDir, filepath.Join(keyDir, "default"), filepath.Dir(path), path} {
+		if l, err := getSELinuxLabel(p); err != nil || l != label {
+			t.Fatalf("Expected %v to be labeled %v, got %v, %v", p, label, l, err)
+		}
+	}
+
+	before := testutil.ToFloat64(keyFileDrifts.WithLabelValues(DriftLabel))
+	if err := setSELinuxLabel(path, "system_u:object_r:var_lib_t:s0"); err != nil {
+		t.Fatal(err)
+	}
+	if err := kss.SyncOnce(context.Background()); err != nil {
+		t.Fatalf("SyncOnce failed: %v", err)
+	}
+	if l, err := getSELinuxLabel(path); err != nil || l != label {
+		t.Fatalf("Expected the label to be restored, got %v, %v", l, err)
+	}
+	if after := testutil.ToFloat64(keyFileDrifts.WithLabelValues(DriftLabel)); after != before+1 {
+		t.Fatalf("Expected the label drift to be counted, got %v", after-before)
+	}
+}
+
+// TestKeySyncKeyFilenames tests that key filenames chosen by the handlers from
+// the contents of the secrets cannot place key files outside of the key sync
+// directory
+func TestKeySyncKeyFilenames(t *testing.T) {
+	// Setup
+	tmpDir, err := os.MkdirTemp("", "keysync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err = os.RemoveAll(tmpDir) // clean up
+		if err != nil {
+			t.Fatal(err)
+		}
+	}()
+	keySyncDir := filepath.Join(tmpDir, "keys")
+	if err := os.Mkdir(keySyncDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		fakeClient = fake.NewClientset()
+		namespace  = "default"
+	)
+
+	// Only return secrets of the type listed, the fake client does not honor
+	// field selectors
+	fakeClient.PrependReactor("list", "secrets", func(action coretesting.Action) (handled bool, ret runtime.Object, err error) {
+		la := action.(coretesting.ListAction)
+		secList, err := fakeClient.Tracker().List(
+			corev1.SchemeGroupVersion.WithResource("secrets"),
+			corev1.SchemeGroupVersion.WithKind("Secret"),
+			namespace)
+		if err != nil {
+			return true, nil, err
+		}
+		filtered := &corev1.SecretList{}
+		for _, s := range secList.(*corev1.SecretList).Items {
+			if la.GetListRestrictions().Fields.String() == keyTypeFieldSelectorPrefix+string(s.Type) {
+				filtered.Items = append(filtered.Items, s)
+			}
+		}
+		return true, filtered, nil
+	})
+
+	// The handler names the key file after a field of the secret, like the
+	// handlers of wrapped keys
+	filenameSecretHandler := func(data map[string][]byte) (map[string][]byte, error) {
+		return map[string][]byte{string(data["filename"]): data["key"]}, nil
+	}
+
+	ksc := KeySyncServerConfig{
+		K8sClient:          fakeClient,
+		Interval:           time.Second,
+		KeySyncDir:         keySyncDir,
+		Namespace:          namespace,
+		KeyFilePermissions: os.FileMode(0600),
+	}
+	kss := NewKeySyncServer(ksc)
+	kss.AddSecretKeyHandler("named-key", filenameSecretHandler)
+
+	for name, filename := range map[string]string{
+		"traversal": "../../escaped",
+		"dot-dot":   "..",
+		"nested":    "dir/escaped",
+		"good":      "mykey",
+	} {
+		_, err = fakeClient.CoreV1().Secrets(namespace).Create(context.Background(), &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Data: map[string][]byte{
+				"filename": []byte(filename),
+				"key":      []byte("this is a key"),
+			},
+			Type: "named-key",
+		}, metav1.CreateOptions{})
+		if err != nil {
+			t.Fatalf("Unable to create secret: %v", err)
+		}
+	}
+
+	// Shamir groups name the reconstructed key file too
+	shares, err := shamir.Split([]byte("this is a key"), 2, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256([]byte("this is a key"))
+	for i, share := range shares {
+		_, err = fakeClient.CoreV1().Secrets(namespace).Create(context.Background(), &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("my-share-%d", i)},
+			Data: map[string][]byte{
+				ShamirGroupField:     []byte("../../escaped-group"),
+				ShamirThresholdField: []byte("2"),
+				ShamirDigestField:    []byte(hex.EncodeToString(sum[:])),
+				ShamirShareField:     share,
+			},
+			Type: ShamirSecretType,
+		}, metav1.CreateOptions{})
+		if err != nil {
+			t.Fatalf("Unable to create secret: %v", err)
+		}
+	}
+
+	err = kss.SyncOnce(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "invalid key filename") {
+		t.Fatalf("SyncOnce should report the invalid key filenames, got %v", err)
+	}
+
+	// Only the key file of the valid filename and the escaped key file of
+	// the Shamir group are synced, both in the key sync directory
+	files, err := os.ReadDir(keySyncDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, f := range files {
+		names = append(names, f.Name())
+	}
+	sort.Strings(names)
+	if len(names) != 2 || !strings.HasSuffix(names[0], "-default-%2E.%2F..%2Fescaped-group-key") ||
+		!strings.HasSuffix(names[1], "-default-good-mykey") {
+		t.Fatalf("Should have synced the valid key files, have %v", names)
+	}
+	err = filepath.WalkDir(tmpDir, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && strings.Contains(d.Name(), "escaped") && filepath.Dir(path) != keySyncDir {
+			return errors.Errorf("key file %s outside of the key sync directory", path)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,284 @@
+// Copyright 2026 k8s-enc-image-operator authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keysync
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"strconv"
+
+	"github.com/lumjjb/k8s-enc-image-operator/apis/v1alpha1"
+	"github.com/lumjjb/k8s-enc-image-operator/keysync/sechandlers"
+	"github.com/lumjjb/k8s-enc-image-operator/secsign"
+	"github.com/lumjjb/k8s-enc-image-operator/shamir"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ShamirSecretType is the type of the secrets holding a Shamir share of a key,
+// the key is only reconstructed once the threshold of shares of its group is
+// available
+const ShamirSecretType = "shamir-share"
+
+// Secret data fields of Shamir shares
+const (
+	// ShamirGroupField is the name of the group of the shares of a key, the
+	// reconstructed key file is named after it
+	ShamirGroupField = "group"
+
+	// ShamirThresholdField is the number of shares required to reconstruct
+	// the key, in decimal, the same for all shares of a group
+	ShamirThresholdField = "threshold"
+
+	// ShamirShareField is the share, if the share is not wrapped
+	ShamirShareField = "share"
+
+	// ShamirShareTypeField is the secret type of the handler unwrapping the
+	// share if it is wrapped, i.e. "kp-key". The other data fields of the
+	// secret are passed to the handler, which must return a single file.
+	ShamirShareTypeField = "shareType"
+
+	// ShamirFilenameField is the name of the reconstructed key file,
+	// ShamirDefaultFilename if not set. It must be a valid secret data key,
+	// and the same for all shares of a group.
+	ShamirFilenameField = "keyFilename"
+
+	// ShamirDigestField is the hex SHA-256 of the key, the same for all
+	// shares of a group. The reconstructed key is only written if it
+	// matches, so that shares of different splits are not combined into a
+	// wrong key.
+	ShamirDigestField = "keyDigest"
+
+	// ShamirDefaultFilename is the name of the reconstructed key file if the
+	// shares do not specify one
+	ShamirDefaultFilename = "key"
+)
+
+// shamirFields are the data fields of Shamir shares not passed to the handler
+// unwrapping the share
+var shamirFields = map[string]bool{
+	ShamirGroupField:     true,
+	ShamirThresholdField: true,
+	ShamirShareTypeField: true,
+	ShamirFilenameField:  true,
+	ShamirDigestField:    true,
+}
+
+// shamirShare is a share processed from a Shamir share secret
+type shamirShare struct {
+	threshold int
+	filename  string
+	digest    string
+	share     []byte
+	secret    v1alpha1.SecretReference
+	labels    map[string]string
+}
+
+// shamirKeyFiles processes the Shamir share secrets into the key files of the
+// groups with at least the threshold of shares. Errors are logged and recorded
+// in the result, and a group below the threshold is recorded as an error so
+// that its key is removed.
+func (ks *KeySyncServer) shamirKeyFiles(secList *corev1.SecretList, result *SyncResult) []keyFile {
+	groups := map[v1alpha1.SecretReference][]shamirShare{}
+	for _, s := range secList.Items {
+		// Secrets of other types would be recorded as invalid shares if
+		// the field selector is not honored
+		if s.Type != ShamirSecretType {
+			continue
+		}
+
+		namespace := s.GetNamespace()
+		if namespace == "" {
+			namespace = metav1.NamespaceDefault
+		}
+
+		secRef := secretReference(&s, namespace)
+		// Shares outside of their validity window do not count towards the
+		// threshold
+		if !ks.verifySecret(&s, secRef, result) || !ks.secretInValidityWindow(&s, secRef, result) {
+			continue
+		}
+
+		group, share, err := ks.processShamirShare(secsign.SignedData(&s))
+		if err != nil {
+			logrus.Errorf("Unable to process Shamir share %s: %v", s.GetName(), err)
+			result.addError(secRef, "unable to process Shamir share: %v", err)
+			continue
+		}
+		share.secret = secRef
+		share.labels = s.GetLabels()
+
+		groupRef := v1alpha1.SecretReference{Namespace: namespace, Name: group, Type: ShamirSecretType}
+		groups[groupRef] = append(groups[groupRef], share)
+	}
+
+	keyFiles := []keyFile{}
+	for groupRef, shares := range groups {
+		data, filename, err := combineShamirShares(shares)
+		if err != nil {
+			logrus.Errorf("Unable to reconstruct key of Shamir group %s: %v", groupRef.Name, err)
+			result.addError(groupRef, "unable to reconstruct key: %v", err)
+			continue
+		}
+		kf, err := newKeyFile(filename, data, groupRef, shamirLabels(shares))
+		if err != nil {
+			logrus.Errorf("Unable to reconstruct key of Shamir group %s: %v", groupRef.Name, err)
+			result.addError(groupRef, "unable to reconstruct key: %v", err)
+			continue
+		}
+		keyFiles = append(keyFiles, kf)
+	}
+	return keyFiles
+}
+
+// processShamirShare returns the group and the share of the secret data,
+// unwrapping the share with the handler of its share type if set
+func (ks *KeySyncServer) processShamirShare(data map[string][]byte) (string, shamirShare, error) {
+	share := shamirShare{filename: ShamirDefaultFilename}
+
+	group := string(data[ShamirGroupField])
+	if group == "" {
+		return "", share, errors.New("group not in secret")
+	}
+
+	threshold, err := strconv.Atoi(string(data[ShamirThresholdField]))
+	if err != nil {
+		return "", share, errors.Wrap(err, "invalid threshold")
+	}
+	if threshold < 2 {
+		return "", share, errors.Errorf("invalid threshold %d, must be at least 2", threshold)
+	}
+	share.threshold = threshold
+
+	if filename, ok := data[ShamirFilenameField]; ok {
+		if err := sechandlers.ValidateFilename(string(filename)); err != nil {
+			return "", share, err
+		}
+		share.filename = string(filename)
+	}
+
+	digest, err := hex.DecodeString(string(data[ShamirDigestField]))
+	if err != nil || len(digest) != sha256.Size {
+		return "", share, errors.New("invalid or missing key digest, must be the hex SHA-256 of the key")
+	}
+	share.digest = hex.EncodeToString(digest)
+
+	shareType, ok := data[ShamirShareTypeField]
+	if !ok {
+		if share.share, ok = data[ShamirShareField]; !ok {
+			return "", share, errors.New("share not in secret")
+		}
+		return group, share, nil
+	}
+
+	skh, ok := ks.keyHandlers[string(shareType)]
+	if !ok || string(shareType) == ShamirSecretType {
+		return "", share, errors.Errorf("no handler for share type %q", shareType)
+	}
+
+	wrapped := map[string][]byte{}
+	for k, v := range data {
+		if !shamirFields[k] {
+			wrapped[k] = v
+		}
+	}
+	files, err := skh(wrapped)
+	if err != nil {
+		return "", share, errors.Wrapf(err, "unable to unwrap share with handler %q", shareType)
+	}
+	if len(files) != 1 {
+		return "", share, errors.Errorf("handler %q returned %d files, expected 1", shareType, len(files))
+	}
+	for _, v := range files {
+		share.share = v
+	}
+	return group, share, nil
+}
+
+// combineShamirShares reconstructs the key of the shares of a group and returns
+// it with its filename, if at least the threshold of distinct shares is
+// available and the key matches the digest of the shares
+func combineShamirShares(shares []shamirShare) ([]byte, string, error) {
+	// Sort for deterministic error messages
+	sort.Slice(shares, func(i, j int) bool {
+		return shares[i].secret.Name < shares[j].secret.Name
+	})
+
+	// A single share holder must not be able to choose the threshold, the
+	// filename or the digest of the key
+	threshold, filename, digest := shares[0].threshold, shares[0].filename, shares[0].digest
+	for _, s := range shares[1:] {
+		if s.threshold != threshold {
+			return nil, "", errors.Errorf("shares %s and %s disagree on threshold", shares[0].secret.Name, s.secret.Name)
+		}
+		if s.filename != filename {
+			return nil, "", errors.Errorf("shares %s and %s disagree on key filename", shares[0].secret.Name, s.secret.Name)
+		}
+		if s.digest != digest {
+			return nil, "", errors.Errorf("shares %s and %s disagree on key digest", shares[0].secret.Name, s.secret.Name)
+		}
+	}
+
+	// Copies of a share, i.e. under another secret name, count once
+	parts := make([][]byte, 0, len(shares))
+	for _, s := range shares {
+		duplicate := false
+		for _, p := range parts {
+			if bytes.Equal(p, s.share) {
+				duplicate = true
+				break
+			}
+		}
+		if !duplicate {
+			parts = append(parts, s.share)
+		}
+	}
+
+	if len(parts) < threshold {
+		return nil, "", errors.Errorf("only %d of %d required shares available", len(parts), threshold)
+	}
+
+	data, err := shamir.Combine(parts)
+	if err != nil {
+		return nil, "", err
+	}
+	if sum := sha256.Sum256(data); hex.EncodeToString(sum[:]) != digest {
+		clear(data)
+		return nil, "", errors.New("reconstructed key does not match the key digest, the shares are corrupted or of different splits")
+	}
+	return data, filename, nil
+}
+
+// shamirLabels returns the labels common to all shares of a group, the labels
+// of the reconstructed key for the secret policy
+func shamirLabels(shares []shamirShare) map[string]string {
+	labels := map[string]string{}
+	for k, v := range shares[0].labels {
+		labels[k] = v
+	}
+	for _, s := range shares[1:] {
+		for k, v := range labels {
+			if s.labels[k] != v {
+				delete(labels, k)
+			}
+		}
+	}
+	return labels
+}
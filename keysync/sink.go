@@ -0,0 +1,326 @@
+// Copyright 2026 k8s-enc-image-operator authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keysync
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"sigs.k8s.io/yaml"
+)
+
+// SinkConfig contains the parameters of an additional directory the keys are
+// synced to, i.e. the keys directory of another container runtime
+type SinkConfig struct {
+	// Name identifies the sink in the sync results and the logs
+	Name string
+
+	// KeySyncDir specifies the directory where keys are synced to
+	KeySyncDir string
+
+	// KeyFilePermissions specifies the permissions to set on the created files
+	KeyFilePermissions os.FileMode
+
+	// KeyFileOwnerUID specifies the owner UID to set on the created files
+	// if nil, owner UID won't be changed
+	KeyFileOwnerUID *int
+
+	// KeyFileOwnerGID specifies the owner GID to set on the created files
+	// if nil, owner GID won't be changed
+	KeyFileOwnerGID *int
+
+	// Layout maps the key files to their paths in the directory, if nil the
+	// FlatLayout is used
+	Layout *Layout
+
+	// SecretTypes are the types of the secrets whose keys are synced to the
+	// sink, if empty the keys of all secrets are synced
+	SecretTypes []string
+
+	// MetadataFile is the file to record the origin of each key file of the
+	// sink in after every sync, outside of the directory
+	MetadataFile string
+
+	// SELinuxLabel is the SELinux label to set on the key files and their
+	// directories, if empty the labels are not changed
+	SELinuxLabel string
+
+	// LockFile is the lock file of the directory, outside of the directory,
+	// if empty KeySyncDir with LockFileSuffix
+	LockFile string
+}
+
+// SinksFile is the file format of the additional sinks, i.e.
+//
+//	sinks:
+//	- name: containerd
+//	  dir: /etc/containerd/ocicrypt/keys
+//	  keyFilePermissions: "0600"
+//	  layout: hierarchical
+//	  secretTypes: ["key", "kp-key"]
+//	  seLinuxLabel: system_u:object_r:container_file_t:s0
+//	  lockFile: /run/enc-key-sync/containerd.lock
+type SinksFile struct {
+	// Sinks are the additional sinks
+	Sinks []SinkFileConfig `json:"sinks"`
+}
+
+// SinkFileConfig is the configuration of a sink in the SinksFile
+type SinkFileConfig struct {
+	// Name identifies the sink
+	Name string `json:"name"`
+
+	// Dir is the directory where keys are synced to
+	Dir string `json:"dir"`
+
+	// KeyFilePermissions are the octal permissions of the key files,
+	// 0600 by default
+	KeyFilePermissions string `json:"keyFilePermissions,omitempty"`
+
+	// KeyFileOwnerUID is the owner UID of the key files
+	KeyFileOwnerUID *int `json:"keyFileOwnerUID,omitempty"`
+
+	// KeyFileOwnerGID is the owner GID of the key files
+	KeyFileOwnerGID *int `json:"keyFileOwnerGID,omitempty"`
+
+	// Layout is the layout of the directory, see NewLayout
+	Layout string `json:"layout,omitempty"`
+
+	// SecretTypes are the types of the secrets whose keys are synced to the
+	// sink, all if empty
+	SecretTypes []string `json:"secretTypes,omitempty"`
+
+	// MetadataFile is the file to record the origin of each key file in
+	MetadataFile string `json:"metadataFile,omitempty"`
+
+	// SELinuxLabel is the SELinux label of the key files
+	SELinuxLabel string `json:"seLinuxLabel,omitempty"`
+
+	// LockFile is the lock file of the directory, the directory with
+	// LockFileSuffix if empty
+	LockFile string `json:"lockFile,omitempty"`
+}
+
+// LoadSinksFile loads the additional sinks from a YAML or JSON file
+func LoadSinksFile(filename string) ([]SinkConfig, error) {
+	data, err := os.ReadFile(filepath.Clean(filename))
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to read sinks file %v", filename)
+	}
+
+	var sf SinksFile
+	if err := yaml.UnmarshalStrict(data, &sf); err != nil {
+		return nil, errors.Wrapf(err, "unable to parse sinks file %v", filename)
+	}
+
+	sinks := []SinkConfig{}
+	names := map[string]bool{}
+	for _, s := range sf.Sinks {
+		if s.Name == "" || s.Dir == "" {
+			return nil, errors.New("sinks must have a name and a dir")
+		}
+		if names[s.Name] {
+			return nil, errors.Errorf("duplicate sink %v", s.Name)
+		}
+		names[s.Name] = true
+
+		permissions := os.FileMode(0600)
+		if s.KeyFilePermissions != "" {
+			if permissions, err = parseFileMode(s.KeyFilePermissions); err != nil {
+				return nil, errors.Wrapf(err, "invalid permissions of sink %v", s.Name)
+			}
+		}
+
+		layout, err := NewLayout(s.Layout)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid layout of sink %v", s.Name)
+		}
+
+		if s.SELinuxLabel != "" {
+			if err := ValidateSELinuxLabel(s.SELinuxLabel); err != nil {
+				return nil, errors.Wrapf(err, "invalid label of sink %v", s.Name)
+			}
+		}
+
+		sinks = append(sinks, SinkConfig{
+			Name:               s.Name,
+			KeySyncDir:         s.Dir,
+			KeyFilePermissions: permissions,
+			KeyFileOwnerUID:    s.KeyFileOwnerUID,
+			KeyFileOwnerGID:    s.KeyFileOwnerGID,
+			Layout:             layout,
+			SecretTypes:        s.SecretTypes,
+			MetadataFile:       s.MetadataFile,
+			SELinuxLabel:       s.SELinuxLabel,
+			LockFile:           s.LockFile,
+		})
+	}
+	return sinks, nil
+}
+
+// sink is a directory the keys are synced to, reconciled and cleaned up
+// independently of the other sinks
+type sink struct {
+	// name identifies the sink, empty for the key sync directory of the
+	// server
+	name string
+
+	// keySyncDir specifies the directory where keys are synced to
+	keySyncDir string
+
+	// keyFilePermissions specifies the permissions to set on the created files
+	keyFilePermissions os.FileMode
+
+	// keyFileOwnerUID specifies the owner UID to set on the created files
+	// if nil, owner UID won't be changed, therefore files will be created with process UID
+	keyFileOwnerUID *int
+
+	// keyFileOwnerGID specifies the owner GID to set on the created files
+	// if nil, owner GID won't be changed, therefore files will be created with process GID
+	keyFileOwnerGID *int
+
+	// layout maps the key files to their paths in the directory
+	layout *Layout
+
+	// secretTypes are the types of the secrets synced to the sink, all if
+	// empty
+	secretTypes []string
+
+	// metadataFile is the file to record the origin of each key file in
+	metadataFile string
+
+	// seLinuxLabel is the SELinux label of the key files and their
+	// directories, empty if not changed
+	seLinuxLabel string
+
+	// subdirectories are the subdirectories secrets may sync to, managed
+	// by the server
+	subdirectories []string
+
+	// secureWipe specifies whether to wipe the key files before they are
+	// removed or rewritten
+	secureWipe bool
+
+	// lockFile is the lock file of the directory, outside of the directory
+	lockFile string
+
+	// lock is the locked lock file of the directory, nil if not locked
+	lock *os.File
+
+	// watcher watches the directory, nil if not watched
+	watcher *fsnotify.Watcher
+
+	// syncedFiles are the hashes of the key files written by the previous
+	// syncs by their filenames, so that drifts on disk are told apart from
+	// new and updated key files
+	syncedFiles map[string]string
+}
+
+// newSink returns the sink of the configuration
+func newSink(sc SinkConfig, allowlist *DeliveryAllowlist) *sink {
+	s := &sink{
+		name:               sc.Name,
+		keySyncDir:         sc.KeySyncDir,
+		keyFilePermissions: sc.KeyFilePermissions,
+		keyFileOwnerUID:    sc.KeyFileOwnerUID,
+		keyFileOwnerGID:    sc.KeyFileOwnerGID,
+		layout:             sc.Layout,
+		secretTypes:        sc.SecretTypes,
+		metadataFile:       sc.MetadataFile,
+		seLinuxLabel:       sc.SELinuxLabel,
+		lockFile:           sc.LockFile,
+		syncedFiles:        map[string]string{},
+	}
+	if s.lockFile == "" {
+		s.lockFile = defaultLockFile(s.keySyncDir)
+	}
+	if s.layout == nil {
+		s.layout, _ = NewLayout(FlatLayout)
+	}
+	if allowlist != nil {
+		s.subdirectories = allowlist.Subdirectories
+	}
+	return s
+}
+
+// keyFiles returns the key files of the secret types of the sink, at their
+// paths in the layout of the sink. Errors are logged and recorded in the
+// result.
+func (s *sink) keyFiles(keyFiles []keyFile, result *SyncResult) []keyFile {
+	placed := []keyFile{}
+	for _, kf := range keyFiles {
+		if len(s.secretTypes) > 0 && !containsString(s.secretTypes, kf.secret.Type) {
+			continue
+		}
+
+		p, err := s.placeKeyFile(kf)
+		if err != nil {
+			logrus.Errorf("Unable to sync key %s of secret %s to %s: %v", kf.secretFilename, kf.secret.Name, s.keySyncDir, err)
+			result.addError(kf.secret, "unable to sync key %s to %s: %v", kf.secretFilename, s.keySyncDir, err)
+			continue
+		}
+		placed = append(placed, p)
+	}
+	return placed
+}
+
+// placeKeyFile returns the key file with its path in the layout of the sink,
+// or the path requested by the secret
+func (s *sink) placeKeyFile(kf keyFile) (keyFile, error) {
+	path, err := s.layout.path(kf.hash, kf.secret, kf.secretFilename)
+	if err != nil {
+		return kf, err
+	}
+	kf.filename = path
+
+	// Previous versions named the files by the MD5 of the contents
+	if s.layout.hashed {
+		kf.legacyFilename, err = s.layout.path(kf.legacyHash, kf.secret, kf.secretFilename)
+		if err != nil {
+			return kf, err
+		}
+	}
+
+	if s.layout.atomic {
+		kf.atomicDir = filepath.Dir(path)
+	}
+
+	if kf.customFilename != "" {
+		kf.filename = kf.customFilename
+		kf.legacyFilename = ""
+		kf.atomicDir = ""
+	}
+	if kf.subdir != "" {
+		kf.filename = kf.subdir + "/" + kf.filename
+		if kf.atomicDir != "" {
+			kf.atomicDir = kf.subdir + "/" + kf.atomicDir
+		}
+		if kf.legacyFilename != "" {
+			kf.legacyFilename = kf.subdir + "/" + kf.legacyFilename
+		}
+	}
+	return kf, nil
+}
+
+// logName returns the name of the file of the sink in the logs
+func (s *sink) logName(filename string) string {
+	if s.name == "" {
+		return filename
+	}
+	return s.name + ":" + filename
+}
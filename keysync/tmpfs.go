@@ -0,0 +1,105 @@
+// Copyright 2026 k8s-enc-image-operator authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keysync
+
+import (
+	"bufio"
+	"bytes"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// mountInfoFile lists the mounts of the mount namespace of the process with
+// their propagation
+const mountInfoFile = "/proc/self/mountinfo"
+
+// mount is a mount of the mountinfo
+type mount struct {
+	// mountPoint is the path the filesystem is mounted on
+	mountPoint string
+
+	// fsType is the type of the filesystem, i.e. overlay
+	fsType string
+
+	// shared is whether the mount is shared with its peers, i.e. a hostPath
+	// volume with Bidirectional mount propagation, so that mounts on it
+	// are visible outside of the container
+	shared bool
+}
+
+// findMount returns the mount the absolute path is on in the mountinfo
+func findMount(mountInfo []byte, path string) (mount, error) {
+	found := mount{}
+	scanner := bufio.NewScanner(bytes.NewReader(mountInfo))
+	for scanner.Scan() {
+		// ID parent major:minor root mountpoint options [optional...] - type source superoptions
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 7 {
+			continue
+		}
+		mountPoint := unescapeMountInfo(fields[4])
+		if rel, err := filepath.Rel(mountPoint, path); err != nil || !(rel == "." || filepath.IsLocal(rel)) {
+			continue
+		}
+
+		// The innermost mount, or the last of the mounts stacked on the same
+		// mount point, is the one the path is on
+		if len(mountPoint) < len(found.mountPoint) {
+			continue
+		}
+		found = mount{mountPoint: mountPoint}
+		for i, f := range fields[6:] {
+			if f == "-" {
+				if i+7 < len(fields) {
+					found.fsType = fields[i+7]
+				}
+				break
+			}
+			if strings.HasPrefix(f, "shared:") {
+				found.shared = true
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return mount{}, err
+	}
+	if found.mountPoint == "" {
+		return mount{}, errors.Errorf("mount of %v not found", path)
+	}
+	return found, nil
+}
+
+// unescapeMountInfo unescapes the octal escapes of the paths in the mountinfo,
+// i.e. \040 for a space
+func unescapeMountInfo(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+4 <= len(s) {
+			if c, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
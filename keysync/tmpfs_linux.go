@@ -0,0 +1,109 @@
+// Copyright 2026 k8s-enc-image-operator authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+
+package keysync
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"syscall"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// tmpfsMagic is the filesystem type of tmpfs mounts reported by statfs
+const tmpfsMagic = 0x01021994
+
+// tmpfsSizePattern matches the size option of tmpfs mounts, in bytes, k, m or
+// g, or a percentage of the memory
+var tmpfsSizePattern = regexp.MustCompile(`^[0-9]+[kmg%]?$`)
+
+// IsTmpfs returns whether the directory is on a tmpfs mount
+func IsTmpfs(dir string) (bool, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return false, err
+	}
+	return st.Type == tmpfsMagic, nil
+}
+
+// EnsureTmpfs ensures that the directory is on a tmpfs mount, so that the keys
+// never reach a persistent disk. If it is not, a tmpfs of the size, i.e. "16m",
+// is mounted on the directory, which requires CAP_SYS_ADMIN, and a directory
+// on a shared mount, i.e. a hostPath volume with Bidirectional mount
+// propagation, so that the container runtime sees the tmpfs; an error is
+// returned if it cannot be mounted.
+func EnsureTmpfs(dir, size string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	tmpfs, err := IsTmpfs(dir)
+	if err != nil {
+		return errors.Wrapf(err, "unable to check the filesystem of %v", dir)
+	}
+	if tmpfs {
+		logrus.Printf("Key directory %v is on a tmpfs", dir)
+		return nil
+	}
+
+	options := "mode=0700"
+	if size != "" {
+		if !tmpfsSizePattern.MatchString(size) {
+			return errors.Errorf("invalid tmpfs size %q", size)
+		}
+		options += ",size=" + size
+	}
+
+	// Without propagation the tmpfs would only be visible in the container,
+	// and the container runtime would not see the keys
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	if abs, err = filepath.EvalSymlinks(abs); err != nil {
+		return err
+	}
+	mountInfo, err := os.ReadFile(mountInfoFile)
+	if err != nil {
+		return errors.Wrap(err, "unable to read the mounts")
+	}
+	m, err := findMount(mountInfo, abs)
+	if err != nil {
+		return errors.Wrapf(err, "unable to check the mount propagation of %v", dir)
+	}
+	if !m.shared {
+		return errors.Errorf("key directory %v is not a tmpfs and not on a shared mount, mount it with Bidirectional mount propagation so that the tmpfs mounted on it is visible to the container runtime", dir)
+	}
+
+	if err := syscall.Mount("tmpfs", dir, "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV|syscall.MS_NOEXEC, options); err != nil {
+		return errors.Wrapf(err, "key directory %v is not a tmpfs and mounting one failed, refusing to write keys to disk", dir)
+	}
+	logrus.Printf("Mounted a tmpfs on key directory %v (%v)", dir, options)
+	return nil
+}
+
+// UnmountTmpfs unmounts the tmpfs of the directory, if it is one, i.e. on
+// uninstall after the keys are purged
+func UnmountTmpfs(dir string) error {
+	tmpfs, err := IsTmpfs(dir)
+	if err != nil || !tmpfs {
+		return err
+	}
+	return syscall.Unmount(dir, 0)
+}
@@ -0,0 +1,41 @@
+// Copyright 2026 k8s-enc-image-operator authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !linux
+
+package keysync
+
+import (
+	"github.com/pkg/errors"
+)
+
+// errTmpfsUnsupported is the error of the tmpfs functions outside of Linux
+var errTmpfsUnsupported = errors.New("tmpfs key directories are only supported on Linux")
+
+// IsTmpfs returns whether the directory is on a tmpfs mount
+func IsTmpfs(dir string) (bool, error) {
+	return false, errTmpfsUnsupported
+}
+
+// EnsureTmpfs ensures that the directory is on a tmpfs mount, which is only
+// supported on Linux
+func EnsureTmpfs(dir, size string) error {
+	return errTmpfsUnsupported
+}
+
+// UnmountTmpfs unmounts the tmpfs of the directory, which is only supported
+// on Linux
+func UnmountTmpfs(dir string) error {
+	return errTmpfsUnsupported
+}
@@ -0,0 +1,118 @@
+// Copyright 2026 k8s-enc-image-operator authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keysync
+
+import (
+	"time"
+
+	"github.com/lumjjb/k8s-enc-image-operator/apis/v1alpha1"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// NotBeforeAnnotation is the annotation of the RFC 3339 time from which
+	// the keys of the secret are synced
+	NotBeforeAnnotation = "oci.crypt/not-before"
+
+	// NotAfterAnnotation is the annotation of the RFC 3339 time after which
+	// the keys of the secret are removed
+	NotAfterAnnotation = "oci.crypt/not-after"
+)
+
+// keyExpirySeconds is the time until the keys of the secrets with a
+// NotAfterAnnotation expire, reset on every sync to drop removed secrets
+var keyExpirySeconds = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "enc_key_sync_key_expiry_seconds",
+	Help: "Seconds until the keys of the secret expire and are removed from the node.",
+}, []string{"namespace", "secret"})
+
+func init() {
+	prometheus.MustRegister(keyExpirySeconds)
+}
+
+// ValidityWindow returns the times of the NotBeforeAnnotation and the
+// NotAfterAnnotation of the secret, nil if not annotated
+func ValidityWindow(s *corev1.Secret) (*time.Time, *time.Time, error) {
+	parse := func(annotation string) (*time.Time, error) {
+		value, ok := s.GetAnnotations()[annotation]
+		if !ok {
+			return nil, nil
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid %s annotation", annotation)
+		}
+		return &t, nil
+	}
+
+	notBefore, err := parse(NotBeforeAnnotation)
+	if err != nil {
+		return nil, nil, err
+	}
+	notAfter, err := parse(NotAfterAnnotation)
+	if err != nil {
+		return nil, nil, err
+	}
+	if notBefore != nil && notAfter != nil && !notAfter.After(*notBefore) {
+		return nil, nil, errors.Errorf("%s is not after %s", NotAfterAnnotation, NotBeforeAnnotation)
+	}
+	return notBefore, notAfter, nil
+}
+
+// secretInValidityWindow returns whether the keys of the secret are inside
+// their validity window, invalid annotations are recorded in the result
+func (ks *KeySyncServer) secretInValidityWindow(s *corev1.Secret, secRef v1alpha1.SecretReference, result *SyncResult) bool {
+	notBefore, notAfter, err := ValidityWindow(s)
+	if err != nil {
+		logrus.Errorf("Unable to process secret %s: %v", s.GetName(), err)
+		result.addError(secRef, "invalid validity window: %v", err)
+		return false
+	}
+	return ks.inValidityWindow(secRef, notBefore, notAfter, result)
+}
+
+// inValidityWindow returns whether the current time is inside the validity
+// window of the keys of the secret. Approaching expiry is logged as a warning
+// and the time until expiry is exported as a metric, unless in a dry run.
+func (ks *KeySyncServer) inValidityWindow(secRef v1alpha1.SecretReference, notBefore, notAfter *time.Time, result *SyncResult) bool {
+	now := ks.clock.Now()
+
+	if notBefore != nil && now.Before(*notBefore) {
+		logrus.Debugf("Keys of secret %s/%s are not valid before %v", secRef.Namespace, secRef.Name, notBefore)
+		return false
+	}
+
+	if notAfter != nil {
+		remaining := notAfter.Sub(now)
+		if remaining <= 0 {
+			logrus.Debugf("Keys of secret %s/%s expired at %v", secRef.Namespace, secRef.Name, notAfter)
+			return false
+		}
+
+		if result.dryRun {
+			return true
+		}
+		keyExpirySeconds.WithLabelValues(secRef.Namespace, secRef.Name).Set(remaining.Seconds())
+		if remaining <= ks.expiryWarningPeriod {
+			logrus.Warnf("Keys of secret %s/%s expire in %v at %v", secRef.Namespace, secRef.Name,
+				remaining.Round(time.Second), notAfter)
+		}
+	}
+	return true
+}
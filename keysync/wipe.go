@@ -0,0 +1,77 @@
+// Copyright 2026 k8s-enc-image-operator authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keysync
+
+import (
+	"os"
+)
+
+// wipeBufferSize is the size of the buffer of zeros key files are overwritten
+// with
+const wipeBufferSize = 32 * 1024
+
+// wipeFile overwrites the contents of the regular file with zeros and syncs them
+// to the disk, so that the key cannot be recovered from the blocks freed when
+// the file is removed. Copy-on-write and log-structured filesystems may keep
+// copies of the previous contents regardless. Symlinks are not followed.
+func wipeFile(path string) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() || info.Size() == 0 {
+		return nil
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if os.IsPermission(err) {
+		// Read only key files are about to be removed anyway
+		if err := os.Chmod(path, 0600); err != nil {
+			return err
+		}
+		f, err = os.OpenFile(path, os.O_WRONLY, 0)
+	}
+	if err != nil {
+		return err
+	}
+
+	zeros := make([]byte, wipeBufferSize)
+	for remaining := info.Size(); remaining > 0; {
+		n := int64(len(zeros))
+		if remaining < n {
+			n = remaining
+		}
+		if _, err := f.Write(zeros[:n]); err != nil {
+			_ = f.Close()
+			return err
+		}
+		remaining -= n
+	}
+
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// zeroizeKeyFiles overwrites the contents of the key files in memory once they
+// are written, so that unwrapped keys do not linger in the memory of the
+// daemon
+func zeroizeKeyFiles(keyFiles []keyFile) {
+	for _, kf := range keyFiles {
+		clear(kf.data)
+	}
+}
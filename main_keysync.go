@@ -16,41 +16,144 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"math"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
+	"github.com/lumjjb/k8s-enc-image-operator/aeswrap"
+	"github.com/lumjjb/k8s-enc-image-operator/agekey"
+	"github.com/lumjjb/k8s-enc-image-operator/hub"
 	keyprotect "github.com/lumjjb/k8s-enc-image-operator/keyprotect"
 	"github.com/lumjjb/k8s-enc-image-operator/keysync"
+	"github.com/lumjjb/k8s-enc-image-operator/multiwrap"
+	"github.com/lumjjb/k8s-enc-image-operator/nodekey"
+	"github.com/lumjjb/k8s-enc-image-operator/nodestatus"
+	"github.com/lumjjb/k8s-enc-image-operator/pgpkey"
+	"github.com/lumjjb/k8s-enc-image-operator/policy"
+	"github.com/lumjjb/k8s-enc-image-operator/secsign"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/clientcmd"
 )
 
 const (
 	NamespaceEnv = "POD_NAMESPACE"
+	NodeNameEnv  = "NODE_NAME"
 )
 
 func main() {
 	inputFlags := struct {
-		kubeconfig                 string
-		interval                   uint
-		dir                        string
-		keyprotectConfigFile       string
-		keyprotectConfigKubeSecret string
-		keyFilePermissions         string
-		keyFileOwnership           string
+		kubeconfig                    string
+		interval                      uint
+		dir                           string
+		keyprotectConfigFile          string
+		keyprotectConfigKubeSecret    string
+		keyprotectConfigKubeSecretKey string
+		aesKEKDir                     string
+		aesKEKKubeSecret              string
+		ageIdentityFile               string
+		ageIdentityKubeSecret         string
+		ageIdentityKubeSecretKey      string
+		pgpKeyringFile                string
+		pgpPassphraseFile             string
+		pgpTrustedKeyringFile         string
+		pgpKeyringKubeSecret          string
+		multiConfigFile               string
+		multiConfigKubeSecret         string
+		multiConfigKubeSecretKey      string
+		signingPublicKeysFile         string
+		metricsAddr                   string
+		policyFile                    string
+		expiryWarningHours            uint
+		deliveryAllowlistFile         string
+		layout                        string
+		metadataFile                  string
+		watchKeySyncDir               bool
+		sinksFile                     string
+		tmpfs                         bool
+		tmpfsSize                     string
+		purge                         bool
+		daemonSetName                 string
+		secureWipe                    bool
+		lock                          bool
+		lockTimeout                   uint
+		lockFile                      string
+		seLinuxLabel                  string
+		keyFilePermissions            string
+		keyFileOwnership              string
+		publishNodeStatus             bool
+		nodeStatusHeartbeat           uint
+		once                          bool
+		onceTimeout                   uint
+		dryRun                        bool
+		output                        string
+		hubURL                        string
+		hubCAFile                     string
+		hubClientCertFile             string
+		hubClientKeyFile              string
+		hubTokenFile                  string
+		nodeKeyWrapping               bool
 	}{
-		kubeconfig:                 "",
-		interval:                   10,
-		dir:                        "/tmp/keys",
-		keyprotectConfigFile:       "",
-		keyprotectConfigKubeSecret: "",
-		keyFilePermissions:         "0600",
-		keyFileOwnership:           "",
+		kubeconfig:                    "",
+		interval:                      10,
+		dir:                           "/tmp/keys",
+		keyprotectConfigFile:          "",
+		keyprotectConfigKubeSecret:    "",
+		keyprotectConfigKubeSecretKey: "config.json",
+		aesKEKDir:                     "",
+		aesKEKKubeSecret:              "",
+		ageIdentityFile:               "",
+		ageIdentityKubeSecret:         "",
+		ageIdentityKubeSecretKey:      agekey.DefaultIdentityKubeSecretKey,
+		pgpKeyringFile:                "",
+		pgpPassphraseFile:             "",
+		pgpTrustedKeyringFile:         "",
+		pgpKeyringKubeSecret:          "",
+		multiConfigFile:               "",
+		multiConfigKubeSecret:         "",
+		multiConfigKubeSecretKey:      "config.json",
+		signingPublicKeysFile:         "",
+		metricsAddr:                   "",
+		policyFile:                    "",
+		expiryWarningHours:            72,
+		deliveryAllowlistFile:         "",
+		layout:                        keysync.FlatLayout,
+		metadataFile:                  "",
+		watchKeySyncDir:               false,
+		sinksFile:                     "",
+		tmpfs:                         false,
+		tmpfsSize:                     "16m",
+		purge:                         false,
+		daemonSetName:                 "",
+		secureWipe:                    false,
+		lock:                          false,
+		lockTimeout:                   300,
+		lockFile:                      "",
+		seLinuxLabel:                  "",
+		keyFilePermissions:            "0600",
+		keyFileOwnership:              "",
+		publishNodeStatus:             false,
+		nodeStatusHeartbeat:           300,
+		once:                          false,
+		onceTimeout:                   60,
+		dryRun:                        false,
+		output:                        "text",
+		hubURL:                        "",
+		hubCAFile:                     "/etc/hub/certs/ca.crt",
+		hubClientCertFile:             "/etc/hub/certs/tls.crt",
+		hubClientKeyFile:              "/etc/hub/certs/tls.key",
+		hubTokenFile:                  "/var/run/secrets/tokens/enc-key-sync-hub",
+		nodeKeyWrapping:               false,
 	}
 
 	flag.StringVar(&inputFlags.kubeconfig, "kubeconfig", inputFlags.kubeconfig,
@@ -63,12 +166,118 @@ func main() {
 		"(optional) config file for keyprotect enablement")
 	flag.StringVar(&inputFlags.keyprotectConfigKubeSecret, "keyprotectConfigKubeSecret", inputFlags.keyprotectConfigKubeSecret,
 		"(optional) kube secret name for config file for keyprotect enablement")
+	flag.StringVar(&inputFlags.keyprotectConfigKubeSecretKey, "keyprotectConfigKubeSecretKey", inputFlags.keyprotectConfigKubeSecretKey,
+		"(optional) key of the config file in the kube secret for keyprotect enablement (defaults to config.json)")
+	flag.StringVar(&inputFlags.aesKEKDir, "aesKEKDir", inputFlags.aesKEKDir,
+		"(optional) directory of AES KEK files named by key ID for aes-wrapped-key enablement")
+	flag.StringVar(&inputFlags.aesKEKKubeSecret, "aesKEKKubeSecret", inputFlags.aesKEKKubeSecret,
+		"(optional) kube secret name of AES KEKs named by key ID for aes-wrapped-key enablement")
+	flag.StringVar(&inputFlags.ageIdentityFile, "ageIdentityFile", inputFlags.ageIdentityFile,
+		"(optional) age identities file for age-key and sops-key enablement")
+	flag.StringVar(&inputFlags.ageIdentityKubeSecret, "ageIdentityKubeSecret", inputFlags.ageIdentityKubeSecret,
+		"(optional) kube secret name of the age identities for age-key and sops-key enablement")
+	flag.StringVar(&inputFlags.ageIdentityKubeSecretKey, "ageIdentityKubeSecretKey", inputFlags.ageIdentityKubeSecretKey,
+		"(optional) key of the age identities in the kube secret (defaults to keys.txt)")
+	flag.StringVar(&inputFlags.pgpKeyringFile, "pgpKeyringFile", inputFlags.pgpKeyringFile,
+		"(optional) OpenPGP private keyring file for pgp-key enablement")
+	flag.StringVar(&inputFlags.pgpPassphraseFile, "pgpPassphraseFile", inputFlags.pgpPassphraseFile,
+		"(optional) file with the passphrase of the OpenPGP private keyring")
+	flag.StringVar(&inputFlags.pgpTrustedKeyringFile, "pgpTrustedKeyringFile", inputFlags.pgpTrustedKeyringFile,
+		"(optional) OpenPGP public keyring file of the senders trusted to sign pgp-key messages")
+	flag.StringVar(&inputFlags.pgpKeyringKubeSecret, "pgpKeyringKubeSecret", inputFlags.pgpKeyringKubeSecret,
+		"(optional) kube secret name of the OpenPGP keyrings (secring.asc, passphrase, pubring.asc) for pgp-key enablement")
+	flag.StringVar(&inputFlags.multiConfigFile, "multiConfigFile", inputFlags.multiConfigFile,
+		"(optional) config file of the ordered backends for multi-wrapped-key enablement")
+	flag.StringVar(&inputFlags.multiConfigKubeSecret, "multiConfigKubeSecret", inputFlags.multiConfigKubeSecret,
+		"(optional) kube secret name for config file of the ordered backends for multi-wrapped-key enablement")
+	flag.StringVar(&inputFlags.multiConfigKubeSecretKey, "multiConfigKubeSecretKey", inputFlags.multiConfigKubeSecretKey,
+		"(optional) key of the config file in the kube secret for multi-wrapped-key enablement (defaults to config.json)")
+	flag.StringVar(&inputFlags.signingPublicKeysFile, "signingPublicKeysFile", inputFlags.signingPublicKeysFile,
+		"(optional) PEM file of the trusted public keys, only secrets signed by one of them are synced")
+	flag.StringVar(&inputFlags.metricsAddr, "metricsAddr", inputFlags.metricsAddr,
+		"(optional) address to serve the prometheus metrics on, i.e. :9090")
+	flag.StringVar(&inputFlags.policyFile, "policyFile", inputFlags.policyFile,
+		"(optional) YAML file of the CEL policy rules deciding which secrets may have their keys synced")
+	flag.UintVar(&inputFlags.expiryWarningHours, "expiryWarningHours", inputFlags.expiryWarningHours,
+		"(optional) period before the "+keysync.NotAfterAnnotation+" time of a secret in which its expiry is logged as a warning (in hours)")
+	flag.StringVar(&inputFlags.deliveryAllowlistFile, "deliveryAllowlistFile", inputFlags.deliveryAllowlistFile,
+		"(optional) YAML file of the file modes, owners, filenames and subdirectories secrets may request with their annotations")
+	flag.StringVar(&inputFlags.layout, "layout", inputFlags.layout,
+		"(optional) layout of the key files in the sync directory, flat, hierarchical, atomic or a template like {{.Namespace}}/{{.Name}}/{{.Filename}}")
+	flag.StringVar(&inputFlags.metadataFile, "metadataFile", inputFlags.metadataFile,
+		"(optional) file outside of the sync directory to record the origin of each key file in, required to migrate away from a nested layout")
+	flag.BoolVar(&inputFlags.watchKeySyncDir, "watchKeySyncDir", inputFlags.watchKeySyncDir,
+		"(optional) watch the sync directory to restore key files deleted or modified on the node immediately instead of on the next interval")
+	flag.StringVar(&inputFlags.sinksFile, "sinksFile", inputFlags.sinksFile,
+		"(optional) YAML file of additional directories to sync the keys to, each with its own permissions, ownership, layout and secret types")
+	flag.BoolVar(&inputFlags.tmpfs, "tmpfs", inputFlags.tmpfs,
+		"(optional) require the sync directories to be tmpfs mounts, mounting one when privileged, and refuse to start otherwise")
+	flag.StringVar(&inputFlags.tmpfsSize, "tmpfsSize", inputFlags.tmpfsSize,
+		"(optional) size limit of the tmpfs mounted on the sync directories, in bytes, k, m, g or % of the memory (defaults to 16m)")
+	flag.BoolVar(&inputFlags.purge, "purge", inputFlags.purge,
+		"(optional) remove all key files managed by the daemon from the sync directories and exit, waiting for the locks with -lock")
+	flag.StringVar(&inputFlags.daemonSetName, "daemonSetName", inputFlags.daemonSetName,
+		"(optional) name of the DaemonSet of the daemon, so that the daemon removes the keys when terminated while the DaemonSet is uninstalled, and -purge only when it is")
+	flag.BoolVar(&inputFlags.secureWipe, "secureWipe", inputFlags.secureWipe,
+		"(optional) overwrite revoked key files with zeros and sync them to the disk before removing them, and zeroize the keys in memory once written")
+	flag.BoolVar(&inputFlags.lock, "lock", inputFlags.lock,
+		"(optional) lock the sync directories so that a single instance writes to them, and hand them over to the next instance on SIGTERM")
+	flag.StringVar(&inputFlags.lockFile, "lockFile", inputFlags.lockFile,
+		"(optional) lock file of the sync directory, outside of it, on a path shared by the instances (defaults to the sync directory with .lock appended)")
+	flag.UintVar(&inputFlags.lockTimeout, "lockTimeout", inputFlags.lockTimeout,
+		"(optional) time to wait for the lock held by another instance, and for the handler configs after taking over (in seconds)")
+	flag.StringVar(&inputFlags.seLinuxLabel, "seLinuxLabel", inputFlags.seLinuxLabel,
+		"(optional) SELinux label to set on the sync directory and the key files, i.e. system_u:object_r:container_file_t:s0")
 	flag.StringVar(&inputFlags.keyFilePermissions, "keyFilePermissions", inputFlags.keyFilePermissions,
 		"(optional) permissions for the created key files (defaults to 0600)")
 	flag.StringVar(&inputFlags.keyFileOwnership, "keyFileOwnership", inputFlags.keyFileOwnership,
 		"(optional) ownership for the created key files (in UID:GID format; if not provided key files will be created with UID:GID of the process)")
+	flag.BoolVar(&inputFlags.publishNodeStatus, "publishNodeStatus", inputFlags.publishNodeStatus,
+		"(optional) publish the keys synced to the node as its EncKeyNodeStatus (requires NODE_NAME env)")
+	flag.UintVar(&inputFlags.nodeStatusHeartbeat, "nodeStatusHeartbeat", inputFlags.nodeStatusHeartbeat,
+		"(optional) interval to publish the node status if unchanged (in seconds)")
+	flag.BoolVar(&inputFlags.once, "once", inputFlags.once,
+		"(optional) perform a single sync and exit, with non-zero status if any secret failed to sync")
+	flag.UintVar(&inputFlags.onceTimeout, "onceTimeout", inputFlags.onceTimeout,
+		"(optional) timeout for the single sync, including waiting for handler configs (in seconds)")
+	flag.BoolVar(&inputFlags.dryRun, "dryRun", inputFlags.dryRun,
+		"(optional) print the keys a single sync would write and remove without touching the sync directory, and exit")
+	flag.StringVar(&inputFlags.output, "output", inputFlags.output,
+		"(optional) output format of the dry run, text or json (defaults to text)")
+	flag.StringVar(&inputFlags.hubURL, "hubURL", inputFlags.hubURL,
+		"(optional) URL of the KeySync hub to retrieve the unwrapped keys from instead of processing the secrets locally")
+	flag.StringVar(&inputFlags.hubCAFile, "hubCAFile", inputFlags.hubCAFile,
+		"(optional) CA file to verify the KeySync hub with")
+	flag.StringVar(&inputFlags.hubClientCertFile, "hubClientCertFile", inputFlags.hubClientCertFile,
+		"(optional) client certificate file to authenticate to the KeySync hub with")
+	flag.StringVar(&inputFlags.hubClientKeyFile, "hubClientKeyFile", inputFlags.hubClientKeyFile,
+		"(optional) client private key file to authenticate to the KeySync hub with")
+	flag.StringVar(&inputFlags.hubTokenFile, "hubTokenFile", inputFlags.hubTokenFile,
+		"(optional) projected service account token file to authenticate to the KeySync hub with")
+	flag.BoolVar(&inputFlags.nodeKeyWrapping, "nodeKeyWrapping", inputFlags.nodeKeyWrapping,
+		"(optional) generate a node-local keypair and require the KeySync hub to wrap the keys to its public key (requires -hubURL and -publishNodeStatus)")
 	flag.Parse()
 
+	if inputFlags.nodeKeyWrapping && (inputFlags.hubURL == "" || !inputFlags.publishNodeStatus) {
+		panic("node key wrapping requires the KeySync hub and publishing the node status")
+	}
+
+	if inputFlags.hubURL != "" && (inputFlags.keyprotectConfigFile != "" || inputFlags.keyprotectConfigKubeSecret != "" ||
+		inputFlags.aesKEKDir != "" || inputFlags.aesKEKKubeSecret != "" ||
+		inputFlags.ageIdentityFile != "" || inputFlags.ageIdentityKubeSecret != "" ||
+		inputFlags.pgpKeyringFile != "" || inputFlags.pgpKeyringKubeSecret != "" ||
+		inputFlags.multiConfigFile != "" || inputFlags.multiConfigKubeSecret != "") {
+		panic("handler configs cannot be used with the KeySync hub")
+	}
+
+	if inputFlags.hubURL != "" && inputFlags.signingPublicKeysFile != "" {
+		panic("secrets are verified by the KeySync hub")
+	}
+
+	if inputFlags.output != "text" && inputFlags.output != "json" {
+		logrus.Fatalf("Invalid output format %q, must be text or json", inputFlags.output)
+	}
+
 	config, err := clientcmd.BuildConfigFromFlags("", inputFlags.kubeconfig)
 	if err != nil {
 		panic(err)
@@ -98,16 +307,182 @@ func main() {
 		panic("input interval caused conversion overflow")
 	}
 	interval := time.Duration(inputFlags.interval) * time.Second
+	if inputFlags.expiryWarningHours > math.MaxInt64/uint(time.Hour) {
+		panic("input expiryWarningHours caused conversion overflow")
+	}
 
 	ksc := keysync.KeySyncServerConfig{
-		K8sClient:          clientset,
-		Interval:           interval,
-		KeySyncDir:         inputFlags.dir,
-		Namespace:          namespace,
-		KeyFilePermissions: os.FileMode(keyFilePermissions),
-		KeyFileOwnerUID:    keyFileOwnerUID,
-		KeyFileOwnerGID:    keyFileOwnerGID,
+		K8sClient:           clientset,
+		Interval:            interval,
+		KeySyncDir:          inputFlags.dir,
+		Namespace:           namespace,
+		KeyFilePermissions:  os.FileMode(keyFilePermissions),
+		KeyFileOwnerUID:     keyFileOwnerUID,
+		KeyFileOwnerGID:     keyFileOwnerGID,
+		ExpiryWarningPeriod: time.Duration(inputFlags.expiryWarningHours) * time.Hour,
+	}
+
+	// The node-local keypair is only held in memory, a new keypair is
+	// published on every start
+	var keyPair *nodekey.KeyPair
+	if inputFlags.nodeKeyWrapping {
+		keyPair, err = nodekey.GenerateKeyPair()
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	if inputFlags.hubURL != "" {
+		tlsConfig, err := hub.NewClientTLSConfig(inputFlags.hubCAFile, inputFlags.hubClientCertFile, inputFlags.hubClientKeyFile)
+		if err != nil {
+			panic(err)
+		}
+		ksc.KeySource = hub.NewHubClient(hub.HubClientConfig{
+			URL:       inputFlags.hubURL,
+			TokenFile: inputFlags.hubTokenFile,
+			TLSConfig: tlsConfig,
+			Timeout:   interval,
+			KeyPair:   keyPair,
+		})
+		logrus.Printf("Retrieving keys from KeySync hub %v", inputFlags.hubURL)
+	}
+
+	if inputFlags.publishNodeStatus {
+		nodeName := os.Getenv(NodeNameEnv)
+		if nodeName == "" {
+			panic("node name required to publish node status")
+		}
+		dynamicClient, err := dynamic.NewForConfig(config)
+		if err != nil {
+			panic(err)
+		}
+		if inputFlags.nodeStatusHeartbeat > math.MaxInt64 {
+			panic("input node status heartbeat caused conversion overflow")
+		}
+		nspc := nodestatus.NodeStatusPublisherConfig{
+			K8sClient:     clientset,
+			DynamicClient: dynamicClient,
+			NodeName:      nodeName,
+			Heartbeat:     time.Duration(inputFlags.nodeStatusHeartbeat) * time.Second,
+		}
+		if keyPair != nil {
+			nspc.PublicKey = keyPair.PublicKey()
+		}
+		nsp := nodestatus.NewNodeStatusPublisher(nspc)
+		if keyPair != nil {
+			// Published before the first sync, so that the hub can
+			// wrap the keys of the first sync
+			if err := nsp.PublishPublicKey(context.Background()); err != nil {
+				logrus.Errorf("Unable to publish node public key: %v", err)
+			}
+		}
+		ksc.SyncReporter = nsp.Report
+		logrus.Printf("Publishing node status for node %v", nodeName)
+	}
+
+	if inputFlags.signingPublicKeysFile != "" {
+		publicKeys, err := secsign.LoadPublicKeysFile(inputFlags.signingPublicKeysFile)
+		if err != nil {
+			panic(err)
+		}
+		verifier, err := secsign.NewVerifier(secsign.VerifierConfig{
+			PublicKeys: publicKeys,
+			Recorder:   secsign.NewEventRecorder(clientset, namespace, "enc-key-sync", os.Getenv(NodeNameEnv)),
+		})
+		if err != nil {
+			panic(err)
+		}
+		ksc.SecretVerifier = verifier
+		logrus.Printf("Only syncing secrets signed by one of %d trusted keys", len(publicKeys))
+	}
+
+	ksc.Layout, err = keysync.NewLayout(inputFlags.layout)
+	if err != nil {
+		panic(err)
+	}
+	ksc.MetadataFile = inputFlags.metadataFile
+	ksc.WatchKeySyncDir = inputFlags.watchKeySyncDir
+	ksc.SecureWipe = inputFlags.secureWipe
+
+	if inputFlags.seLinuxLabel != "" {
+		if err := keysync.ValidateSELinuxLabel(inputFlags.seLinuxLabel); err != nil {
+			panic(err)
+		}
+		ksc.SELinuxLabel = inputFlags.seLinuxLabel
+		logrus.Printf("Private key files will be labeled %v", ksc.SELinuxLabel)
+	}
+	ksc.LockKeySyncDirs = inputFlags.lock
+	if inputFlags.lockTimeout > math.MaxInt64/uint(time.Second) {
+		panic("input lockTimeout caused conversion overflow")
+	}
+	ksc.LockTimeout = time.Duration(inputFlags.lockTimeout) * time.Second
+	ksc.LockFile = inputFlags.lockFile
+
+	if inputFlags.sinksFile != "" {
+		ksc.Sinks, err = keysync.LoadSinksFile(inputFlags.sinksFile)
+		if err != nil {
+			panic(err)
+		}
+		for _, s := range ksc.Sinks {
+			logrus.Printf("Also syncing keys to %v (%v)", s.KeySyncDir, s.Name)
+		}
+	}
+
+	if inputFlags.deliveryAllowlistFile != "" {
+		ksc.DeliveryAllowlist, err = keysync.LoadDeliveryAllowlistFile(inputFlags.deliveryAllowlistFile)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	if inputFlags.policyFile != "" {
+		p, err := policy.LoadPolicyFile(inputFlags.policyFile)
+		if err != nil {
+			panic(err)
+		}
+		ksc.SecretPolicy = p
+		logrus.Printf("Enforcing the secret policy of %v", inputFlags.policyFile)
 	}
+
+	if inputFlags.metricsAddr != "" {
+		go func() {
+			mux := http.NewServeMux()
+			mux.Handle("/metrics", promhttp.Handler())
+			server := &http.Server{Addr: inputFlags.metricsAddr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+			if err := server.ListenAndServe(); err != nil {
+				logrus.Errorf("Metrics server failure: %v", err)
+			}
+		}()
+	}
+
+	// The keyprotect handler is added asynchronously when its config is
+	// loaded from a kube secret
+	if inputFlags.keyprotectConfigFile == "" && inputFlags.keyprotectConfigKubeSecret != "" {
+		ksc.RequiredSecretTypes = append(ksc.RequiredSecretTypes, "kp-key")
+	}
+
+	// The AES handler is added asynchronously when its KEKs are loaded
+	if inputFlags.aesKEKDir != "" || inputFlags.aesKEKKubeSecret != "" {
+		ksc.RequiredSecretTypes = append(ksc.RequiredSecretTypes, aeswrap.SecretType)
+	}
+
+	// The age and SOPS handlers are added asynchronously when the identities
+	// are loaded
+	if inputFlags.ageIdentityFile != "" || inputFlags.ageIdentityKubeSecret != "" {
+		ksc.RequiredSecretTypes = append(ksc.RequiredSecretTypes, agekey.AgeSecretType, agekey.SOPSSecretType)
+	}
+
+	// The OpenPGP handler is added asynchronously when the keyrings are loaded
+	if inputFlags.pgpKeyringFile != "" || inputFlags.pgpKeyringKubeSecret != "" {
+		ksc.RequiredSecretTypes = append(ksc.RequiredSecretTypes, pgpkey.SecretType)
+	}
+
+	// The multi backend handler is added asynchronously when its config is
+	// loaded from a kube secret
+	if inputFlags.multiConfigFile == "" && inputFlags.multiConfigKubeSecret != "" {
+		ksc.RequiredSecretTypes = append(ksc.RequiredSecretTypes, multiwrap.SecretType)
+	}
+
 	ks := keysync.NewKeySyncServer(ksc)
 
 	if inputFlags.keyprotectConfigFile != "" {
@@ -117,7 +492,7 @@ func main() {
 		}
 		ks.AddSecretKeyHandler("kp-key", kpskh)
 	} else if inputFlags.keyprotectConfigKubeSecret != "" {
-		go keyprotectConfigKubeSecretThread(clientset, namespace, inputFlags.keyprotectConfigKubeSecret, ks, interval)
+		go keyprotect.ConfigKubeSecretThread(clientset, namespace, inputFlags.keyprotectConfigKubeSecret, inputFlags.keyprotectConfigKubeSecretKey, ks, interval)
 		/*
 			secClient := clientset.CoreV1().Secrets(namespace)
 			s, err := secClient.Get(inputFlags.keyprotectConfigKubeSecret, metav1.GetOptions{})
@@ -137,6 +512,36 @@ func main() {
 		*/
 	}
 
+	if inputFlags.aesKEKDir != "" {
+		go aeswrap.KEKThread(func() (map[string][]byte, error) {
+			return aeswrap.LoadKEKDir(inputFlags.aesKEKDir)
+		}, ks, interval)
+	} else if inputFlags.aesKEKKubeSecret != "" {
+		go aeswrap.KEKThread(aeswrap.KubeSecretKEKLoader(clientset, namespace, inputFlags.aesKEKKubeSecret), ks, interval)
+	}
+
+	if inputFlags.ageIdentityFile != "" {
+		go agekey.IdentityThread(agekey.FileIdentityLoader(inputFlags.ageIdentityFile), ks, interval)
+	} else if inputFlags.ageIdentityKubeSecret != "" {
+		go agekey.IdentityThread(agekey.KubeSecretIdentityLoader(clientset, namespace, inputFlags.ageIdentityKubeSecret, inputFlags.ageIdentityKubeSecretKey), ks, interval)
+	}
+
+	if inputFlags.pgpKeyringFile != "" {
+		go pgpkey.KeyringThread(pgpkey.FileKeyringLoader(inputFlags.pgpKeyringFile, inputFlags.pgpPassphraseFile, inputFlags.pgpTrustedKeyringFile), ks, interval)
+	} else if inputFlags.pgpKeyringKubeSecret != "" {
+		go pgpkey.KeyringThread(pgpkey.KubeSecretKeyringLoader(clientset, namespace, inputFlags.pgpKeyringKubeSecret), ks, interval)
+	}
+
+	if inputFlags.multiConfigFile != "" {
+		mskh, err := multiwrap.GetSecKeyHandlerFromConfigFile(inputFlags.multiConfigFile)
+		if err != nil {
+			panic(err)
+		}
+		ks.AddSecretKeyHandler(multiwrap.SecretType, mskh)
+	} else if inputFlags.multiConfigKubeSecret != "" {
+		go multiwrap.ConfigThread(multiwrap.KubeSecretConfigLoader(clientset, namespace, inputFlags.multiConfigKubeSecret, inputFlags.multiConfigKubeSecretKey), ks, interval)
+	}
+
 	logrus.Printf("Starting KeySync server with sync-dir %v, interval %v s, namespace %v",
 		ksc.KeySyncDir,
 		ksc.Interval/time.Second,
@@ -151,46 +556,138 @@ func main() {
 			*ksc.KeyFileOwnerGID)
 	}
 
-	if err := ks.Start(); err != nil {
-		logrus.Fatalf("KeySync failure: %v", err)
+	if inputFlags.purge {
+		if inputFlags.daemonSetName != "" && !daemonSetUninstalling(clientset, namespace, inputFlags.daemonSetName) {
+			logrus.Printf("DaemonSet %v is not being deleted, keeping the keys", inputFlags.daemonSetName)
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), ksc.LockTimeout)
+		err := ks.Purge(ctx)
+		cancel()
+		if err != nil {
+			logrus.Fatalf("KeySync purge failure: %v", err)
+		}
+		if inputFlags.tmpfs {
+			unmountTmpfs(ksc)
+		}
+		logrus.Printf("KeySync purge completed")
+		return
 	}
-}
 
-// keyprotectConfigKubeSecretThread is a helper function that tries to retrieve the kube secret containing the
-// keyprotect config and add the handler to the key sync server. Meant to run as a thread.
-func keyprotectConfigKubeSecretThread(clientset kubernetes.Interface, namespace string, secretName string, ks *keysync.KeySyncServer, interval time.Duration) {
-	first := true
-	oldData := ""
-	for {
-		if !first {
-			<-time.After(interval)
-		} else {
-			first = false
+	if inputFlags.dryRun {
+		if inputFlags.onceTimeout > math.MaxInt64 {
+			panic("input once timeout caused conversion overflow")
 		}
-
-		secClient := clientset.CoreV1().Secrets(namespace)
-		s, err := secClient.Get(context.Background(), secretName, metav1.GetOptions{})
+		ctx, cancel := context.WithTimeout(context.Background(), time.Duration(inputFlags.onceTimeout)*time.Second)
+		plan, err := ks.Plan(ctx)
+		cancel()
 		if err != nil {
-			continue
+			logrus.Fatalf("KeySync plan failure: %v", err)
 		}
+		if inputFlags.output == "json" {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(plan); err != nil {
+				logrus.Fatalf("Unable to encode plan: %v", err)
+			}
+			return
+		}
+		plan.Print(os.Stdout)
+		return
+	}
 
-		if s.Data != nil {
-			d := s.Data["config.json"]
-			if len(d) > 0 {
-				if string(d) == oldData {
-					continue
-				}
-				oldData = string(d)
-
-				logrus.Printf("New keyprotect config detected in secrets, configuring...")
-				kpskh, err := keyprotect.GetSecKeyHandlerFromConfig(d)
+	// Keys are only written to memory backed directories, the keys written
+	// to disk before are removed rather than hidden under the mounts
+	if inputFlags.tmpfs {
+		for _, dir := range syncDirs(ksc) {
+			if tmpfs, err := keysync.IsTmpfs(dir); err == nil && !tmpfs {
+				ctx, cancel := context.WithTimeout(context.Background(), ksc.LockTimeout)
+				err := ks.Purge(ctx)
+				cancel()
 				if err != nil {
-					// log err
-					logrus.Errorf("Unable to parse keyprotect config: %v", err)
-					continue
+					logrus.Fatalf("KeySync failure: %v", err)
 				}
-				ks.AddSecretKeyHandler("kp-key", kpskh)
+				break
+			}
+		}
+		for _, dir := range syncDirs(ksc) {
+			if err := keysync.EnsureTmpfs(dir, inputFlags.tmpfsSize); err != nil {
+				logrus.Fatalf("KeySync failure: %v", err)
 			}
 		}
 	}
+
+	if inputFlags.once {
+		if inputFlags.onceTimeout > math.MaxInt64 {
+			panic("input once timeout caused conversion overflow")
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Duration(inputFlags.onceTimeout)*time.Second)
+		err := ks.SyncOnce(ctx)
+		cancel()
+		if err != nil {
+			logrus.Fatalf("KeySync failure: %v", err)
+		}
+		logrus.Printf("KeySync completed")
+		return
+	}
+
+	// The synced keys are kept and the locks released on termination, so
+	// that the next instance takes over, unless the DaemonSet is uninstalled
+	// and the keys are purged while the locks are still held
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	purged := make(chan bool, 1)
+	go func() {
+		<-signals
+		purge := inputFlags.daemonSetName != "" && daemonSetUninstalling(clientset, namespace, inputFlags.daemonSetName)
+		purged <- purge
+		if purge {
+			ks.StopAndPurge()
+			return
+		}
+		ks.Stop()
+	}()
+
+	if err := ks.Start(); err != nil {
+		logrus.Fatalf("KeySync failure: %v", err)
+	}
+	if <-purged && inputFlags.tmpfs {
+		unmountTmpfs(ksc)
+	}
+	logrus.Printf("KeySync stopped")
+}
+
+// unmountTmpfs unmounts the tmpfs of the key sync directory and of the
+// directories of the sinks once the keys are purged
+func unmountTmpfs(ksc keysync.KeySyncServerConfig) {
+	for _, dir := range syncDirs(ksc) {
+		if err := keysync.UnmountTmpfs(dir); err != nil {
+			logrus.Errorf("Unable to unmount tmpfs %v: %v", dir, err)
+		}
+	}
+}
+
+// syncDirs returns the key sync directory and the directories of the sinks
+func syncDirs(ksc keysync.KeySyncServerConfig) []string {
+	dirs := []string{ksc.KeySyncDir}
+	for _, s := range ksc.Sinks {
+		dirs = append(dirs, s.KeySyncDir)
+	}
+	return dirs
+}
+
+// daemonSetUninstalling returns whether the DaemonSet is deleted or being
+// deleted, as opposed to the pod being restarted or updated. If it cannot be
+// checked, i.e. on a transient error of the API server, the keys are kept, so
+// that a rolling update does not remove the keys of the node.
+func daemonSetUninstalling(clientset kubernetes.Interface, namespace, name string) bool {
+	ds, err := clientset.AppsV1().DaemonSets(namespace).Get(context.Background(), name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return true
+	}
+	if err != nil {
+		logrus.Warnf("Unable to check DaemonSet %v, keeping the keys: %v", name, err)
+		return false
+	}
+	return ds.DeletionTimestamp != nil
 }
@@ -0,0 +1,194 @@
+// Copyright 2026 k8s-enc-image-operator authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package multiwrap
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/lumjjb/k8s-enc-image-operator/aeswrap"
+	keyprotect "github.com/lumjjb/k8s-enc-image-operator/keyprotect"
+	"github.com/lumjjb/k8s-enc-image-operator/keysync/sechandlers"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// multiConfig example, is a json in the following format, the backends are
+// tried in order
+// {
+//     "backends": [
+//         {
+//             "name": "us-south",
+//             "timeout": "5s",
+//             "keyprotect": {
+//                 "keyprotect-url": "https://us-south.kms.cloud.ibm.com",
+//                 "instance-id": "a3c5e3g5-9ef7-4838-a285-398efb23e6f3",
+//                 "apikey": "ZWh0Y.................................4Tsxbz"
+//             }
+//         },
+//         {
+//             "name": "local",
+//             "aesKEKs": {"v1": "<base64 of the raw AES KEK>"}
+//         }
+//     ]
+// }
+type multiConfig struct {
+	Backends []backendConfig `json:"backends"`
+}
+
+// backendConfig configures a single backend, exactly one of Keyprotect and
+// AESKEKs must be set
+type backendConfig struct {
+	Name       string            `json:"name"`
+	Timeout    string            `json:"timeout,omitempty"`
+	Keyprotect json.RawMessage   `json:"keyprotect,omitempty"`
+	AESKEKs    map[string][]byte `json:"aesKEKs,omitempty"`
+}
+
+// GetSecKeyHandlerFromConfigFile returns a secret handler for keys wrapped by
+// several backends given a configuration file
+func GetSecKeyHandlerFromConfigFile(configPath string) (sechandlers.SecretKeyHandler, error) {
+	data, err := os.ReadFile(filepath.Clean(configPath))
+	if err != nil {
+		return nil, err
+	}
+
+	return GetSecKeyHandlerFromConfig(data)
+}
+
+// GetSecKeyHandlerFromConfig returns a secret handler for keys wrapped by
+// several backends given configuration data
+func GetSecKeyHandlerFromConfig(data []byte) (sechandlers.SecretKeyHandler, error) {
+	backends, err := parseConfig(data)
+	if err != nil {
+		return nil, err
+	}
+	return NewMultiSecretKeyHandler(backends), nil
+}
+
+// parseConfig parses the configuration data into the backends
+func parseConfig(data []byte) ([]Backend, error) {
+	var mc multiConfig
+	if err := json.Unmarshal(data, &mc); err != nil {
+		return nil, err
+	}
+	if len(mc.Backends) == 0 {
+		return nil, errors.New("no backends configured")
+	}
+
+	backends := []Backend{}
+	names := map[string]bool{}
+	for _, bc := range mc.Backends {
+		if bc.Name == "" {
+			return nil, errors.New("backend without name")
+		}
+		if names[bc.Name] {
+			return nil, errors.Errorf("duplicate backend %s", bc.Name)
+		}
+		names[bc.Name] = true
+
+		b := Backend{Name: bc.Name, Timeout: DefaultTimeout}
+		if bc.Timeout != "" {
+			timeout, err := time.ParseDuration(bc.Timeout)
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid timeout of backend %s", bc.Name)
+			}
+			b.Timeout = timeout
+		}
+
+		switch {
+		case len(bc.Keyprotect) > 0 && len(bc.AESKEKs) == 0:
+			kpskh, err := keyprotect.GetSecKeyHandlerFromConfig(bc.Keyprotect)
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid keyprotect config of backend %s", bc.Name)
+			}
+			b.Handler = kpskh
+		case len(bc.AESKEKs) > 0 && len(bc.Keyprotect) == 0:
+			for kid, kek := range bc.AESKEKs {
+				if _, err := aes.NewCipher(kek); err != nil {
+					return nil, errors.Wrapf(err, "invalid KEK %q of backend %s", kid, bc.Name)
+				}
+			}
+			b.Handler = aeswrap.NewAESSecretKeyHandler(bc.AESKEKs)
+		default:
+			return nil, errors.Errorf("backend %s must configure exactly one of keyprotect and aesKEKs", bc.Name)
+		}
+
+		backends = append(backends, b)
+	}
+	return backends, nil
+}
+
+// ConfigLoader is a function type that loads the configuration data
+type ConfigLoader func() ([]byte, error)
+
+// KubeSecretConfigLoader returns a loader of the configuration data from the
+// entry of the kube secret
+func KubeSecretConfigLoader(clientset kubernetes.Interface, namespace, secretName, secretKey string) ConfigLoader {
+	return func() ([]byte, error) {
+		s, err := clientset.CoreV1().Secrets(namespace).Get(context.Background(), secretName, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		data, ok := s.Data[secretKey]
+		if !ok {
+			return nil, errors.Errorf("key %s not found in secret %s", secretKey, secretName)
+		}
+		return data, nil
+	}
+}
+
+// ConfigThread is a helper function that loads the configuration and adds the
+// handler to the server, and again whenever the configuration changes. Meant
+// to run as a thread.
+func ConfigThread(load ConfigLoader, adder sechandlers.SecretKeyHandlerAdder, interval time.Duration) {
+	first := true
+	var oldData []byte
+	for {
+		if !first {
+			<-time.After(interval)
+		} else {
+			first = false
+		}
+
+		data, err := load()
+		if err != nil {
+			logrus.Errorf("Unable to load multi backend config: %v", err)
+			continue
+		}
+
+		if oldData != nil && bytes.Equal(data, oldData) {
+			continue
+		}
+
+		skh, err := GetSecKeyHandlerFromConfig(data)
+		if err != nil {
+			logrus.Errorf("Unable to parse multi backend config: %v", err)
+			continue
+		}
+		oldData = data
+
+		logrus.Printf("New multi backend config detected, configuring...")
+		adder.AddSecretKeyHandler(SecretType, skh)
+	}
+}
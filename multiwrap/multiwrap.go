@@ -0,0 +1,165 @@
+// Copyright 2026 k8s-enc-image-operator authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package multiwrap handles keys wrapped redundantly by several backends, i.e.
+// Key Protect instances in different regions or a local KEK, so that keys can
+// still be unwrapped while one of the backends is unavailable.
+package multiwrap
+
+import (
+	"strings"
+	"time"
+
+	"github.com/lumjjb/k8s-enc-image-operator/keysync/sechandlers"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// SecretType is the type of the secrets holding a key wrapped by
+	// several backends
+	SecretType = "multi-wrapped-key"
+
+	// FilenameField is the name of the key file, DefaultFilename if not set.
+	// It must be a valid secret data key.
+	FilenameField = "filename"
+
+	// DefaultFilename is the name of the key file if the secret does not
+	// specify one
+	DefaultFilename = "key"
+
+	// BackendFieldSeparator separates the backend name from the data field
+	// of the backend, i.e. "us-south.ciphertext"
+	BackendFieldSeparator = "."
+
+	// DefaultTimeout is the timeout of a backend if not configured
+	DefaultTimeout = 10 * time.Second
+)
+
+// unwraps counts the keys unwrapped by each backend, so that failovers to the
+// later backends are visible
+var unwraps = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "enc_key_sync_multiwrap_unwraps_total",
+	Help: "Number of keys of multi-wrapped-key secrets unwrapped by each backend.",
+}, []string{"backend"})
+
+func init() {
+	prometheus.MustRegister(unwraps)
+}
+
+// Backend is a backend that a key can be wrapped by
+type Backend struct {
+	// Name identifies the backend, its data fields in the secret are
+	// prefixed by the name and BackendFieldSeparator
+	Name string
+
+	// Handler unwraps the key from the data fields of the backend, it must
+	// return a single file
+	Handler sechandlers.SecretKeyHandler
+
+	// Timeout is the time after which the next backend is tried
+	Timeout time.Duration
+}
+
+// handlerResult is the result of a backend handler
+type handlerResult struct {
+	files map[string][]byte
+	err   error
+}
+
+// NewMultiSecretKeyHandler returns a secret handler unwrapping the key with the
+// backends in order, until one succeeds. Backends without data fields in the
+// secret are skipped. The backend that unwrapped the key is logged and counted
+// in the enc_key_sync_multiwrap_unwraps_total metric.
+func NewMultiSecretKeyHandler(backends []Backend) sechandlers.SecretKeyHandler {
+	return func(data map[string][]byte) (map[string][]byte, error) {
+		filename := DefaultFilename
+		if f, ok := data[FilenameField]; ok {
+			filename = string(f)
+			if err := sechandlers.ValidateFilename(filename); err != nil {
+				return nil, err
+			}
+		}
+
+		failures := []string{}
+		for _, b := range backends {
+			bdata := backendData(data, b.Name)
+			if len(bdata) == 0 {
+				continue
+			}
+
+			key, err := unwrapWithTimeout(b, bdata)
+			if err != nil {
+				logrus.Warnf("Unable to unwrap key %s with backend %s, failing over: %v", filename, b.Name, err)
+				failures = append(failures, b.Name+": "+err.Error())
+				continue
+			}
+
+			logrus.Printf("Unwrapped key %s with backend %s", filename, b.Name)
+			unwraps.WithLabelValues(b.Name).Inc()
+			return map[string][]byte{filename: key}, nil
+		}
+
+		if len(failures) == 0 {
+			return nil, errors.New("key not wrapped by any configured backend")
+		}
+		return nil, errors.Errorf("unable to unwrap key with any backend: %s", strings.Join(failures, "; "))
+	}
+}
+
+// backendData returns the data fields of the backend without its prefix
+func backendData(data map[string][]byte, name string) map[string][]byte {
+	prefix := name + BackendFieldSeparator
+	bdata := map[string][]byte{}
+	for k, v := range data {
+		if strings.HasPrefix(k, prefix) {
+			bdata[strings.TrimPrefix(k, prefix)] = v
+		}
+	}
+	return bdata
+}
+
+// unwrapWithTimeout unwraps the key with the backend, giving up after its
+// timeout. Handlers do not support cancellation, so a timed out handler keeps
+// running in the background until it returns.
+func unwrapWithTimeout(b Backend, data map[string][]byte) ([]byte, error) {
+	timeout := b.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+
+	resultCh := make(chan handlerResult, 1)
+	go func() {
+		files, err := b.Handler(data)
+		resultCh <- handlerResult{files: files, err: err}
+	}()
+
+	select {
+	case r := <-resultCh:
+		if r.err != nil {
+			return nil, r.err
+		}
+		if len(r.files) != 1 {
+			return nil, errors.Errorf("backend returned %d files, expected 1", len(r.files))
+		}
+		for _, key := range r.files {
+			return key, nil
+		}
+		return nil, nil
+	case <-time.After(timeout):
+		return nil, errors.Errorf("timed out after %v", timeout)
+	}
+}
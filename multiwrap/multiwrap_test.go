@@ -0,0 +1,172 @@
+// Copyright 2026 k8s-enc-image-operator authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package multiwrap
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lumjjb/k8s-enc-image-operator/aeswrap"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// TestMultiHandlerFailover tests that the backends are tried in order until one
+// unwraps the key, skipping backends the key is not wrapped by
+func TestMultiHandlerFailover(t *testing.T) {
+	key := []byte("this is a key")
+	var mu sync.Mutex
+	calls := []string{}
+	called := func(name string) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls = append(calls, name)
+	}
+
+	backends := []Backend{
+		{
+			Name: "slow",
+			Handler: func(data map[string][]byte) (map[string][]byte, error) {
+				called("slow")
+				time.Sleep(time.Second)
+				return map[string][]byte{"key": key}, nil
+			},
+			Timeout: 10 * time.Millisecond,
+		},
+		{
+			Name: "unused",
+			Handler: func(data map[string][]byte) (map[string][]byte, error) {
+				called("unused")
+				return map[string][]byte{"key": key}, nil
+			},
+		},
+		{
+			Name: "down",
+			Handler: func(data map[string][]byte) (map[string][]byte, error) {
+				called("down")
+				return nil, fmt.Errorf("region unavailable")
+			},
+		},
+		{
+			Name: "up",
+			Handler: func(data map[string][]byte) (map[string][]byte, error) {
+				called("up")
+				if string(data["ciphertext"]) != "wrapped" {
+					return nil, fmt.Errorf("unexpected data %v", data)
+				}
+				return map[string][]byte{"kpkey": key}, nil
+			},
+		},
+	}
+	handler := NewMultiSecretKeyHandler(backends)
+	before := testutil.ToFloat64(unwraps.WithLabelValues("up"))
+
+	out, err := handler(map[string][]byte{
+		FilenameField:      []byte("my-key.pem"),
+		"slow.ciphertext":  []byte("wrapped"),
+		"down.ciphertext":  []byte("wrapped"),
+		"up.ciphertext":    []byte("wrapped"),
+		"upper.ciphertext": []byte("not for up"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != 1 || !bytes.Equal(out["my-key.pem"], key) {
+		t.Fatalf("Expected the key as my-key.pem, got %v", out)
+	}
+	mu.Lock()
+	tried := strings.Join(calls, ",")
+	mu.Unlock()
+	if tried != "slow,down,up" {
+		t.Fatalf("Expected backends slow, down, up to be tried, got %v", tried)
+	}
+
+	// The backend that unwrapped the key is recorded
+	if after := testutil.ToFloat64(unwraps.WithLabelValues("up")); after != before+1 {
+		t.Fatalf("Expected the unwrap by backend up to be counted, got %v", after-before)
+	}
+	for _, b := range []string{"slow", "down", "unused"} {
+		if n := testutil.ToFloat64(unwraps.WithLabelValues(b)); n != 0 {
+			t.Fatalf("Expected no unwraps by backend %v, got %v", b, n)
+		}
+	}
+
+	_, err = handler(map[string][]byte{"down.ciphertext": []byte("wrapped")})
+	if err == nil || !strings.Contains(err.Error(), "region unavailable") {
+		t.Fatalf("Expected the backend failures, got %v", err)
+	}
+
+	if _, err := handler(map[string][]byte{"other.ciphertext": []byte("wrapped")}); err == nil {
+		t.Fatal("Key not wrapped by any configured backend should fail")
+	}
+
+	_, err = handler(map[string][]byte{
+		FilenameField:   []byte("../escaped"),
+		"up.ciphertext": []byte("wrapped"),
+	})
+	if err == nil || !strings.Contains(err.Error(), "invalid key filename") {
+		t.Fatalf("Expected the invalid filename to be refused, got %v", err)
+	}
+}
+
+// TestMultiConfig tests a config with a local KEK backend
+func TestMultiConfig(t *testing.T) {
+	kek := bytes.Repeat([]byte{1}, 32)
+	key := []byte("this is a key")
+
+	wrapper, err := aeswrap.NewAESSecretKeyWrapper("v1", kek, aeswrap.AlgGCM, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrapped, err := wrapper(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := fmt.Sprintf(`{"backends": [{"name": "local", "timeout": "1s", "aesKEKs": {"v1": %q}}]}`,
+		base64.StdEncoding.EncodeToString(kek))
+	handler, err := GetSecKeyHandlerFromConfig([]byte(config))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := map[string][]byte{}
+	for k, v := range wrapped {
+		data["local"+BackendFieldSeparator+k] = v
+	}
+	out, err := handler(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out[DefaultFilename], key) {
+		t.Fatalf("Expected %q, got %v", key, out)
+	}
+
+	for _, invalid := range []string{
+		`{"backends": []}`,
+		`{"backends": [{"name": "local"}]}`,
+		`{"backends": [{"name": "local", "aesKEKs": {"v1": "AAAA"}}]}`,
+		`{"backends": [{"name": "local", "timeout": "soon", "aesKEKs": {"v1": "` + base64.StdEncoding.EncodeToString(kek) + `"}}]}`,
+	} {
+		if _, err := GetSecKeyHandlerFromConfig([]byte(invalid)); err == nil {
+			t.Fatalf("Config %s should be invalid", invalid)
+		}
+	}
+}
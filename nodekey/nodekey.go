@@ -0,0 +1,139 @@
+// Copyright 2026 k8s-enc-image-operator authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package nodekey wraps keys to the node-local keypair of a node daemon, so
+// that keys served to one node are useless on any other node.
+//
+// Keys are wrapped with an ephemeral X25519 key agreement with the public key
+// of the node, HKDF-SHA256 and AES-256-GCM. The wrapped key is the ephemeral
+// public key, followed by the nonce and the ciphertext.
+package nodekey
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+
+	"github.com/pkg/errors"
+)
+
+// hkdfInfo binds the derived keys to their use
+const hkdfInfo = "oci.crypt node key wrap v1"
+
+// KeyPair is the node-local keypair of a node daemon, it is generated on
+// startup and never leaves the node
+type KeyPair struct {
+	privateKey *ecdh.PrivateKey
+}
+
+// GenerateKeyPair generates a new node-local keypair
+func GenerateKeyPair() (*KeyPair, error) {
+	privateKey, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &KeyPair{privateKey: privateKey}, nil
+}
+
+// PublicKey returns the base64 encoded public key of the keypair, which is
+// published in the EncKeyNodeStatus of the node
+func (kp *KeyPair) PublicKey() string {
+	return base64.StdEncoding.EncodeToString(kp.privateKey.PublicKey().Bytes())
+}
+
+// Wrap wraps the key to the base64 encoded public key of a node
+func Wrap(publicKey string, key []byte) ([]byte, error) {
+	data, err := base64.StdEncoding.DecodeString(publicKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid public key")
+	}
+	recipient, err := ecdh.X25519().NewPublicKey(data)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid public key")
+	}
+
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	shared, err := ephemeral.ECDH(recipient)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := newAEAD(shared, ephemeral.PublicKey().Bytes(), recipient.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	wrapped := append(ephemeral.PublicKey().Bytes(), nonce...)
+	return aead.Seal(wrapped, nonce, key, nil), nil
+}
+
+// Unwrap unwraps a key wrapped to the public key of the keypair
+func (kp *KeyPair) Unwrap(wrapped []byte) ([]byte, error) {
+	// X25519 public keys are 32 bytes
+	if len(wrapped) < 32 {
+		return nil, errors.New("wrapped key too short")
+	}
+	ephemeral, err := ecdh.X25519().NewPublicKey(wrapped[:32])
+	if err != nil {
+		return nil, err
+	}
+	shared, err := kp.privateKey.ECDH(ephemeral)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := newAEAD(shared, wrapped[:32], kp.privateKey.PublicKey().Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	rest := wrapped[32:]
+	if len(rest) < aead.NonceSize() {
+		return nil, errors.New("wrapped key too short")
+	}
+
+	key, err := aead.Open(nil, rest[:aead.NonceSize()], rest[aead.NonceSize():], nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to unwrap key")
+	}
+	return key, nil
+}
+
+// newAEAD derives the AES-256-GCM key from the shared secret, salted with both
+// public keys
+func newAEAD(shared, ephemeralPublicKey, recipientPublicKey []byte) (cipher.AEAD, error) {
+	salt := append(append([]byte{}, ephemeralPublicKey...), recipientPublicKey...)
+	key, err := hkdf.Key(sha256.New, shared, salt, hkdfInfo, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
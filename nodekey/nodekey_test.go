@@ -0,0 +1,63 @@
+// Copyright 2026 k8s-enc-image-operator authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nodekey
+
+import (
+	"bytes"
+	"testing"
+)
+
+// TestWrapUnwrap tests that keys wrapped to a node can only be unwrapped by
+// the node
+func TestWrapUnwrap(t *testing.T) {
+	nodeA, err := GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodeB, err := GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key := []byte("this is a key")
+	wrapped, err := Wrap(nodeA.PublicKey(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(wrapped, key) {
+		t.Fatal("Wrapped key should not contain the key")
+	}
+
+	unwrapped, err := nodeA.Unwrap(wrapped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(unwrapped, key) {
+		t.Fatalf("Expected %q, got %q", key, unwrapped)
+	}
+
+	if _, err := nodeB.Unwrap(wrapped); err == nil {
+		t.Fatal("Key wrapped to node a should not unwrap on node b")
+	}
+
+	wrapped[len(wrapped)-1] ^= 1
+	if _, err := nodeA.Unwrap(wrapped); err == nil {
+		t.Fatal("Tampered key should not unwrap")
+	}
+
+	if _, err := Wrap("not a key", key); err == nil {
+		t.Fatal("Wrapping to an invalid public key should fail")
+	}
+}
@@ -0,0 +1,170 @@
+// Copyright 2026 k8s-enc-image-operator authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nodestatus
+
+import (
+	"context"
+	"sort"
+
+	"github.com/lumjjb/k8s-enc-image-operator/apis/v1alpha1"
+
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic"
+	clientset "k8s.io/client-go/kubernetes"
+)
+
+const (
+	// CoverageCurrent is a node holding the keys of the current version of
+	// the secret
+	CoverageCurrent = "Current"
+
+	// CoverageStale is a node holding the keys of an older version of the
+	// secret
+	CoverageStale = "Stale"
+
+	// CoverageMissing is a node not holding any keys of the secret
+	CoverageMissing = "Missing"
+
+	// CoverageError is a node that failed to sync the secret
+	CoverageError = "Error"
+
+	// CoverageUnknown is a node that has not published its status
+	CoverageUnknown = "Unknown"
+)
+
+// NodeCoverage is the coverage of a secret on a node
+type NodeCoverage struct {
+	// Node is the name of the node
+	Node string
+
+	// State is one of the Coverage states
+	State string
+
+	// Files are the key files of the secret on the node
+	Files []string
+
+	// ResourceVersion is the resource version of the secret synced to
+	// the node
+	ResourceVersion string
+
+	// Message is the sync error of the secret on the node
+	Message string
+
+	// LastSyncTime is the last sync time published by the node
+	LastSyncTime metav1.Time
+}
+
+// CoverageReport summarizes which nodes hold the keys of a secret
+type CoverageReport struct {
+	// Secret is the secret the report is for, with its current resource
+	// version
+	Secret v1alpha1.SecretReference
+
+	// Nodes are the coverage of the secret per node, sorted by node name
+	Nodes []NodeCoverage
+
+	// Counts are the number of nodes per coverage state
+	Counts map[string]int
+}
+
+// Coverage returns the report of which nodes of the cluster hold the keys of the
+// secret, based on the EncKeyNodeStatus published by the nodes
+func Coverage(ctx context.Context, k8sClient clientset.Interface, dynamicClient dynamic.Interface, namespace, name string) (*CoverageReport, error) {
+	report := &CoverageReport{
+		Secret: v1alpha1.SecretReference{
+			Namespace: namespace,
+			Name:      name,
+		},
+		Counts: map[string]int{},
+	}
+
+	secret, err := k8sClient.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil && !k8serrors.IsNotFound(err) {
+		return nil, err
+	}
+	if err == nil {
+		report.Secret.Type = string(secret.Type)
+		report.Secret.ResourceVersion = secret.GetResourceVersion()
+	}
+
+	nodes, err := k8sClient.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	list, err := dynamicClient.Resource(v1alpha1.EncKeyNodeStatusResource).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := map[string]*v1alpha1.EncKeyNodeStatus{}
+	for i := range list.Items {
+		var ns v1alpha1.EncKeyNodeStatus
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(list.Items[i].Object, &ns); err != nil {
+			return nil, err
+		}
+		statuses[ns.GetName()] = &ns
+	}
+
+	for _, node := range nodes.Items {
+		nc := nodeCoverage(statuses[node.GetName()], report.Secret)
+		nc.Node = node.GetName()
+		report.Nodes = append(report.Nodes, nc)
+		report.Counts[nc.State]++
+	}
+
+	sort.Slice(report.Nodes, func(i, j int) bool {
+		return report.Nodes[i].Node < report.Nodes[j].Node
+	})
+
+	return report, nil
+}
+
+// nodeCoverage returns the coverage of the secret given the status of the node
+func nodeCoverage(ns *v1alpha1.EncKeyNodeStatus, secret v1alpha1.SecretReference) NodeCoverage {
+	nc := NodeCoverage{State: CoverageUnknown}
+	if ns == nil {
+		return nc
+	}
+
+	nc.LastSyncTime = ns.Status.LastSyncTime
+	nc.State = CoverageMissing
+
+	for _, k := range ns.Status.Keys {
+		if k.Secret.Namespace != secret.Namespace || k.Secret.Name != secret.Name {
+			continue
+		}
+		nc.Files = append(nc.Files, k.Filename)
+		nc.ResourceVersion = k.Secret.ResourceVersion
+	}
+
+	if len(nc.Files) > 0 {
+		nc.State = CoverageCurrent
+		if secret.ResourceVersion != "" && nc.ResourceVersion != secret.ResourceVersion {
+			nc.State = CoverageStale
+		}
+	}
+
+	for _, e := range ns.Status.Errors {
+		if e.Secret.Namespace == secret.Namespace && e.Secret.Name == secret.Name {
+			nc.State = CoverageError
+			nc.Message = e.Message
+		}
+	}
+
+	return nc
+}
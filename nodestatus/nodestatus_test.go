@@ -0,0 +1,132 @@
+// Copyright 2026 k8s-enc-image-operator authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nodestatus
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/lumjjb/k8s-enc-image-operator/apis/v1alpha1"
+	"github.com/lumjjb/k8s-enc-image-operator/keysync"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	coretesting "k8s.io/client-go/testing"
+)
+
+// TestPublishAndCoverage runs through publishing the status of nodes and
+// summarizing the coverage of a secret
+func TestPublishAndCoverage(t *testing.T) {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "my-secret",
+			Namespace:       "enc-key-sync",
+			ResourceVersion: "2",
+		},
+		Type: "key",
+	}
+	fakeClient := fake.NewClientset(
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-a", UID: "uid-a"}},
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-b", UID: "uid-b"}},
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-c", UID: "uid-c"}},
+		secret,
+	)
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{v1alpha1.EncKeyNodeStatusResource: "EncKeyNodeStatusList"})
+
+	newPublisher := func(node string) *NodeStatusPublisher {
+		return NewNodeStatusPublisher(NodeStatusPublisherConfig{
+			K8sClient:     fakeClient,
+			DynamicClient: dynamicClient,
+			NodeName:      node,
+			Heartbeat:     time.Minute,
+		})
+	}
+
+	secRef := func(rv string) v1alpha1.SecretReference {
+		return v1alpha1.SecretReference{Namespace: "enc-key-sync", Name: "my-secret", Type: "key", ResourceVersion: rv}
+	}
+
+	now := time.Now()
+	resultA := &keysync.SyncResult{
+		Time: now,
+		Keys: []v1alpha1.SyncedKey{{Filename: "hash-enc-key-sync-my-secret-mykey", Hash: "hash", Secret: secRef("2")}},
+	}
+
+	// node-a holds the current keys, node-b a stale version, node-c has no status
+	pubA := newPublisher("node-a")
+	pubA.Report(resultA)
+	newPublisher("node-b").Report(&keysync.SyncResult{
+		Time: now,
+		Keys: []v1alpha1.SyncedKey{{Filename: "old-enc-key-sync-my-secret-mykey", Hash: "old", Secret: secRef("1")}},
+	})
+
+	u, err := dynamicClient.Resource(v1alpha1.EncKeyNodeStatusResource).Get(context.Background(), "node-a", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Node status should have been created: %v", err)
+	}
+	if refs := u.GetOwnerReferences(); len(refs) != 1 || refs[0].UID != "uid-a" {
+		t.Fatalf("Node status should be owned by node: %v", refs)
+	}
+
+	// Reporting an unchanged result within the heartbeat does not update
+	dynamicClient.ClearActions()
+	pubA.Report(&keysync.SyncResult{Time: now.Add(time.Second), Keys: resultA.Keys})
+	for _, a := range dynamicClient.Actions() {
+		if _, ok := a.(coretesting.UpdateAction); ok {
+			t.Fatal("Unchanged status should not be updated")
+		}
+	}
+
+	// Reporting a changed result updates
+	pubA.Report(&keysync.SyncResult{
+		Time:   now.Add(2 * time.Second),
+		Keys:   resultA.Keys,
+		Errors: []v1alpha1.SyncError{{Secret: secRef("2"), Message: "unable to write key file"}},
+	})
+	updated := false
+	for _, a := range dynamicClient.Actions() {
+		if _, ok := a.(coretesting.UpdateAction); ok {
+			updated = true
+		}
+	}
+	if !updated {
+		t.Fatal("Changed status should be updated")
+	}
+
+	report, err := Coverage(context.Background(), fakeClient, dynamicClient, "enc-key-sync", "my-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		"node-a": CoverageError,
+		"node-b": CoverageStale,
+		"node-c": CoverageUnknown,
+	}
+	if len(report.Nodes) != len(expected) {
+		t.Fatalf("Expected %d nodes in report, got %d", len(expected), len(report.Nodes))
+	}
+	for _, nc := range report.Nodes {
+		if nc.State != expected[nc.Node] {
+			t.Fatalf("Expected node %v to be %v, got %v", nc.Node, expected[nc.Node], nc.State)
+		}
+	}
+}
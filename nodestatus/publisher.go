@@ -0,0 +1,195 @@
+// Copyright 2026 k8s-enc-image-operator authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nodestatus
+
+import (
+	"context"
+	"time"
+
+	"github.com/lumjjb/k8s-enc-image-operator/apis/v1alpha1"
+	"github.com/lumjjb/k8s-enc-image-operator/keysync"
+
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/equality"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic"
+	clientset "k8s.io/client-go/kubernetes"
+)
+
+// NodeStatusPublisherConfig contains the parameters required for publishing
+// the EncKeyNodeStatus of a node
+type NodeStatusPublisherConfig struct {
+	// K8sClient is the k8s clientset to interface with the kubernetes
+	// cluster
+	K8sClient clientset.Interface
+
+	// DynamicClient is the k8s dynamic client to interface with the
+	// EncKeyNodeStatus custom resources
+	DynamicClient dynamic.Interface
+
+	// NodeName is the name of the node the status is published for
+	NodeName string
+
+	// Heartbeat is the interval in which the last sync time is published
+	// even if the synced keys and errors did not change
+	Heartbeat time.Duration
+
+	// PublicKey is the public key of the node-local keypair published for
+	// the KeySync hub to wrap keys with, empty if keys are not wrapped
+	PublicKey string
+}
+
+// NodeStatusPublisher publishes the results of the key syncs of a node as its
+// EncKeyNodeStatus. The status is only updated when the synced keys or errors
+// change, or when the heartbeat interval elapsed.
+type NodeStatusPublisher struct {
+	// k8sClient is the k8s clientset to interface with the kubernetes
+	// cluster
+	k8sClient clientset.Interface
+
+	// dynamicClient is the k8s dynamic client to interface with the
+	// EncKeyNodeStatus custom resources
+	dynamicClient dynamic.Interface
+
+	// nodeName is the name of the node the status is published for
+	nodeName string
+
+	// heartbeat is the interval in which the last sync time is published
+	heartbeat time.Duration
+
+	// publicKey is the public key of the node-local keypair
+	publicKey string
+
+	// published is the last status published, nil if the status has not
+	// been published yet
+	published *v1alpha1.EncKeyNodeStatusStatus
+}
+
+func NewNodeStatusPublisher(nspc NodeStatusPublisherConfig) *NodeStatusPublisher {
+	return &NodeStatusPublisher{
+		k8sClient:     nspc.K8sClient,
+		dynamicClient: nspc.DynamicClient,
+		nodeName:      nspc.NodeName,
+		heartbeat:     nspc.Heartbeat,
+		publicKey:     nspc.PublicKey,
+	}
+}
+
+// Report publishes the result of a sync, errors are logged and publishing is
+// retried on the next report. It implements keysync.SyncReporter.
+func (p *NodeStatusPublisher) Report(result *keysync.SyncResult) {
+	status := v1alpha1.EncKeyNodeStatusStatus{
+		LastSyncTime:   metav1.NewTime(result.Time),
+		LastChangeTime: metav1.NewTime(result.Time),
+		Keys:           result.Keys,
+		Errors:         result.Errors,
+		PublicKey:      p.publicKey,
+	}
+
+	if p.published != nil {
+		changed := !equality.Semantic.DeepEqual(p.published.Keys, status.Keys) ||
+			!equality.Semantic.DeepEqual(p.published.Errors, status.Errors) ||
+			p.published.PublicKey != status.PublicKey
+		if !changed {
+			if result.Time.Sub(p.published.LastSyncTime.Time) < p.heartbeat {
+				return
+			}
+			status.LastChangeTime = p.published.LastChangeTime
+		}
+	}
+
+	if err := p.publishStatus(context.Background(), &status); err != nil {
+		logrus.Errorf("Unable to publish node status: %v", err)
+		return
+	}
+	p.published = &status
+}
+
+// PublishPublicKey publishes the public key of the node-local keypair before
+// the first sync, so that the KeySync hub can wrap the keys of the first sync
+// to it. The keys and errors published previously are kept.
+func (p *NodeStatusPublisher) PublishPublicKey(ctx context.Context) error {
+	return p.publish(ctx, &v1alpha1.EncKeyNodeStatusStatus{PublicKey: p.publicKey}, func(existing *unstructured.Unstructured) error {
+		return unstructured.SetNestedField(existing.Object, p.publicKey, "status", "publicKey")
+	})
+}
+
+// publishStatus creates or updates the EncKeyNodeStatus of the node with the
+// status
+func (p *NodeStatusPublisher) publishStatus(ctx context.Context, status *v1alpha1.EncKeyNodeStatusStatus) error {
+	return p.publish(ctx, status, func(existing *unstructured.Unstructured) error {
+		obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(status)
+		if err != nil {
+			return err
+		}
+		return unstructured.SetNestedMap(existing.Object, obj, "status")
+	})
+}
+
+// publish creates the EncKeyNodeStatus of the node with the status, or
+// updates it with the update function if it exists
+func (p *NodeStatusPublisher) publish(ctx context.Context, status *v1alpha1.EncKeyNodeStatusStatus, update func(*unstructured.Unstructured) error) error {
+	client := p.dynamicClient.Resource(v1alpha1.EncKeyNodeStatusResource)
+
+	existing, err := client.Get(ctx, p.nodeName, metav1.GetOptions{})
+	if err != nil && !k8serrors.IsNotFound(err) {
+		return err
+	}
+
+	if k8serrors.IsNotFound(err) {
+		// The status is owned by the node, so that it is garbage collected
+		// when the node is removed
+		node, err := p.k8sClient.CoreV1().Nodes().Get(ctx, p.nodeName, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		ns := &v1alpha1.EncKeyNodeStatus{
+			TypeMeta: metav1.TypeMeta{
+				APIVersion: v1alpha1.SchemeGroupVersion.String(),
+				Kind:       "EncKeyNodeStatus",
+			},
+			ObjectMeta: metav1.ObjectMeta{
+				Name: p.nodeName,
+				OwnerReferences: []metav1.OwnerReference{
+					{
+						APIVersion: "v1",
+						Kind:       "Node",
+						Name:       node.GetName(),
+						UID:        node.GetUID(),
+					},
+				},
+			},
+			Status: *status,
+		}
+		obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(ns)
+		if err != nil {
+			return err
+		}
+
+		_, err = client.Create(ctx, &unstructured.Unstructured{Object: obj}, metav1.CreateOptions{})
+		return err
+	}
+
+	if err := update(existing); err != nil {
+		return err
+	}
+
+	_, err = client.Update(ctx, existing, metav1.UpdateOptions{})
+	return err
+}
@@ -0,0 +1,182 @@
+// Copyright 2026 k8s-enc-image-operator authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pgpkey
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/lumjjb/k8s-enc-image-operator/keysync/sechandlers"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// KubeSecretKeyringKey is the key of the private keyring in the kube
+	// secret
+	KubeSecretKeyringKey = "secring.asc"
+
+	// KubeSecretPassphraseKey is the key of the passphrase of the private
+	// keyring in the kube secret, optional
+	KubeSecretPassphraseKey = "passphrase"
+
+	// KubeSecretTrustedKeyringKey is the key of the trusted public keyring
+	// in the kube secret, optional
+	KubeSecretTrustedKeyringKey = "pubring.asc"
+)
+
+// KeyringData is the raw material of the keyrings, armored or binary
+type KeyringData struct {
+	Keyring        []byte
+	Passphrase     []byte
+	TrustedKeyring []byte
+}
+
+// KeyringLoader is a function type that loads the keyring data
+type KeyringLoader func() (*KeyringData, error)
+
+// FileKeyringLoader returns a loader of the keyring data from the files, i.e.
+// node-local files or a mounted secret. The passphrase and trusted keyring
+// files are optional.
+func FileKeyringLoader(keyringFile, passphraseFile, trustedKeyringFile string) KeyringLoader {
+	return func() (*KeyringData, error) {
+		var err error
+		kd := &KeyringData{}
+		if kd.Keyring, err = os.ReadFile(keyringFile); err != nil {
+			return nil, err
+		}
+		if passphraseFile != "" {
+			if kd.Passphrase, err = os.ReadFile(passphraseFile); err != nil {
+				return nil, err
+			}
+		}
+		if trustedKeyringFile != "" {
+			if kd.TrustedKeyring, err = os.ReadFile(trustedKeyringFile); err != nil {
+				return nil, err
+			}
+		}
+		return kd, nil
+	}
+}
+
+// KubeSecretKeyringLoader returns a loader of the keyring data from the kube
+// secret, with the keyring in secring.asc, the optional passphrase in
+// passphrase and the optional trusted keyring in pubring.asc
+func KubeSecretKeyringLoader(clientset kubernetes.Interface, namespace, secretName string) KeyringLoader {
+	return func() (*KeyringData, error) {
+		s, err := clientset.CoreV1().Secrets(namespace).Get(context.Background(), secretName, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		keyring, ok := s.Data[KubeSecretKeyringKey]
+		if !ok {
+			return nil, errors.Errorf("key %s not found in secret %s", KubeSecretKeyringKey, secretName)
+		}
+		return &KeyringData{
+			Keyring:        keyring,
+			Passphrase:     s.Data[KubeSecretPassphraseKey],
+			TrustedKeyring: s.Data[KubeSecretTrustedKeyringKey],
+		}, nil
+	}
+}
+
+// ParseKeyrings parses the private keyring, decrypting its keys with the
+// passphrase, and the trusted keyring if any
+func ParseKeyrings(kd *KeyringData) (openpgp.EntityList, openpgp.EntityList, error) {
+	keyring, err := readKeyring(kd.Keyring)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "unable to read keyring")
+	}
+
+	passphrase := bytes.TrimRight(kd.Passphrase, "\r\n")
+	for _, e := range keyring {
+		if e.PrivateKey == nil {
+			return nil, nil, errors.Errorf("keyring entry %X has no private key", e.PrimaryKey.Fingerprint)
+		}
+		if err := e.DecryptPrivateKeys(passphrase); err != nil {
+			return nil, nil, errors.Wrapf(err, "unable to decrypt private key %X", e.PrimaryKey.Fingerprint)
+		}
+	}
+
+	var trusted openpgp.EntityList
+	if len(kd.TrustedKeyring) > 0 {
+		trusted, err = readKeyring(kd.TrustedKeyring)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "unable to read trusted keyring")
+		}
+	}
+	return keyring, trusted, nil
+}
+
+// readKeyring reads the armored or binary keyring
+func readKeyring(data []byte) (openpgp.EntityList, error) {
+	var el openpgp.EntityList
+	var err error
+	if isArmored(data) {
+		el, err = openpgp.ReadArmoredKeyRing(bytes.NewReader(data))
+	} else {
+		el, err = openpgp.ReadKeyRing(bytes.NewReader(data))
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(el) == 0 {
+		return nil, errors.New("no keys found")
+	}
+	return el, nil
+}
+
+// KeyringThread is a helper function that loads the keyrings and adds the
+// OpenPGP handler to the server, and again whenever the keyrings change, i.e.
+// when a new key is added for rotation. Meant to run as a thread.
+func KeyringThread(load KeyringLoader, adder sechandlers.SecretKeyHandlerAdder, interval time.Duration) {
+	first := true
+	var oldData *KeyringData
+	for {
+		if !first {
+			<-time.After(interval)
+		} else {
+			first = false
+		}
+
+		kd, err := load()
+		if err != nil {
+			logrus.Errorf("Unable to load OpenPGP keyrings: %v", err)
+			continue
+		}
+
+		if oldData != nil && bytes.Equal(kd.Keyring, oldData.Keyring) &&
+			bytes.Equal(kd.Passphrase, oldData.Passphrase) &&
+			bytes.Equal(kd.TrustedKeyring, oldData.TrustedKeyring) {
+			continue
+		}
+
+		keyring, trusted, err := ParseKeyrings(kd)
+		if err != nil {
+			logrus.Errorf("Unable to parse OpenPGP keyrings: %v", err)
+			continue
+		}
+		oldData = kd
+
+		logrus.Printf("New OpenPGP keyrings detected, configuring %d private keys and %d trusted keys...", len(keyring), len(trusted))
+		adder.AddSecretKeyHandler(SecretType, NewPGPSecretKeyHandler(keyring, trusted))
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright 2026 k8s-enc-image-operator authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package pgpkey handles keys delivered as OpenPGP messages, encrypted to a
+// private keyring of the cluster and optionally signed by a trusted sender.
+package pgpkey
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/lumjjb/k8s-enc-image-operator/keysync/sechandlers"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// SecretType is the type of the secrets holding keys encrypted as
+	// OpenPGP messages, one key file per entry
+	SecretType = "pgp-key"
+
+	// messageType is the armor type of OpenPGP messages
+	messageType = "PGP MESSAGE"
+)
+
+// NewPGPSecretKeyHandler returns a secret handler decrypting every entry of the
+// secret, an armored or binary OpenPGP message, with the private keyring into
+// the key file of the same name. If the trusted keyring is not empty, the
+// messages must be signed by one of its keys.
+func NewPGPSecretKeyHandler(keyring, trusted openpgp.EntityList) sechandlers.SecretKeyHandler {
+	return func(data map[string][]byte) (map[string][]byte, error) {
+		if len(data) == 0 {
+			return nil, errors.New("no OpenPGP messages in secret")
+		}
+
+		retdata := map[string][]byte{}
+		for name, message := range data {
+			key, err := decryptMessage(message, keyring, trusted)
+			if err != nil {
+				return nil, errors.Wrapf(err, "unable to decrypt %s", name)
+			}
+			retdata[name] = key
+		}
+		return retdata, nil
+	}
+}
+
+// decryptMessage decrypts the armored or binary OpenPGP message with the
+// keyring, and verifies it is signed by the trusted keyring if not empty
+func decryptMessage(message []byte, keyring, trusted openpgp.EntityList) ([]byte, error) {
+	var r io.Reader = bytes.NewReader(message)
+	if isArmored(message) {
+		block, err := armor.Decode(r)
+		if err != nil {
+			return nil, err
+		}
+		if block.Type != messageType {
+			return nil, errors.Errorf("unexpected armor type %q", block.Type)
+		}
+		r = block.Body
+	}
+
+	// The trusted keys are only used to verify the signature
+	keys := append(openpgp.EntityList{}, keyring...)
+	keys = append(keys, trusted...)
+
+	md, err := openpgp.ReadMessage(r, keys, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if !md.IsEncrypted {
+		return nil, errors.New("message is not encrypted")
+	}
+
+	// The signature and integrity are only checked once the whole body
+	// is read
+	plaintext, err := io.ReadAll(md.UnverifiedBody)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(trusted) == 0 {
+		return plaintext, nil
+	}
+	if !md.IsSigned || md.SignedBy == nil || !isTrusted(md.SignedBy.Entity, trusted) {
+		return nil, errors.New("message is not signed by a trusted key")
+	}
+	if md.SignatureError != nil {
+		return nil, errors.Wrap(md.SignatureError, "invalid signature")
+	}
+	return plaintext, nil
+}
+
+// isTrusted returns whether the entity is one of the trusted entities
+func isTrusted(e *openpgp.Entity, trusted openpgp.EntityList) bool {
+	for _, t := range trusted {
+		if bytes.Equal(e.PrimaryKey.Fingerprint, t.PrimaryKey.Fingerprint) {
+			return true
+		}
+	}
+	return false
+}
+
+// isArmored returns whether the data is ASCII armored
+func isArmored(data []byte) bool {
+	return bytes.HasPrefix(bytes.TrimSpace(data), []byte("-----BEGIN PGP"))
+}
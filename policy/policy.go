@@ -0,0 +1,194 @@
+// Copyright 2026 k8s-enc-image-operator authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package policy implements a declarative secret policy for the key sync,
+// deciding with CEL expressions which secrets may have their keys synced.
+package policy
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/google/cel-go/cel"
+	"github.com/lumjjb/k8s-enc-image-operator/keysync"
+	"github.com/pkg/errors"
+	"sigs.k8s.io/yaml"
+)
+
+// Config is the policy config, a list of rules that every secret must satisfy
+// for its keys to be synced, i.e.
+//
+//	rules:
+//	- name: namespaces
+//	  expression: secret.namespace in ["enc-key-sync", "team-a"]
+//	- name: owner
+//	  expression: '"owner" in secret.labels'
+//	  message: secrets must be labelled with their owner
+//	- name: key-size
+//	  expression: files.all(f, files[f] <= 16384)
+//	- name: namespace-cap
+//	  expression: totals.keys + size(files) <= 20 && totals.bytes + keyBytes <= 65536
+type Config struct {
+	Rules []RuleConfig `json:"rules"`
+}
+
+// RuleConfig is a rule of the policy
+type RuleConfig struct {
+	// Name identifies the rule in the denial reasons
+	Name string `json:"name"`
+
+	// Expression is the CEL expression that must evaluate to true for the
+	// secret to be allowed, with the variables
+	//   secret: name, namespace, type and labels of the secret
+	//   files: map of the key filenames of the secret to their sizes
+	//   keyBytes: total size of the key files of the secret
+	//   totals: keys and bytes, the number and total size of the key
+	//     files of the secrets of the namespace allowed before this one
+	Expression string `json:"expression"`
+
+	// Message is the denial reason, defaults to the expression
+	Message string `json:"message,omitempty"`
+}
+
+// rule is a compiled rule of the policy
+type rule struct {
+	name    string
+	message string
+	program cel.Program
+}
+
+// Policy is a secret policy of compiled CEL rules
+type Policy struct {
+	rules []rule
+}
+
+// LoadPolicyFile loads the policy from a YAML or JSON config file
+func LoadPolicyFile(filename string) (*Policy, error) {
+	data, err := os.ReadFile(filepath.Clean(filename))
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to read policy file %v", filename)
+	}
+
+	var pc Config
+	if err := yaml.UnmarshalStrict(data, &pc); err != nil {
+		return nil, errors.Wrapf(err, "unable to parse policy file %v", filename)
+	}
+	return NewPolicy(pc)
+}
+
+// NewPolicy compiles the rules of the policy config
+func NewPolicy(pc Config) (*Policy, error) {
+	env, err := cel.NewEnv(
+		cel.Variable("secret", cel.MapType(cel.StringType, cel.DynType)),
+		cel.Variable("files", cel.MapType(cel.StringType, cel.IntType)),
+		cel.Variable("keyBytes", cel.IntType),
+		cel.Variable("totals", cel.MapType(cel.StringType, cel.IntType)),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Policy{}
+	names := map[string]bool{}
+	for _, rc := range pc.Rules {
+		if rc.Name == "" {
+			return nil, errors.Errorf("rule %q has no name", rc.Expression)
+		}
+		if names[rc.Name] {
+			return nil, errors.Errorf("duplicate rule %v", rc.Name)
+		}
+		names[rc.Name] = true
+
+		ast, iss := env.Compile(rc.Expression)
+		if iss.Err() != nil {
+			return nil, errors.Wrapf(iss.Err(), "invalid expression of rule %v", rc.Name)
+		}
+		if ast.OutputType() != cel.BoolType {
+			return nil, errors.Errorf("expression of rule %v is not a bool", rc.Name)
+		}
+		program, err := env.Program(ast)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid expression of rule %v", rc.Name)
+		}
+
+		message := rc.Message
+		if message == "" {
+			message = rc.Expression
+		}
+		p.rules = append(p.rules, rule{name: rc.Name, message: message, program: program})
+	}
+	return p, nil
+}
+
+// Evaluate evaluates the rules for each secret, a secret is denied by the
+// first rule it does not satisfy, or whose evaluation fails. The namespace
+// totals only include the secrets allowed before.
+func (p *Policy) Evaluate(secrets []keysync.PolicySecret) []error {
+	errs := make([]error, len(secrets))
+	totals := map[string]map[string]int{}
+	for i, s := range secrets {
+		ns, ok := totals[s.Secret.Namespace]
+		if !ok {
+			ns = map[string]int{"keys": 0, "bytes": 0}
+			totals[s.Secret.Namespace] = ns
+		}
+
+		errs[i] = p.evaluate(s, ns)
+		if errs[i] != nil {
+			continue
+		}
+
+		for _, size := range s.Files {
+			ns["keys"]++
+			ns["bytes"] += size
+		}
+	}
+	return errs
+}
+
+// evaluate returns the reason the secret is denied, or nil if allowed
+func (p *Policy) evaluate(s keysync.PolicySecret, totals map[string]int) error {
+	labels := s.Labels
+	if labels == nil {
+		labels = map[string]string{}
+	}
+
+	keyBytes := 0
+	for _, size := range s.Files {
+		keyBytes += size
+	}
+
+	vars := map[string]interface{}{
+		"secret": map[string]interface{}{
+			"name":      s.Secret.Name,
+			"namespace": s.Secret.Namespace,
+			"type":      s.Secret.Type,
+			"labels":    labels,
+		},
+		"files":    s.Files,
+		"keyBytes": keyBytes,
+		"totals":   totals,
+	}
+
+	for _, r := range p.rules {
+		out, _, err := r.program.Eval(vars)
+		if err != nil {
+			return errors.Wrapf(err, "rule %v", r.name)
+		}
+		if allowed, ok := out.Value().(bool); !ok || !allowed {
+			return errors.Errorf("rule %v: %v", r.name, r.message)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+// Copyright 2026 k8s-enc-image-operator authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package policy
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/lumjjb/k8s-enc-image-operator/apis/v1alpha1"
+	"github.com/lumjjb/k8s-enc-image-operator/keysync"
+)
+
+// TestEvaluate tests the rules over the secret metadata, key sizes and
+// namespace totals
+func TestEvaluate(t *testing.T) {
+	p, err := NewPolicy(Config{Rules: []RuleConfig{
+		{Name: "namespaces", Expression: `secret.namespace in ["team-a", "team-b"]`},
+		{Name: "owner", Expression: `"owner" in secret.labels`, Message: "secrets must be labelled with their owner"},
+		{Name: "key-size", Expression: `files.all(f, files[f] <= 16)`},
+		{Name: "namespace-cap", Expression: `totals.keys + size(files) <= 2 && totals.bytes + keyBytes <= 24`},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	owned := map[string]string{"owner": "me"}
+	secret := func(namespace, name string, labels map[string]string, files map[string]int) keysync.PolicySecret {
+		return keysync.PolicySecret{
+			Secret: v1alpha1.SecretReference{Namespace: namespace, Name: name, Type: "key"},
+			Labels: labels,
+			Files:  files,
+		}
+	}
+
+	secrets := []keysync.PolicySecret{
+		secret("team-a", "a", owned, map[string]int{"k1": 8}),
+		secret("team-a", "b", nil, map[string]int{"k1": 8}),
+		secret("team-a", "c", owned, map[string]int{"k1": 32}),
+		secret("team-a", "d", owned, map[string]int{"k1": 8, "k2": 8}),
+		secret("team-a", "e", owned, map[string]int{"k1": 8}),
+		secret("team-b", "a", owned, map[string]int{"k1": 8, "k2": 8}),
+		secret("team-c", "a", owned, map[string]int{"k1": 8}),
+	}
+	expected := []string{
+		"",
+		"rule owner: secrets must be labelled with their owner",
+		"rule key-size",
+		"rule namespace-cap",
+		"",
+		"",
+		"rule namespaces",
+	}
+
+	errs := p.Evaluate(secrets)
+	for i, err := range errs {
+		if expected[i] == "" {
+			if err != nil {
+				t.Fatalf("Expected %v to be allowed, got %v", secrets[i].Secret, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), expected[i]) {
+			t.Fatalf("Expected %v to be denied by %q, got %v", secrets[i].Secret, expected[i], err)
+		}
+	}
+}
+
+// TestNewPolicyInvalid tests that invalid rules are refused
+func TestNewPolicyInvalid(t *testing.T) {
+	for _, rc := range []RuleConfig{
+		{Name: "syntax", Expression: `secret.name ==`},
+		{Name: "not-bool", Expression: `secret.name`},
+		{Name: "unknown", Expression: `pod.name == "x"`},
+		{Expression: `true`},
+	} {
+		if _, err := NewPolicy(Config{Rules: []RuleConfig{rc}}); err == nil {
+			t.Fatalf("Rule %v should be invalid", rc)
+		}
+	}
+}
@@ -0,0 +1,346 @@
+// Copyright 2026 k8s-enc-image-operator authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package secsign verifies detached signatures over key secrets, so that only
+// keys signed by a trusted signing key are synced to the nodes, and not any key
+// injected by someone allowed to create secrets in the namespace.
+package secsign
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+const (
+	// SignatureAnnotation is the annotation holding the base64 encoded
+	// signature of the secret
+	SignatureAnnotation = "oci.crypt/signature"
+
+	// SignatureField is the data field holding the base64 encoded signature
+	// of the secret, if it is not annotated. It is not part of the signed
+	// payload.
+	SignatureField = ".signature"
+
+	// AnnotationPrefix is the prefix of the annotations of the secret that
+	// are part of the signed payload, i.e. the validity window, node selector
+	// and delivery annotations
+	AnnotationPrefix = "oci.crypt/"
+)
+
+// Reasons of verification failures, used in the events and metrics
+const (
+	// ReasonUnsigned is the reason of secrets without signature
+	ReasonUnsigned = "Unsigned"
+
+	// ReasonInvalidSignature is the reason of secrets whose signature is
+	// not valid for any of the trusted keys
+	ReasonInvalidSignature = "InvalidSignature"
+)
+
+// verificationFailures counts the secrets that failed verification
+var verificationFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "enc_key_sync_secret_verification_failures_total",
+	Help: "Number of times a key secret was refused because its signature could not be verified.",
+}, []string{"namespace", "secret", "reason"})
+
+func init() {
+	prometheus.MustRegister(verificationFailures)
+}
+
+// VerificationError is the error of a secret that failed verification
+type VerificationError struct {
+	// Reason is ReasonUnsigned or ReasonInvalidSignature
+	Reason string
+
+	// Err describes the failure
+	Err error
+}
+
+func (e *VerificationError) Error() string {
+	return e.Err.Error()
+}
+
+// VerifierConfig contains the parameters of the verifier
+type VerifierConfig struct {
+	// PublicKeys are the trusted signing keys, a signature by any of them
+	// is accepted
+	PublicKeys []crypto.PublicKey
+
+	// Recorder records the verification failures as events of the secrets,
+	// if nil no events are recorded
+	Recorder record.EventRecorder
+}
+
+// Verifier verifies the signatures of secrets
+type Verifier struct {
+	// publicKeys are the trusted signing keys
+	publicKeys []crypto.PublicKey
+
+	// recorder records the verification failures as events
+	recorder record.EventRecorder
+}
+
+// NewVerifier returns a verifier of the signatures of secrets
+func NewVerifier(vc VerifierConfig) (*Verifier, error) {
+	if len(vc.PublicKeys) == 0 {
+		return nil, errors.New("no trusted public keys")
+	}
+	for _, pub := range vc.PublicKeys {
+		switch pub.(type) {
+		case *ecdsa.PublicKey, ed25519.PublicKey, *rsa.PublicKey:
+		default:
+			return nil, errors.Errorf("unsupported public key type %T", pub)
+		}
+	}
+
+	return &Verifier{
+		publicKeys: vc.PublicKeys,
+		recorder:   vc.Recorder,
+	}, nil
+}
+
+// Verify verifies that the secret is signed by one of the trusted keys. A
+// failure is logged, counted and recorded as an event of the secret, and
+// returned as a *VerificationError.
+func (v *Verifier) Verify(s *corev1.Secret) error {
+	err := v.Check(s)
+	if err == nil {
+		return nil
+	}
+	verr := err.(*VerificationError)
+
+	logrus.Warnf("Refusing secret %s/%s: %v", s.GetNamespace(), s.GetName(), verr.Err)
+	verificationFailures.WithLabelValues(s.GetNamespace(), s.GetName(), verr.Reason).Inc()
+	if v.recorder != nil {
+		v.recorder.Eventf(s, corev1.EventTypeWarning, verr.Reason, "Key secret refused: %v", verr.Err)
+	}
+	return verr
+}
+
+// Check verifies that the secret is signed by one of the trusted keys like
+// Verify, without logging, counting or recording a failure, i.e. for a dry
+// run. A failure is returned as a *VerificationError.
+func (v *Verifier) Check(s *corev1.Secret) error {
+	err := v.verify(s)
+	if err == nil {
+		return nil
+	}
+
+	verr := &VerificationError{Reason: ReasonInvalidSignature, Err: err}
+	if errors.Is(err, errUnsigned) {
+		verr.Reason = ReasonUnsigned
+	}
+	return verr
+}
+
+// errUnsigned is the error of secrets without signature
+var errUnsigned = errors.New("secret is not signed")
+
+// verify verifies the signature of the secret
+func (v *Verifier) verify(s *corev1.Secret) error {
+	sig, err := signature(s)
+	if err != nil {
+		return err
+	}
+
+	payload, err := Payload(s)
+	if err != nil {
+		return err
+	}
+
+	for _, pub := range v.publicKeys {
+		if verifySignature(pub, payload, sig) {
+			return nil
+		}
+	}
+	return errors.New("signature is not valid for any trusted key")
+}
+
+// signature returns the base64 decoded signature of the secret from the
+// annotation or the data field
+func signature(s *corev1.Secret) ([]byte, error) {
+	sig, ok := s.GetAnnotations()[SignatureAnnotation]
+	if !ok {
+		data, ok := s.Data[SignatureField]
+		if !ok {
+			return nil, errUnsigned
+		}
+		sig = string(data)
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(sig))
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid signature encoding")
+	}
+	return decoded, nil
+}
+
+// SignedData returns the data of the secret without the signature field, the
+// data covered by the signature, to be processed by the key handlers
+func SignedData(s *corev1.Secret) map[string][]byte {
+	data := map[string][]byte{}
+	for k, v := range s.Data {
+		if k != SignatureField {
+			data[k] = v
+		}
+	}
+	return data
+}
+
+// Payload returns the payload signed for the secret, the compact JSON encoding
+// of its annotations prefixed with AnnotationPrefix except the signature, its
+// data without the signature field with base64 encoded values, its name,
+// namespace and type, with keys in sorted order, i.e.
+// {"annotations":{"oci.crypt/not-after":"2027-01-01T00:00:00Z"},"data":{"mykey":"<base64>"},"name":"my-secret","namespace":"enc-key-sync","type":"key"}
+// so that a signed secret cannot be moved to another namespace or retargeted.
+// The secret must have a name and a namespace.
+func Payload(s *corev1.Secret) ([]byte, error) {
+	if s.GetName() == "" || s.GetNamespace() == "" {
+		return nil, errors.New("secret must have a name and a namespace to be signed")
+	}
+
+	annotations := map[string]string{}
+	for k, v := range s.GetAnnotations() {
+		if strings.HasPrefix(k, AnnotationPrefix) && k != SignatureAnnotation {
+			annotations[k] = v
+		}
+	}
+
+	// Maps are encoded with sorted keys, so the encoding is canonical, and
+	// like jq -cS without escaping of HTML characters, i.e. in selectors
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	err := enc.Encode(struct {
+		Annotations map[string]string `json:"annotations"`
+		Data        map[string][]byte `json:"data"`
+		Name        string            `json:"name"`
+		Namespace   string            `json:"namespace"`
+		Type        string            `json:"type"`
+	}{
+		Annotations: annotations,
+		Data:        SignedData(s),
+		Name:        s.GetName(),
+		Namespace:   s.GetNamespace(),
+		Type:        string(s.Type),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// verifySignature verifies the signature of the payload, like cosign ECDSA and
+// RSA signatures are over the SHA-256 digest, and Ed25519 signatures over the
+// payload itself
+func verifySignature(pub crypto.PublicKey, payload, sig []byte) bool {
+	digest := sha256.Sum256(payload)
+	switch k := pub.(type) {
+	case *ecdsa.PublicKey:
+		return ecdsa.VerifyASN1(k, digest[:], sig)
+	case ed25519.PublicKey:
+		return ed25519.Verify(k, payload, sig)
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], sig) == nil
+	default:
+		return false
+	}
+}
+
+// Sign signs the secret with the private key and returns the base64 encoded
+// signature, for the annotation or the data field
+func Sign(s *corev1.Secret, priv crypto.Signer) (string, error) {
+	payload, err := Payload(s)
+	if err != nil {
+		return "", err
+	}
+
+	var sig []byte
+	switch priv.Public().(type) {
+	case ed25519.PublicKey:
+		sig, err = priv.Sign(rand.Reader, payload, crypto.Hash(0))
+	case *ecdsa.PublicKey, *rsa.PublicKey:
+		digest := sha256.Sum256(payload)
+		sig, err = priv.Sign(rand.Reader, digest[:], crypto.SHA256)
+	default:
+		return "", errors.Errorf("unsupported private key type %T", priv)
+	}
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(sig), nil
+}
+
+// NewEventRecorder returns a recorder of the events of the objects in the
+// namespace, i.e. to record the verification failures of its secrets
+func NewEventRecorder(clientset kubernetes.Interface, namespace, component, host string) record.EventRecorder {
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: clientset.CoreV1().Events(namespace)})
+	return broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: component, Host: host})
+}
+
+// LoadPublicKeysFile loads the PEM encoded public keys of the file, i.e. a
+// cosign.pub file or several concatenated
+func LoadPublicKeysFile(filename string) ([]crypto.PublicKey, error) {
+	data, err := os.ReadFile(filepath.Clean(filename))
+	if err != nil {
+		return nil, err
+	}
+	return ParsePublicKeys(data)
+}
+
+// ParsePublicKeys parses the PEM encoded public keys
+func ParsePublicKeys(data []byte) ([]crypto.PublicKey, error) {
+	keys := []crypto.PublicKey{}
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "PUBLIC KEY" {
+			continue
+		}
+		pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, pub)
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no public keys found")
+	}
+	return keys, nil
+}
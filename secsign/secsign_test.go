@@ -0,0 +1,229 @@
+// Copyright 2026 k8s-enc-image-operator authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package secsign
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+// TestVerify tests that secrets signed by a trusted key are accepted, in the
+// annotation or the data field, and that unsigned, tampered and otherwise
+// signed secrets are refused with an event
+func TestVerify(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, untrusted, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Load the trusted keys like a PEM file
+	pemData := []byte{}
+	for _, pub := range []crypto.PublicKey{ecKey.Public(), rsaKey.Public(), edKey.Public()} {
+		der, err := x509.MarshalPKIXPublicKey(pub)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pemData = append(pemData, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})...)
+	}
+	keys, err := ParsePublicKeys(pemData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 3 {
+		t.Fatalf("Expected 3 keys, got %d", len(keys))
+	}
+
+	recorder := record.NewFakeRecorder(10)
+	v, err := NewVerifier(VerifierConfig{PublicKeys: keys, Recorder: recorder})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newSecret := func() *corev1.Secret {
+		return &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "my-secret", Namespace: "enc-key-sync"},
+			Data:       map[string][]byte{"mykey": []byte("this is a key")},
+			Type:       "key",
+		}
+	}
+
+	for _, signer := range []crypto.Signer{ecKey, rsaKey, edKey} {
+		s := newSecret()
+		sig, err := Sign(s, signer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.Annotations = map[string]string{SignatureAnnotation: sig}
+		if err := v.Verify(s); err != nil {
+			t.Fatalf("Secret signed with %T should be accepted: %v", signer, err)
+		}
+
+		// The signature in the data field is not part of the payload
+		s = newSecret()
+		s.Data[SignatureField] = []byte(sig)
+		if err := v.Verify(s); err != nil {
+			t.Fatalf("Secret signed with %T in the data field should be accepted: %v", signer, err)
+		}
+	}
+
+	expectRefused := func(s *corev1.Secret, reason string) {
+		// Checking refuses the secret without an event
+		var cerr *VerificationError
+		if err := v.Check(s); !errors.As(err, &cerr) || cerr.Reason != reason {
+			t.Fatalf("Expected check to refuse with reason %s, got %v", reason, err)
+		}
+		select {
+		case event := <-recorder.Events:
+			t.Fatalf("Unexpected event of the check %q", event)
+		default:
+		}
+
+		err := v.Verify(s)
+		var verr *VerificationError
+		if !errors.As(err, &verr) || verr.Reason != reason {
+			t.Fatalf("Expected refusal with reason %s, got %v", reason, err)
+		}
+		select {
+		case event := <-recorder.Events:
+			if event != "Warning "+reason+" Key secret refused: "+err.Error() {
+				t.Fatalf("Unexpected event %q", event)
+			}
+		default:
+			t.Fatal("Expected an event for the refused secret")
+		}
+	}
+
+	expectRefused(newSecret(), ReasonUnsigned)
+
+	s := newSecret()
+	sig, err := Sign(s, ecKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Annotations = map[string]string{SignatureAnnotation: sig}
+	s.Data["mykey"] = []byte("injected key")
+	expectRefused(s, ReasonInvalidSignature)
+
+	s = newSecret()
+	sig, err = Sign(s, ecKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Annotations = map[string]string{SignatureAnnotation: sig}
+	s.Type = "kp-key"
+	expectRefused(s, ReasonInvalidSignature)
+
+	s = newSecret()
+	sig, err = Sign(s, untrusted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Annotations = map[string]string{SignatureAnnotation: sig}
+	expectRefused(s, ReasonInvalidSignature)
+
+	// The namespace, name and oci.crypt annotations are signed, other
+	// annotations are not
+	newAnnotatedSecret := func() *corev1.Secret {
+		s := newSecret()
+		s.Annotations = map[string]string{
+			"oci.crypt/not-after":     "2027-01-01T00:00:00Z",
+			"oci.crypt/node-selector": "zone=a",
+		}
+		sig, err := Sign(s, edKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.Annotations[SignatureAnnotation] = sig
+		return s
+	}
+
+	s = newAnnotatedSecret()
+	s.Annotations["kubectl.kubernetes.io/last-applied-configuration"] = "{}"
+	if err := v.Verify(s); err != nil {
+		t.Fatalf("Secret with other annotations should be accepted: %v", err)
+	}
+
+	for name, modify := range map[string]func(*corev1.Secret){
+		"namespace":     func(s *corev1.Secret) { s.Namespace = "other" },
+		"name":          func(s *corev1.Secret) { s.Name = "other" },
+		"not-after":     func(s *corev1.Secret) { delete(s.Annotations, "oci.crypt/not-after") },
+		"node-selector": func(s *corev1.Secret) { s.Annotations["oci.crypt/node-selector"] = "zone=b" },
+		"delivery":      func(s *corev1.Secret) { s.Annotations["oci.crypt/file-mode"] = "0644" },
+	} {
+		s = newAnnotatedSecret()
+		modify(s)
+		if err := v.Verify(s); err == nil {
+			t.Fatalf("Secret with modified %s should be refused", name)
+		}
+		<-recorder.Events
+	}
+}
+
+// TestPayload tests the documented format of the signed payload
+func TestPayload(t *testing.T) {
+	s := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "my-secret",
+			Namespace: "enc-key-sync",
+			Annotations: map[string]string{
+				"oci.crypt/node-selector": "gen>1",
+				SignatureAnnotation:       "c2lnbmF0dXJl",
+				"other":                   "unsigned",
+			},
+		},
+		Data: map[string][]byte{
+			"mykey":        []byte("this is a key"),
+			SignatureField: []byte("c2lnbmF0dXJl"),
+		},
+		Type: "key",
+	}
+	payload, err := Payload(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"annotations":{"oci.crypt/node-selector":"gen>1"},"data":{"mykey":"dGhpcyBpcyBhIGtleQ=="},"name":"my-secret","namespace":"enc-key-sync","type":"key"}`
+	if string(payload) != expected {
+		t.Fatalf("Expected payload %s, got %s", expected, payload)
+	}
+
+	s.Namespace = ""
+	if _, err := Payload(s); err == nil {
+		t.Fatal("Secret without namespace should not be signed")
+	}
+}
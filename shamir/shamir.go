@@ -0,0 +1,150 @@
+// Copyright 2026 k8s-enc-image-operator authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package shamir implements Shamir's secret sharing over GF(2^8), so that a key
+// can be split into shares of which a threshold is needed to reconstruct it.
+// Each share is the evaluation of a random polynomial per secret byte, followed
+// by the x coordinate of the share as the last byte.
+package shamir
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+
+	"github.com/pkg/errors"
+)
+
+// Split splits the secret into the number of parts, any threshold of which
+// reconstruct the secret
+func Split(secret []byte, parts, threshold int) ([][]byte, error) {
+	if len(secret) == 0 {
+		return nil, errors.New("cannot split an empty secret")
+	}
+	if threshold < 2 || parts < threshold || parts > 255 {
+		return nil, errors.Errorf("invalid threshold %d of %d parts, must be 2 <= threshold <= parts <= 255", threshold, parts)
+	}
+
+	shares := make([][]byte, parts)
+	for i := range shares {
+		shares[i] = make([]byte, len(secret)+1)
+		shares[i][len(secret)] = byte(i + 1)
+	}
+
+	coefficients := make([]byte, threshold)
+	for i, b := range secret {
+		// The constant term is the secret byte, the others are random
+		coefficients[0] = b
+		if _, err := rand.Read(coefficients[1:]); err != nil {
+			return nil, err
+		}
+		for _, share := range shares {
+			share[i] = evaluate(coefficients, share[len(secret)])
+		}
+	}
+	return shares, nil
+}
+
+// Combine reconstructs the secret from the shares. The result is only correct
+// if at least the threshold of shares the secret was split with are given.
+func Combine(shares [][]byte) ([]byte, error) {
+	if len(shares) < 2 {
+		return nil, errors.New("at least 2 shares are required")
+	}
+
+	length := len(shares[0])
+	if length < 2 {
+		return nil, errors.New("shares are too short")
+	}
+	xs := make([]byte, len(shares))
+	for i, share := range shares {
+		if len(share) != length {
+			return nil, errors.New("shares have different lengths")
+		}
+		xs[i] = share[length-1]
+		if xs[i] == 0 {
+			return nil, errors.New("invalid share x coordinate 0")
+		}
+		for j := 0; j < i; j++ {
+			if xs[j] == xs[i] {
+				return nil, errors.Errorf("duplicate share %d", xs[i])
+			}
+		}
+	}
+
+	secret := make([]byte, length-1)
+	ys := make([]byte, len(shares))
+	for i := range secret {
+		for j, share := range shares {
+			ys[j] = share[i]
+		}
+		secret[i] = interpolateAtZero(xs, ys)
+	}
+	return secret, nil
+}
+
+// evaluate evaluates the polynomial with the coefficients, lowest degree
+// first, at x using Horner's method
+func evaluate(coefficients []byte, x byte) byte {
+	var y byte
+	for i := len(coefficients) - 1; i >= 0; i-- {
+		y = add(mul(y, x), coefficients[i])
+	}
+	return y
+}
+
+// interpolateAtZero returns the value at 0 of the Lagrange polynomial through
+// the points
+func interpolateAtZero(xs, ys []byte) byte {
+	var result byte
+	for i := range xs {
+		basis := byte(1)
+		for j := range xs {
+			if i == j {
+				continue
+			}
+			// x_j / (x_j - x_i), subtraction is addition in GF(2^8)
+			basis = mul(basis, div(xs[j], add(xs[j], xs[i])))
+		}
+		result = add(result, mul(ys[i], basis))
+	}
+	return result
+}
+
+// add adds in GF(2^8)
+func add(a, b byte) byte {
+	return a ^ b
+}
+
+// mul multiplies in GF(2^8) with the AES polynomial, in constant time
+func mul(a, b byte) byte {
+	var p byte
+	for i := 0; i < 8; i++ {
+		p ^= byte(subtle.ConstantTimeByteEq(b&1, 1)) * a
+		carry := a >> 7
+		a <<= 1
+		a ^= carry * 0x1b
+		b >>= 1
+	}
+	return p
+}
+
+// div divides in GF(2^8), b must not be 0
+func div(a, b byte) byte {
+	// b^254 is the inverse of b
+	inv := b
+	for i := 0; i < 6; i++ {
+		inv = mul(mul(inv, inv), b)
+	}
+	return mul(a, mul(inv, inv))
+}
@@ -0,0 +1,221 @@
+// Copyright 2026 k8s-enc-image-operator authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/lumjjb/k8s-enc-image-operator/keysync/sechandlers"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// plaintextKeySecretType is the type of secrets holding plaintext keys
+	plaintextKeySecretType = "key"
+
+	// maxRequestSize is the maximum size of an admission review request
+	// that will be read
+	maxRequestSize = 3 * 1024 * 1024
+)
+
+// KeyWrapWebhookConfig contains the parameters required for operation of the
+// key wrapping webhook
+type KeyWrapWebhookConfig struct {
+	// Namespaces specifies the namespaces where plaintext key secrets are
+	// wrapped, secrets in other namespaces are admitted unchanged
+	Namespaces []string
+
+	// WrappedSecretType specifies the type that wrapped secrets are
+	// rewritten to, i.e. "kp-key"
+	WrappedSecretType string
+
+	// KeyWrapper wraps the plaintext key into the secret data of the
+	// wrapped secret type
+	KeyWrapper sechandlers.SecretKeyWrapper
+}
+
+// KeyWrapWebhook is a mutating admission webhook that intercepts the creation
+// of plaintext key secrets and rewrites them into wrapped key secrets, and
+// denies updates adding plaintext keys to existing secrets, so that plaintext
+// keys are never persisted.
+type KeyWrapWebhook struct {
+	// namespaces specifies the namespaces where plaintext key secrets are wrapped
+	namespaces map[string]bool
+
+	// wrappedSecretType specifies the type that wrapped secrets are rewritten to
+	wrappedSecretType string
+
+	// keyWrapper wraps the plaintext key into the secret data of the
+	// wrapped secret type
+	keyWrapper sechandlers.SecretKeyWrapper
+}
+
+// jsonPatchOp is a single JSON patch operation as per RFC 6902
+type jsonPatchOp struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+func NewKeyWrapWebhook(kwc KeyWrapWebhookConfig) *KeyWrapWebhook {
+	wh := KeyWrapWebhook{
+		namespaces:        map[string]bool{},
+		wrappedSecretType: kwc.WrappedSecretType,
+		keyWrapper:        kwc.KeyWrapper,
+	}
+
+	for _, ns := range kwc.Namespaces {
+		wh.namespaces[ns] = true
+	}
+
+	return &wh
+}
+
+// ServeHTTP handles admission review requests for secrets
+func (wh *KeyWrapWebhook) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxRequestSize))
+	if err != nil {
+		http.Error(w, "unable to read request", http.StatusBadRequest)
+		return
+	}
+
+	var review admissionv1.AdmissionReview
+	if err := json.Unmarshal(body, &review); err != nil || review.Request == nil {
+		http.Error(w, "invalid admission review", http.StatusBadRequest)
+		return
+	}
+
+	review.Response = wh.admit(review.Request)
+	review.Response.UID = review.Request.UID
+	review.Request = nil
+
+	resp, err := json.Marshal(review)
+	if err != nil {
+		http.Error(w, "unable to encode admission review", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := w.Write(resp); err != nil {
+		logrus.Errorf("Unable to write admission response: %v", err)
+	}
+}
+
+// admit returns the admission response for the request, plaintext key secrets
+// created in the configured namespaces are patched into wrapped key secrets,
+// updates changing the keys of plaintext key secrets are denied, all other
+// requests are allowed unchanged
+func (wh *KeyWrapWebhook) admit(req *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse {
+	allowed := &admissionv1.AdmissionResponse{Allowed: true}
+
+	if (req.Operation != admissionv1.Create && req.Operation != admissionv1.Update) || req.Kind.Kind != "Secret" {
+		return allowed
+	}
+
+	var secret corev1.Secret
+	if err := json.Unmarshal(req.Object.Raw, &secret); err != nil {
+		return denied(fmt.Sprintf("unable to decode secret: %v", err))
+	}
+
+	if secret.Type != plaintextKeySecretType || !wh.namespaces[req.Namespace] {
+		return allowed
+	}
+
+	// The type of a secret is immutable, so keys added to an existing
+	// plaintext key secret cannot be wrapped
+	if req.Operation == admissionv1.Update {
+		var old corev1.Secret
+		if err := json.Unmarshal(req.OldObject.Raw, &old); err != nil {
+			return denied(fmt.Sprintf("unable to decode secret: %v", err))
+		}
+		if sameData(old.Data, secret.Data) {
+			return allowed
+		}
+		logrus.Warnf("Denied update of the keys of plaintext key secret %s/%s", req.Namespace, secret.GetName())
+		return denied("the keys of a plaintext key secret cannot be changed, delete and recreate the secret to have it wrapped")
+	}
+
+	patch, err := wh.wrapSecretPatch(&secret)
+	if err != nil {
+		logrus.Errorf("Unable to wrap key secret %s/%s: %v", req.Namespace, secret.GetName(), err)
+		return denied(fmt.Sprintf("unable to wrap key secret: %v", err))
+	}
+
+	logrus.Printf("Wrapped key secret %s/%s into type %s", req.Namespace, secret.GetName(), wh.wrappedSecretType)
+
+	patchType := admissionv1.PatchTypeJSONPatch
+	return &admissionv1.AdmissionResponse{
+		Allowed:   true,
+		Patch:     patch,
+		PatchType: &patchType,
+	}
+}
+
+// wrapSecretPatch wraps the key of the secret and returns the JSON patch that
+// rewrites the secret into the wrapped secret type
+func (wh *KeyWrapWebhook) wrapSecretPatch(secret *corev1.Secret) ([]byte, error) {
+	// The wrapped secret formats hold a single key per secret
+	if len(secret.Data) != 1 {
+		return nil, errors.Errorf("secret must contain exactly 1 key to be wrapped, has %d", len(secret.Data))
+	}
+
+	var key []byte
+	for _, v := range secret.Data {
+		key = v
+	}
+
+	wrapped, err := wh.keyWrapper(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal([]jsonPatchOp{
+		{Op: "replace", Path: "/type", Value: wh.wrappedSecretType},
+		{Op: "replace", Path: "/data", Value: wrapped},
+	})
+}
+
+// sameData returns whether the data of two secrets are the same
+func sameData(a, b map[string][]byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if w, ok := b[k]; !ok || !bytes.Equal(v, w) {
+			return false
+		}
+	}
+	return true
+}
+
+// denied returns an admission response rejecting the request with message
+func denied(message string) *admissionv1.AdmissionResponse {
+	return &admissionv1.AdmissionResponse{
+		Allowed: false,
+		Result: &metav1.Status{
+			Status:  metav1.StatusFailure,
+			Message: message,
+		},
+	}
+}
@@ -0,0 +1,169 @@
+// Copyright 2026 k8s-enc-image-operator authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// reverseKeyWrapper is a test wrapper that reverses the key bytes
+func reverseKeyWrapper(key []byte) (map[string][]byte, error) {
+	ct := make([]byte, len(key))
+	for i, b := range key {
+		ct[len(key)-1-i] = b
+	}
+	return map[string][]byte{
+		"rootkeyid":  []byte("root"),
+		"ciphertext": ct,
+	}, nil
+}
+
+// review sends the creation of the secret, or its update if the old secret is
+// not nil, as an admission review to the webhook and returns the response
+func review(t *testing.T, wh *KeyWrapWebhook, namespace string, secret, old *corev1.Secret) *admissionv1.AdmissionResponse {
+	raw, err := json.Marshal(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	operation := admissionv1.Create
+	var oldObject runtime.RawExtension
+	if old != nil {
+		operation = admissionv1.Update
+		if oldObject.Raw, err = json.Marshal(old); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ar := admissionv1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "admission.k8s.io/v1",
+			Kind:       "AdmissionReview",
+		},
+		Request: &admissionv1.AdmissionRequest{
+			UID:       types.UID("test-uid"),
+			Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "Secret"},
+			Namespace: namespace,
+			Operation: operation,
+			Object:    runtime.RawExtension{Raw: raw},
+			OldObject: oldObject,
+		},
+	}
+	body, err := json.Marshal(ar)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	wh.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/wrap", bytes.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Unexpected status code %v", rec.Code)
+	}
+
+	var resp admissionv1.AdmissionReview
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Response == nil || resp.Response.UID != "test-uid" {
+		t.Fatalf("Invalid admission response: %+v", resp.Response)
+	}
+	return resp.Response
+}
+
+// TestKeyWrapWebhook runs through wrapping of plaintext key secrets in
+// designated and other namespaces
+func TestKeyWrapWebhook(t *testing.T) {
+	wh := NewKeyWrapWebhook(KeyWrapWebhookConfig{
+		Namespaces:        []string{"enc-key-sync"},
+		WrappedSecretType: "kp-key",
+		KeyWrapper:        reverseKeyWrapper,
+	})
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "my-secret",
+		},
+		Data: map[string][]byte{
+			"mykey": []byte("this is a key"),
+		},
+		Type: "key",
+	}
+
+	// Secrets outside the designated namespaces are not changed
+	resp := review(t, wh, "default", secret, nil)
+	if !resp.Allowed || resp.Patch != nil {
+		t.Fatalf("Secret in other namespace should be allowed unchanged")
+	}
+
+	// Secrets in the designated namespaces are rewritten
+	resp = review(t, wh, "enc-key-sync", secret, nil)
+	if !resp.Allowed || resp.Patch == nil {
+		t.Fatalf("Secret should be allowed with patch")
+	}
+
+	var patch []struct {
+		Op    string          `json:"op"`
+		Path  string          `json:"path"`
+		Value json.RawMessage `json:"value"`
+	}
+	if err := json.Unmarshal(resp.Patch, &patch); err != nil {
+		t.Fatal(err)
+	}
+	if len(patch) != 2 || patch[0].Path != "/type" || string(patch[0].Value) != `"kp-key"` {
+		t.Fatalf("Unexpected patch: %s", string(resp.Patch))
+	}
+
+	var data map[string][]byte
+	if err := json.Unmarshal(patch[1].Value, &data); err != nil {
+		t.Fatal(err)
+	}
+	if string(data["ciphertext"]) != "yek a si siht" || string(data["rootkeyid"]) != "root" {
+		t.Fatalf("Unexpected wrapped data: %v", data)
+	}
+
+	// Secrets with multiple keys cannot be represented as a wrapped secret
+	old := secret.DeepCopy()
+	secret.Data["otherkey"] = []byte("this is another key")
+	resp = review(t, wh, "enc-key-sync", secret, nil)
+	if resp.Allowed {
+		t.Fatalf("Secret with multiple keys should be denied")
+	}
+
+	// Plaintext keys cannot be added to an existing secret, whose type
+	// cannot be changed
+	resp = review(t, wh, "enc-key-sync", secret, old)
+	if resp.Allowed {
+		t.Fatalf("Update adding a plaintext key should be denied")
+	}
+
+	// Updates not changing the keys, i.e. of the labels of a plaintext key
+	// secret created before the webhook, are allowed unchanged
+	labeled := old.DeepCopy()
+	labeled.Labels = map[string]string{"team": "a"}
+	resp = review(t, wh, "enc-key-sync", labeled, old)
+	if !resp.Allowed || resp.Patch != nil {
+		t.Fatalf("Update not changing the keys should be allowed unchanged")
+	}
+}